@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/sirupsen/logrus"
+)
+
+// CONTENT OF A SESSION TOKEN ISSUED BY THE ECHO APP ON LOGIN. THE TOKEN IS [BASE64(CLAIMS)].[BASE64(HMAC-SHA256(CLAIMS))]
+// SIGNED WITH THE auth.secret SHARED BY BOTH APPS. Shares ARE THE USERS THAT SHARED THEIR CONVERSATION WITH User
+type TokenClaims struct {
+	User    string   `json:"user"`
+	Roles   []string `json:"roles,omitempty"`
+	Shares  []string `json:"shares,omitempty"`
+	Expires int64    `json:"exp"`
+}
+
+// ADMINS CAN READ EVERY CONVERSATION AND MANAGE THE SEARCH JOBS OF EVERYBODY. AUDITORS CAN ONLY READ
+const (
+	RoleAdmin   = "admin"
+	RoleAuditor = "auditor"
+)
+
+func (claims TokenClaims) HasRole(role string) bool {
+	for _, r := range claims.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// TRUE IF THE USER MAY SEARCH OR READ THE CONVERSATION OF target: ITS OWN, ONE SHARED WITH IT OR ANY IF ADMIN OR AUDITOR
+func (claims TokenClaims) CanAccess(target string) bool {
+	if target == claims.User || claims.HasRole(RoleAdmin) || claims.HasRole(RoleAuditor) {
+		return true
+	}
+	for _, owner := range claims.Shares {
+		if owner == target {
+			return true
+		}
+	}
+	return false
+}
+
+// TRUE IF THE USER MAY READ, REFINE OR CANCEL A SEARCH JOB: ONLY THE ONE THAT SUBMITTED IT OR AN ADMIN
+func (claims TokenClaims) CanUseJob(job *SearchJob) bool {
+	return job.Owner == claims.User || claims.HasRole(RoleAdmin)
+}
+
+var tokenSecret []byte
+
+// RETURNS THE CLAIMS OF A TOKEN. FAILS IF THE TOKEN IS MALFORMED, FORGED OR EXPIRED
+func VerifyToken(token string) (TokenClaims, error) {
+	var claims TokenClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return claims, fmt.Errorf("Malformed token")
+	}
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal([]byte(parts[1]), []byte(base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))) {
+		return claims, fmt.Errorf("Invalid token signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, fmt.Errorf("Malformed token: %v", err)
+	}
+	err = json.Unmarshal(b, &claims)
+	if err != nil || claims.User == "" {
+		return claims, fmt.Errorf("Malformed token")
+	}
+	if time.Now().Unix() > claims.Expires {
+		return claims, fmt.Errorf("Token expired, log in again")
+	}
+	return claims, nil
+}
+
+// RETURNS WHO SENT A MESSAGE ACCORDING TO ITS token ATTRIBUTE
+func Authenticate(msg *sqs.Message) (TokenClaims, error) {
+	token := GetAttribute(msg, "token")
+	if token == "" {
+		return TokenClaims{}, fmt.Errorf("Not logged in")
+	}
+	return VerifyToken(token)
+}
+
+// ANSWERS A MESSAGE THAT COULD NOT BE AUTHENTICATED OR AUTHORIZED WITH THE REASON IN THE error ATTRIBUTE
+func SendUnauthorized(msg *sqs.Message, reason error) error {
+	return SendRejected(msg, "UNAUTHORIZED", reason.Error(), 0)
+}
+
+// ANSWERS A REJECTED MESSAGE WITH body AND THE REASON IN THE error ATTRIBUTE. REJECTIONS THAT WILL PASS LATER (RATE
+// LIMITS) ALSO CARRY THE SECONDS TO WAIT BEFORE TRYING AGAIN IN retryAfter
+func SendRejected(msg *sqs.Message, body string, reason string, wait time.Duration) error {
+	msgTX := &sqs.SendMessageInput{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"clientName": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(*msg.MessageAttributes["clientName"].StringValue),
+			},
+			"sessionID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(*msg.MessageAttributes["sessionID"].StringValue),
+			},
+			"timestamp": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(*msg.MessageAttributes["timestamp"].StringValue),
+			},
+			"cmd": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(*msg.MessageAttributes["cmd"].StringValue),
+			},
+			"error": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(reason),
+			},
+		},
+		MessageBody: aws.String(body),
+		QueueUrl:    &outboxURL,
+	}
+	if wait > 0 {
+		msgTX.MessageAttributes["retryAfter"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("Number"),
+			StringValue: aws.String(strconv.Itoa(RetrySeconds(wait))),
+		}
+	}
+	result, err := sqssvc.SendMessage(msgTX)
+	if err != nil {
+		log.Errorf("Could not send message to SQS queue: %v", err)
+	} else {
+		log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+	}
+	return nil
+}
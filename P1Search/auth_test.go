@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TOKEN SIGNED THE WAY THE ECHO APP DOES IT
+func signedToken(t *testing.T, secret string, claims TokenClaims) string {
+	b, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyToken(t *testing.T) {
+	secret := "0123456789abcdef0123456789abcdef"
+	tokenSecret = []byte(secret)
+	hour := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name     string
+		token    string
+		wantUser string
+	}{
+		{"valid", signedToken(t, secret, TokenClaims{User: "alice", Expires: hour}), "alice"},
+		{"other secret", signedToken(t, "another secret", TokenClaims{User: "alice", Expires: hour}), ""},
+		{"expired", signedToken(t, secret, TokenClaims{User: "alice", Expires: time.Now().Add(-time.Second).Unix()}), ""},
+		{"no user", signedToken(t, secret, TokenClaims{Expires: hour}), ""},
+		{"three parts", "a.b.c", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := VerifyToken(tt.token)
+			if (err == nil) != (tt.wantUser != "") || (err == nil && claims.User != tt.wantUser) {
+				t.Errorf("VerifyToken() = %q, %v, want %q", claims.User, err, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestCanAccess(t *testing.T) {
+	owner := &SearchJob{Owner: "alice"}
+	tests := []struct {
+		claims      TokenClaims
+		target      string
+		wantAccess  bool
+		wantUsesJob bool
+	}{
+		{TokenClaims{User: "alice"}, "alice", true, true},
+		{TokenClaims{User: "bob"}, "alice", false, false},
+		{TokenClaims{User: "bob", Shares: []string{"alice"}}, "alice", true, false},
+		{TokenClaims{User: "carol", Roles: []string{RoleAuditor}}, "alice", true, false},
+		{TokenClaims{User: "dave", Roles: []string{RoleAdmin}}, "alice", true, true},
+	}
+	for _, tt := range tests {
+		if got := tt.claims.CanAccess(tt.target); got != tt.wantAccess {
+			t.Errorf("%s (roles %v, shares %v) CanAccess(%s) = %v", tt.claims.User, tt.claims.Roles, tt.claims.Shares, tt.target, got)
+		}
+		if got := tt.claims.CanUseJob(owner); got != tt.wantUsesJob {
+			t.Errorf("%s CanUseJob(job of alice) = %v", tt.claims.User, got)
+		}
+	}
+}
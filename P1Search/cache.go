@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// RANKED MATCHES OF A QUERY BEFORE PAGINATION. TotalMatches COUNTS THE MATCHES DROPPED BY search.maxresults TOO
+type RankedSearch struct {
+	Results      []SearchResult
+	TotalMatches int
+}
+
+type conversationEntry struct {
+	Version string
+	Lines   []ConversationLine
+	Index   *TFIDFIndex
+	Created time.Time
+}
+
+type resultsEntry struct {
+	Version string
+	Search  RankedSearch
+	Created time.Time
+}
+
+// COUNTERS OF THE SEARCH CACHE. Hits/Misses COUNT QUERIES, Downloads COUNTS CONVERSATIONS THAT HAD TO BE FETCHED
+// FROM S3 AGAIN AND Invalidations THE ONES DROPPED BECAUSE THEY CHANGED IN S3
+type CacheStats struct {
+	Hits          int64
+	Misses        int64
+	Downloads     int64
+	Invalidations int64
+}
+
+// CACHES THE DOWNLOADED CONVERSATION OF EVERY USER AND THE RANKED RESULTS OF EVERY (USER, QUERY, OPTIONS).
+// EVERY ENTRY IS TAGGED WITH THE VERSION OF THE CONVERSATION IT WAS BUILT FROM AND IS ONLY SERVED WHILE THAT VERSION IS CURRENT
+type SearchCache struct {
+	mu            sync.Mutex
+	ttl           time.Duration
+	maxEntries    int
+	conversations map[string]conversationEntry
+	results       map[string]resultsEntry
+	stats         CacheStats
+}
+
+func NewSearchCache(ttl time.Duration, maxEntries int) *SearchCache {
+	return &SearchCache{
+		ttl:           ttl,
+		maxEntries:    maxEntries,
+		conversations: make(map[string]conversationEntry),
+		results:       make(map[string]resultsEntry),
+	}
+}
+
+// VERSION OF A USER CONVERSATION: CHANGES WHENEVER A SESSION IS ADDED, REMOVED OR REWRITTEN IN S3
+func ConversationVersion(items *s3.ListObjectsV2Output) string {
+	h := fnv.New64a()
+	for _, item := range items.Contents {
+		fmt.Fprintf(h, "%s\x00", *item.Key)
+		if item.ETag != nil {
+			fmt.Fprintf(h, "%s\x00", *item.ETag)
+		}
+		if item.LastModified != nil {
+			fmt.Fprintf(h, "%d\x00", item.LastModified.UnixNano())
+		}
+	}
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// KEY OF THE RANKED RESULTS OF A QUERY. PAGE AND CONTEXT OPTIONS ARE LEFT OUT BECAUSE THEY ARE APPLIED AFTER RANKING
+func SearchCacheKey(client string, sentence string, opts SearchOptions) string {
+	return fmt.Sprintf("%s\x00%s\x00%d\x00%t\x00%d\x00%t", client, sentence, opts.MaxResults, opts.Fuzzy, opts.MaxDistance, opts.Similar)
+}
+
+// RETURNS THE CACHED LINES OF client IF THEY WERE BUILT FROM version
+func (c *SearchCache) GetConversation(client string, version string) ([]ConversationLine, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.conversations[client]
+	if ok && entry.Version != version {
+		c.invalidateLocked(client)
+		ok = false
+	}
+	if ok && c.expired(entry.Created) {
+		delete(c.conversations, client)
+		ok = false
+	}
+	if !ok {
+		c.stats.Downloads++
+	}
+	return entry.Lines, ok
+}
+
+func (c *SearchCache) PutConversation(client string, version string, lines []ConversationLine) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conversations[client] = conversationEntry{Version: version, Lines: lines, Created: time.Now()}
+	c.evictLocked()
+}
+
+// RETURNS THE TF-IDF INDEX OF THE LINES OF client, BUILDING IT THE FIRST TIME IT IS NEEDED FOR version.
+// THE INDEX LIVES WITH THE CACHED CONVERSATION SO IT IS DROPPED TOGETHER WITH IT. IT IS BUILT WITHOUT HOLDING THE
+// LOCK SO OTHER SEARCHES ARE NOT BLOCKED MEANWHILE, IF TWO BUILD IT AT ONCE THE FIRST ONE STORED IS KEPT
+func (c *SearchCache) GetIndex(client string, version string, lines []ConversationLine) *TFIDFIndex {
+	c.mu.Lock()
+	entry, ok := c.conversations[client]
+	c.mu.Unlock()
+	if ok && entry.Version == version && entry.Index != nil {
+		return entry.Index
+	}
+	index := BuildTFIDFIndex(lines)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok = c.conversations[client]
+	if ok && entry.Version == version {
+		if entry.Index != nil {
+			return entry.Index
+		}
+		entry.Index = index
+		c.conversations[client] = entry
+	}
+	return index
+}
+
+// RETURNS THE CACHED RANKED RESULTS OF key IF THEY WERE BUILT FROM version
+func (c *SearchCache) GetResults(key string, version string) (RankedSearch, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.results[key]
+	if ok && (entry.Version != version || c.expired(entry.Created)) {
+		delete(c.results, key)
+		ok = false
+	}
+	if ok {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	return entry.Search, ok
+}
+
+func (c *SearchCache) PutResults(key string, version string, search RankedSearch) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results[key] = resultsEntry{Version: version, Search: search, Created: time.Now()}
+	c.evictLocked()
+}
+
+func (c *SearchCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// DROPS THE CONVERSATION OF client AND EVERY RESULT BUILT FROM IT
+func (c *SearchCache) invalidateLocked(client string) {
+	delete(c.conversations, client)
+	prefix := client + "\x00"
+	for key := range c.results {
+		if len(key) >= len(prefix) && key[:len(prefix)] == prefix {
+			delete(c.results, key)
+		}
+	}
+	c.stats.Invalidations++
+}
+
+// REMOVES THE OLDEST RESULTS (AND THEN CONVERSATIONS) WHILE THE CACHE HOLDS MORE THAN maxEntries OF EACH KIND
+func (c *SearchCache) evictLocked() {
+	if c.maxEntries <= 0 {
+		return
+	}
+	if over := len(c.results) - c.maxEntries; over > 0 {
+		keys := make([]string, 0, len(c.results))
+		for key := range c.results {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return c.results[keys[i]].Created.Before(c.results[keys[j]].Created) })
+		for _, key := range keys[:over] {
+			delete(c.results, key)
+		}
+	}
+	if over := len(c.conversations) - c.maxEntries; over > 0 {
+		clients := make([]string, 0, len(c.conversations))
+		for client := range c.conversations {
+			clients = append(clients, client)
+		}
+		sort.Slice(clients, func(i, j int) bool {
+			return c.conversations[clients[i]].Created.Before(c.conversations[clients[j]].Created)
+		})
+		for _, client := range clients[:over] {
+			delete(c.conversations, client)
+		}
+	}
+}
+
+func (c *SearchCache) expired(created time.Time) bool {
+	return c.ttl > 0 && time.Since(created) > c.ttl
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	s3 "github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestConversationVersion(t *testing.T) {
+	modified := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+	listing := func(etag string, keys ...string) *s3.ListObjectsV2Output {
+		out := &s3.ListObjectsV2Output{}
+		for _, key := range keys {
+			out.Contents = append(out.Contents, &s3.Object{Key: aws.String(key), ETag: aws.String(etag), LastModified: &modified})
+		}
+		return out
+	}
+
+	version := ConversationVersion(listing("e1", "conversations/alice_a.txt"))
+	if again := ConversationVersion(listing("e1", "conversations/alice_a.txt")); again != version {
+		t.Errorf("the same listing gave versions %q and %q", version, again)
+	}
+	if rewritten := ConversationVersion(listing("e2", "conversations/alice_a.txt")); rewritten == version {
+		t.Errorf("rewriting a session kept the version %q", version)
+	}
+	if added := ConversationVersion(listing("e1", "conversations/alice_a.txt", "conversations/alice_b.txt")); added == version {
+		t.Errorf("adding a session kept the version %q", version)
+	}
+}
+
+func TestSearchCacheVersions(t *testing.T) {
+	c := NewSearchCache(time.Hour, 10)
+	lines := []ConversationLine{{Timestamp: "t0", Text: "hello"}}
+	key := SearchCacheKey("alice", "hello", SearchOptions{})
+	c.PutConversation("alice", "v1", lines)
+	c.PutResults(key, "v1", RankedSearch{TotalMatches: 1})
+
+	if _, ok := c.GetConversation("alice", "v1"); !ok {
+		t.Fatalf("the conversation was not cached")
+	}
+	if search, ok := c.GetResults(key, "v1"); !ok || search.TotalMatches != 1 {
+		t.Fatalf("GetResults() = %+v, %v, want the cached results", search, ok)
+	}
+
+	// A new session in S3 changes the version, everything built from the old one goes
+	if _, ok := c.GetConversation("alice", "v2"); ok {
+		t.Errorf("a stale conversation was served")
+	}
+	if _, ok := c.GetResults(key, "v1"); ok {
+		t.Errorf("results of an invalidated conversation were served")
+	}
+
+	stats := c.Stats()
+	if stats != (CacheStats{Hits: 1, Misses: 1, Downloads: 1, Invalidations: 1}) {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestSearchCacheExpiryAndEviction(t *testing.T) {
+	c := NewSearchCache(time.Hour, 2)
+	c.PutResults("alice\x00a", "v1", RankedSearch{})
+	c.PutResults("alice\x00b", "v1", RankedSearch{})
+	c.results["alice\x00a"] = resultsEntry{Version: "v1", Created: time.Now().Add(-time.Minute)}
+	c.PutResults("alice\x00c", "v1", RankedSearch{})
+
+	if _, ok := c.GetResults("alice\x00a", "v1"); ok {
+		t.Errorf("the oldest results were not evicted over maxEntries")
+	}
+	if _, ok := c.GetResults("alice\x00c", "v1"); !ok {
+		t.Errorf("the newest results were evicted")
+	}
+
+	c.results["alice\x00b"] = resultsEntry{Version: "v1", Created: time.Now().Add(-2 * time.Hour)}
+	if _, ok := c.GetResults("alice\x00b", "v1"); ok {
+		t.Errorf("results older than the ttl were served")
+	}
+}
+
+func TestSearchCacheIndex(t *testing.T) {
+	c := NewSearchCache(time.Hour, 10)
+	lines := []ConversationLine{{Text: "deploy failed"}}
+
+	// Without a cached conversation the index is built but not kept
+	if c.GetIndex("alice", "v1", lines) == c.GetIndex("alice", "v1", lines) {
+		t.Errorf("index kept without a cached conversation")
+	}
+	c.PutConversation("alice", "v1", lines)
+	first := c.GetIndex("alice", "v1", lines)
+	if c.GetIndex("alice", "v1", lines) != first {
+		t.Errorf("index rebuilt for the same version")
+	}
+	if c.GetIndex("alice", "v2", lines) == first {
+		t.Errorf("index of an old version reused")
+	}
+}
+
+func TestSearchCacheIndexConcurrent(t *testing.T) {
+	c := NewSearchCache(time.Hour, 10)
+	lines := []ConversationLine{{Text: "deploy failed"}, {Text: "deploy fixed"}}
+	c.PutConversation("alice", "v1", lines)
+
+	// Searches building the index at once all end up with the one stored first
+	indexes := make([]*TFIDFIndex, 8)
+	var wg sync.WaitGroup
+	for i := range indexes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			indexes[i] = c.GetIndex("alice", "v1", lines)
+		}(i)
+	}
+	wg.Wait()
+	kept := c.GetIndex("alice", "v1", lines)
+	for i, index := range indexes {
+		if index != kept {
+			t.Errorf("search %d got an index that was not kept", i)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A RUN OF CONSECUTIVE CONVERSATION LINES [Start, End] (BOTH INCLUDED) SHOWN AROUND ONE OR MORE HITS
+type ContextWindow struct {
+	Start int
+	End   int
+}
+
+// BUILDS A before/after WINDOW AROUND EVERY HIT LINE AND MERGES THE WINDOWS THAT OVERLAP OR TOUCH, LIKE grep -B/-A.
+// THE RETURNED WINDOWS ARE IN FILE ORDER
+func MergeContextWindows(hits []int, before int, after int, totalLines int) []ContextWindow {
+	sorted := append([]int(nil), hits...)
+	sort.Ints(sorted)
+
+	var windows []ContextWindow
+	for _, hit := range sorted {
+		start := hit - before
+		if start < 0 {
+			start = 0
+		}
+		end := hit + after
+		if end > totalLines-1 {
+			end = totalLines - 1
+		}
+		if n := len(windows); n > 0 && start <= windows[n-1].End+1 {
+			if end > windows[n-1].End {
+				windows[n-1].End = end
+			}
+			continue
+		}
+		windows = append(windows, ContextWindow{Start: start, End: end})
+	}
+	return windows
+}
+
+// ENCODES THE WINDOWS AS RESULT ENTRIES SEPARATED BY ///. HITS KEEP THEIR SCORE AND OFFSETS, CONTEXT LINES LEAVE THEM
+// AND THE VARIANTS EMPTY (BUT KEEP THEIR SESSION AND LINE) AND CONSECUTIVE WINDOWS ARE SEPARATED BY A -- ENTRY
+func FormatContextWindows(lines []ConversationLine, windows []ContextWindow, hits map[int]SearchResult) string {
+	var b strings.Builder
+	for i, window := range windows {
+		if i > 0 {
+			b.WriteString("--///")
+		}
+		for index := window.Start; index <= window.End; index++ {
+			if hit, ok := hits[index]; ok {
+				b.WriteString(FormatSearchResult(hit) + "///")
+			} else {
+				b.WriteString(fmt.Sprintf("%s|||%s||||||||||||%s|||%d///", lines[index].Timestamp, lines[index].Text, lines[index].Session, lines[index].Line))
+			}
+		}
+	}
+	return b.String()
+}
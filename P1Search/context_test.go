@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeContextWindows(t *testing.T) {
+	tests := []struct {
+		name   string
+		hits   []int
+		before int
+		after  int
+		total  int
+		want   []ContextWindow
+	}{
+		{"no hits", nil, 2, 2, 10, nil},
+		{"no context", []int{3, 5}, 0, 0, 10, []ContextWindow{{3, 3}, {5, 5}}},
+		{"separate windows", []int{2, 8}, 1, 1, 10, []ContextWindow{{1, 3}, {7, 9}}},
+		{"overlapping windows merge", []int{2, 4}, 1, 1, 10, []ContextWindow{{1, 5}}},
+		{"touching windows merge", []int{2, 5}, 1, 1, 10, []ContextWindow{{1, 6}}},
+		{"clipped to the conversation", []int{0, 9}, 3, 3, 10, []ContextWindow{{0, 3}, {6, 9}}},
+		{"unsorted hits", []int{8, 1}, 0, 1, 10, []ContextWindow{{1, 2}, {8, 9}}},
+		{"window inside the previous one", []int{2, 3}, 0, 5, 10, []ContextWindow{{2, 8}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MergeContextWindows(tt.hits, tt.before, tt.after, tt.total)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergeContextWindows(%v, %d, %d, %d) = %v, want %v", tt.hits, tt.before, tt.after, tt.total, got, tt.want)
+			}
+		})
+	}
+
+	hits := []int{5, 1}
+	MergeContextWindows(hits, 1, 1, 10)
+	if !reflect.DeepEqual(hits, []int{5, 1}) {
+		t.Errorf("MergeContextWindows reordered the hits of the caller: %v", hits)
+	}
+}
+
+func TestFormatContextWindows(t *testing.T) {
+	lines := []ConversationLine{
+		{Timestamp: "t0", Text: "zero", Session: "a", Line: 1},
+		{Timestamp: "t1", Text: "one", Session: "a", Line: 2},
+		{Timestamp: "t2", Text: "two", Session: "b", Line: 1},
+		{Timestamp: "t3", Text: "three", Session: "b", Line: 2},
+		{Timestamp: "t4", Text: "four", Session: "b", Line: 3},
+	}
+	hits := map[int]SearchResult{
+		1: {Line: lines[1], Score: 1, Offsets: [][2]int{{0, 3}}},
+		4: {Line: lines[4], Score: 2, Offsets: [][2]int{{0, 4}}},
+	}
+	got := FormatContextWindows(lines, []ContextWindow{{0, 2}, {4, 4}}, hits)
+	want := "t0|||zero||||||||||||a|||1///t1|||one|||1.000|||0-3||||||a|||2///t2|||two||||||||||||b|||1///--///t4|||four|||2.000|||0-4||||||b|||3///"
+	if got != want {
+		t.Errorf("FormatContextWindows() =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PAGE OF RANKED RESULTS RETURNED TO THE CLIENT. THE CURSORS ARE EMPTY WHEN THERE IS NO PAGE IN THAT DIRECTION
+type SearchPage struct {
+	TotalMatches int
+	NextCursor   string
+	PrevCursor   string
+}
+
+// IDENTIFIES A QUERY SO A CURSOR CANNOT BE REPLAYED AGAINST A DIFFERENT ONE
+func SearchFingerprint(client string, sentence string, opts SearchOptions) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00%d\x00%d\x00%t\x00%d\x00%t\x00%d", client, sentence, opts.MaxResults, opts.Before, opts.After, opts.Fuzzy, opts.MaxDistance, opts.Similar, opts.PageSize)
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// BUILDS THE OPAQUE CURSOR POINTING TO THE RESULT AT POSITION offset
+func EncodeCursor(offset int, fingerprint string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", offset, fingerprint)))
+}
+
+// RETURNS THE OFFSET A CURSOR POINTS TO. FAILS IF THE CURSOR IS MALFORMED OR WAS CREATED FOR ANOTHER QUERY
+func DecodeCursor(cursor string, fingerprint string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, fmt.Errorf("Malformed cursor: %v", err)
+	}
+	columns := strings.SplitN(string(raw), ":", 2)
+	if len(columns) != 2 {
+		return 0, fmt.Errorf("Malformed cursor %q", cursor)
+	}
+	if columns[1] != fingerprint {
+		return 0, fmt.Errorf("Cursor %q belongs to another search", cursor)
+	}
+	offset, err := strconv.Atoi(columns[0])
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("Malformed cursor %q", cursor)
+	}
+	return offset, nil
+}
+
+// CUTS THE PAGE STARTING AT THE CURSOR OUT OF THE RANKED RESULTS AND FILLS THE CURSORS OF THE NEIGHBOUR PAGES.
+// AN EMPTY OR INVALID CURSOR RETURNS THE FIRST PAGE
+func PaginateResults(results []SearchResult, opts SearchOptions, fingerprint string, page *SearchPage) []SearchResult {
+	if opts.PageSize <= 0 {
+		return results
+	}
+	offset := 0
+	if opts.Cursor != "" {
+		n, err := DecodeCursor(opts.Cursor, fingerprint)
+		if err != nil {
+			log.Warnf("Ignoring search cursor, returning first page: %v", err)
+		} else if n < len(results) {
+			offset = n
+		}
+	}
+	end := offset + opts.PageSize
+	if end > len(results) {
+		end = len(results)
+	}
+	if end < len(results) {
+		page.NextCursor = EncodeCursor(end, fingerprint)
+	}
+	if offset > 0 {
+		prev := offset - opts.PageSize
+		if prev < 0 {
+			prev = 0
+		}
+		page.PrevCursor = EncodeCursor(prev, fingerprint)
+	}
+	return results[offset:end]
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/base64"
+	"strconv"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	fingerprint := SearchFingerprint("alice", "hello", SearchOptions{PageSize: 10})
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	for _, offset := range []int{0, 10, 35} {
+		got, err := DecodeCursor(EncodeCursor(offset, fingerprint), fingerprint)
+		if err != nil || got != offset {
+			t.Errorf("DecodeCursor(EncodeCursor(%d)) = %d, %v, want %d", offset, got, err, offset)
+		}
+	}
+
+	other := SearchFingerprint("alice", "bye", SearchOptions{PageSize: 10})
+	for name, cursor := range map[string]string{
+		"other search":        EncodeCursor(10, other),
+		"not base64":          "%%%",
+		"no separator":        raw("10"),
+		"negative offset":     raw("-10:" + fingerprint),
+		"offset not a number": raw("ten:" + fingerprint),
+	} {
+		if _, err := DecodeCursor(cursor, fingerprint); err == nil {
+			t.Errorf("DecodeCursor accepted a cursor with %s", name)
+		}
+	}
+}
+
+func TestSearchFingerprint(t *testing.T) {
+	base := SearchOptions{MaxResults: 100, PageSize: 10}
+	want := SearchFingerprint("alice", "hello", base)
+
+	withCursor := base
+	withCursor.Cursor = "x"
+	if got := SearchFingerprint("alice", "hello", withCursor); got != want {
+		t.Errorf("the cursor changed the fingerprint of the search: %q != %q", got, want)
+	}
+
+	fuzzy := base
+	fuzzy.Fuzzy = true
+	bigger := base
+	bigger.PageSize = 20
+	for name, got := range map[string]string{
+		"client":    SearchFingerprint("bob", "hello", base),
+		"sentence":  SearchFingerprint("alice", "bye", base),
+		"page size": SearchFingerprint("alice", "hello", bigger),
+		"fuzzy":     SearchFingerprint("alice", "hello", fuzzy),
+	} {
+		if got == want {
+			t.Errorf("changing the %s kept the fingerprint %q", name, got)
+		}
+	}
+}
+
+func TestPaginateResults(t *testing.T) {
+	results := make([]SearchResult, 25)
+	for i := range results {
+		results[i].Line.Text = strconv.Itoa(i)
+	}
+	const fp = "fp"
+	tests := []struct {
+		name     string
+		pageSize int
+		cursor   string
+		first    string // Text of the first result of the page
+		size     int
+		next     string
+		prev     string
+	}{
+		{"no paging", 0, "", "0", 25, "", ""},
+		{"first page", 10, "", "0", 10, EncodeCursor(10, fp), ""},
+		{"middle page", 10, EncodeCursor(10, fp), "10", 10, EncodeCursor(20, fp), EncodeCursor(0, fp)},
+		{"last page", 10, EncodeCursor(20, fp), "20", 5, "", EncodeCursor(10, fp)},
+		{"unaligned cursor", 10, EncodeCursor(5, fp), "5", 10, EncodeCursor(15, fp), EncodeCursor(0, fp)},
+		{"cursor past the end", 10, EncodeCursor(40, fp), "0", 10, EncodeCursor(10, fp), ""},
+		{"cursor of another search", 10, EncodeCursor(10, "other"), "0", 10, EncodeCursor(10, fp), ""},
+		{"one page holds everything", 30, "", "0", 25, "", ""},
+	}
+	for _, tt := range tests {
+		var page SearchPage
+		got := PaginateResults(results, SearchOptions{PageSize: tt.pageSize, Cursor: tt.cursor}, fp, &page)
+		if len(got) != tt.size || got[0].Line.Text != tt.first {
+			t.Errorf("%s: page of %d results starting at %s, want %d starting at %s", tt.name, len(got), got[0].Line.Text, tt.size, tt.first)
+		}
+		if page.NextCursor != tt.next || page.PrevCursor != tt.prev {
+			t.Errorf("%s: cursors %q %q, want %q %q", tt.name, page.NextCursor, page.PrevCursor, tt.next, tt.prev)
+		}
+	}
+}
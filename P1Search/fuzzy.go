@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// A WORD OF A LINE AND ITS [START, END) BYTE POSITION
+type Token struct {
+	Text  string
+	Start int
+	End   int
+}
+
+// SPLITS text IN WORDS (RUNS OF LETTERS AND DIGITS), KEEPING THEIR POSITION
+func Tokenize(text string) []Token {
+	var tokens []Token
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			tokens = append(tokens, Token{Text: text[start:i], Start: start, End: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, Token{Text: text[start:], Start: start, End: len(text)})
+	}
+	return tokens
+}
+
+// LEVENSHTEIN DISTANCE BETWEEN a AND b (IN RUNES) IF IT IS <= maxDistance. RETURNS maxDistance+1 AS SOON AS
+// THE DISTANCE IS KNOWN TO BE BIGGER, SO LONG UNRELATED WORDS ARE DISCARDED EARLY
+func BoundedEditDistance(a string, b string, maxDistance int) int {
+	ra, rb := []rune(a), []rune(b)
+	if abs(len(ra)-len(rb)) > maxDistance {
+		return maxDistance + 1
+	}
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > maxDistance {
+			return maxDistance + 1
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// APPROXIMATE MATCH: EVERY WORD OF sentence MUST BE WITHIN maxDistance EDITS (CASE INSENSITIVE) OF SOME WORD OF text.
+// RETURNS THE OFFSETS AND TEXT OF EVERY WORD OF text THAT MATCHED, OR NOTHING IF ANY WORD OF sentence HAS NO MATCH
+func FindFuzzyMatches(text string, sentence string, maxDistance int) ([][2]int, []string) {
+	queryTokens := Tokenize(strings.ToLower(sentence))
+	if len(queryTokens) == 0 {
+		return nil, nil
+	}
+	lineTokens := Tokenize(text)
+	matched := make([]bool, len(lineTokens))
+	for _, q := range queryTokens {
+		found := false
+		for i, t := range lineTokens {
+			if BoundedEditDistance(q.Text, strings.ToLower(t.Text), maxDistance) <= maxDistance {
+				matched[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, nil
+		}
+	}
+
+	var offsets [][2]int
+	var variants []string
+	for i, t := range lineTokens {
+		if matched[i] {
+			offsets = append(offsets, [2]int{t.Start, t.End})
+			variants = append(variants, t.Text)
+		}
+	}
+	return offsets, variants
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
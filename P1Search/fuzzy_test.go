@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBoundedEditDistance(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		max  int
+		want int
+	}{
+		{"equal", "hello", "hello", 2, 0},
+		{"substitution", "hello", "hallo", 2, 1},
+		{"insertion", "hello", "helllo", 2, 1},
+		{"deletion", "hello", "helo", 2, 1},
+		{"transposition counts twice", "hello", "hlelo", 2, 2},
+		{"empty strings", "", "", 1, 0},
+		{"one empty", "", "ab", 2, 2},
+		{"runes instead of bytes", "cañón", "canon", 2, 2},
+		{"length difference over the bound", "a", "abcd", 2, 3},
+		{"distance over the bound", "kitten", "sitting", 2, 3},
+		{"distance at the bound", "kitten", "sitting", 3, 3},
+		{"zero bound", "abc", "abd", 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BoundedEditDistance(tt.a, tt.b, tt.max); got != tt.want {
+				t.Errorf("BoundedEditDistance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []Token
+	}{
+		{"", nil},
+		{"  ...  ", nil},
+		{"hi there", []Token{{"hi", 0, 2}, {"there", 3, 8}}},
+		{"a1, b2!", []Token{{"a1", 0, 2}, {"b2", 4, 6}}},
+		{"ñu año", []Token{{"ñu", 0, 3}, {"año", 4, 8}}},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestFindFuzzyMatches(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		sentence     string
+		max          int
+		wantOffsets  [][2]int
+		wantVariants []string
+	}{
+		{"exact word", "see you tomorrow", "tomorrow", 1, [][2]int{{8, 16}}, []string{"tomorrow"}},
+		{"typo", "see you tomorrow", "tomorow", 1, [][2]int{{8, 16}}, []string{"tomorrow"}},
+		{"case insensitive", "Hello World", "hello", 0, [][2]int{{0, 5}}, []string{"Hello"}},
+		{"every word must match", "see you tomorrow", "tomorrow later", 1, nil, nil},
+		{"several variants", "cat bat rat", "cat", 1, [][2]int{{0, 3}, {4, 7}, {8, 11}}, []string{"cat", "bat", "rat"}},
+		{"too many edits", "see you tomorrow", "tmrw", 1, nil, nil},
+		{"empty sentence", "see you tomorrow", "", 1, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offsets, variants := FindFuzzyMatches(tt.text, tt.sentence, tt.max)
+			if !reflect.DeepEqual(offsets, tt.wantOffsets) || !reflect.DeepEqual(variants, tt.wantVariants) {
+				t.Errorf("FindFuzzyMatches(%q, %q, %d) = %v %v, want %v %v", tt.text, tt.sentence, tt.max, offsets, variants, tt.wantOffsets, tt.wantVariants)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+package main
+
+// THE FILES ENDING IN _shared.go ARE COPIES OF THE ONES IN ../shared, COMMON TO SEVERAL APPS. EDIT THEM THERE AND
+// RUN go generate IN EVERY APP THAT USES THEM
+
+//go:generate sh ../shared/copy.sh health.go ratelimit.go secret.go
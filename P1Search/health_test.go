@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHistogramObserve(t *testing.T) {
+	h := NewHistogram([]float64{0.1, 1, 10})
+	for _, v := range []float64{0.05, 0.1, 0.5, 5, 50} {
+		h.Observe(v)
+	}
+	// Counts are per bucket, values over the last bound only count in Sum and Count
+	if !reflect.DeepEqual(h.Counts, []uint64{2, 1, 1}) || h.Count != 5 || h.Sum != 55.65 {
+		t.Errorf("histogram = %+v", h)
+	}
+}
+
+func TestFormatMetric(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{3, "3"},
+		{0.025, "0.025"},
+		{1700000000.5, "1.7000000005e+09"},
+	}
+	for _, tt := range tests {
+		if got := FormatMetric(tt.v); got != tt.want {
+			t.Errorf("FormatMetric(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/sirupsen/logrus"
+	viper "github.com/theherk/viper"
+)
+
+// STATES OF A SEARCH JOB
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobCancelled = "cancelled"
+	JobFailed    = "failed"
+)
+
+// IDS PROPOSED BY THE CLIENTS FOR THEIR JOBS MUST LOOK LIKE THE ONES GENERATED HERE
+var jobIDPattern = regexp.MustCompile(`^[A-Za-z0-9]{6,32}$`)
+
+// STATUS OF A SEARCH JOB AS SENT TO THE CLIENTS (COMMAND 7)
+type JobStatus struct {
+	JobID           string `json:"jobID"`
+	Client          string `json:"client"`
+	Sentence        string `json:"sentence"`
+	Parent          string `json:"parent,omitempty"`
+	Status          string `json:"status"`
+	SessionsScanned int    `json:"sessionsScanned"`
+	TotalSessions   int    `json:"totalSessions"`
+	Matches         int    `json:"matches"`
+	Error           string `json:"error,omitempty"`
+	Created         string `json:"created"`
+}
+
+// A SEARCH RUN IN BACKGROUND BY THE SEARCH WORKERS. ONCE DONE ITS RESULT IS KEPT SO THE CLIENTS CAN PAGE THROUGH IT
+// OR REFINE IT WITHOUT RUNNING IT AGAIN. Lines IS THE CONVERSATION THE RESULT INDEXES POINT TO AND Parent THE JOB IT
+// REFINED, IF ANY. THE MUTABLE STATE IS GUARDED BY mu SINCE IT IS READ BY THE MAIN LOOP WHILE A WORKER RUNS THE JOB
+type SearchJob struct {
+	ID          string
+	Client      string
+	Owner       string // User that submitted the job
+	Sentence    string
+	Parent      string
+	Options     SearchOptions
+	Fingerprint string
+	SessID      string // Session that submitted the job
+	Timestamp   string // Timestamp of the submission, echoed in every reply
+	Subscribe   bool   // Push progress and the first page to the submitting session
+	Created     time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu              sync.Mutex
+	status          string
+	sessionsScanned int
+	totalSessions   int
+	matches         int
+	err             string
+	search          RankedSearch
+	lines           []ConversationLine
+	finished        time.Time
+}
+
+func NewSearchJob(client string, sentence string, opts SearchOptions) *SearchJob {
+	job := &SearchJob{
+		Client:      client,
+		Sentence:    sentence,
+		Parent:      opts.Refine,
+		Options:     opts,
+		Fingerprint: SearchFingerprint(client, sentence, opts),
+		status:      JobQueued,
+	}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	return job
+}
+
+// RETURNS A SNAPSHOT OF THE STATUS OF THE JOB
+func (job *SearchJob) Status() JobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return JobStatus{
+		JobID:           job.ID,
+		Client:          job.Client,
+		Sentence:        job.Sentence,
+		Parent:          job.Parent,
+		Status:          job.status,
+		SessionsScanned: job.sessionsScanned,
+		TotalSessions:   job.totalSessions,
+		Matches:         job.matches,
+		Error:           job.err,
+		Created:         job.Created.Format(timestampLayout),
+	}
+}
+
+// RETURNS THE RESULT OF THE JOB AND THE LINES IT POINTS TO. ONLY MEANINGFUL ONCE THE JOB IS DONE
+func (job *SearchJob) Result() (RankedSearch, []ConversationLine) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.search, job.lines
+}
+
+func (job *SearchJob) start() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.status != JobQueued {
+		return false
+	}
+	job.status = JobRunning
+	return true
+}
+
+func (job *SearchJob) setProgress(scanned int, total int, matches int) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.sessionsScanned, job.totalSessions, job.matches = scanned, total, matches
+}
+
+// MOVES THE JOB TO A FINAL STATE. RETURNS FALSE IF IT WAS ALREADY IN ONE
+func (job *SearchJob) finish(status string, search RankedSearch, lines []ConversationLine, err string) bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.status != JobQueued && job.status != JobRunning {
+		return false
+	}
+	job.status, job.search, job.lines, job.err = status, search, lines, err
+	if status == JobDone {
+		job.matches = search.TotalMatches
+	}
+	job.finished = time.Now()
+	job.cancel()
+	return true
+}
+
+// CANCELS A QUEUED OR RUNNING JOB. THE WORKER RUNNING IT STOPS AT THE NEXT SESSION
+func (job *SearchJob) Cancel() bool {
+	return job.finish(JobCancelled, RankedSearch{}, nil, "")
+}
+
+// TRUE ttl AFTER THE JOB FINISHED OR, IF IT NEVER DID, ttl AFTER IT WAS SUBMITTED
+func (job *SearchJob) expired(ttl time.Duration) bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if ttl <= 0 {
+		return false
+	} else if job.finished.IsZero() {
+		return time.Since(job.Created) > ttl
+	}
+	return time.Since(job.finished) > ttl
+}
+
+func (job *SearchJob) active() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.status == JobQueued || job.status == JobRunning
+}
+
+// RETURNED BY Add WHEN THE OWNER OF A NEW JOB ALREADY HAS TOO MANY QUEUED OR RUNNING ONES
+var ErrTooManyJobs = errors.New("Too many search jobs")
+
+// SEARCH JOBS OF EVERY CLIENT. EVERY JOB EXPIRES ttl AFTER IT FINISHED, OR AFTER IT WAS SUBMITTED IF IT IS STILL
+// QUEUED OR RUNNING (IT IS CANCELLED THEN), AND IS DROPPED BY Sweep OR WHEN IT IS LOOKED UP. EACH USER KEEPS AT MOST
+// maxPerUser JOBS, <= 0 FOR NO LIMIT
+type SearchJobStore struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxPerUser int
+	jobs       map[string]*SearchJob
+}
+
+func NewSearchJobStore(ttl time.Duration, maxPerUser int) *SearchJobStore {
+	return &SearchJobStore{ttl: ttl, maxPerUser: maxPerUser, jobs: make(map[string]*SearchJob)}
+}
+
+// STORES A JOB UNDER proposedID IF IT IS VALID AND FREE OR UNDER A NEW RANDOM ID OTHERWISE, AND RETURNS IT.
+// EXPIRED JOBS ARE DROPPED ON THE WAY. IF THE OWNER OF THE JOB ALREADY HAS maxPerUser JOBS ITS OLDEST FINISHED ONE IS
+// DROPPED TO MAKE ROOM. IF ALL OF THEM ARE STILL QUEUED OR RUNNING THE NEW JOB IS STORED FAILED, SO ITS CLIENT CAN
+// STILL ASK WHY, AND ErrTooManyJobs IS RETURNED
+func (s *SearchJobStore) Add(job *SearchJob, proposedID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeLocked()
+	var err error
+	if s.maxPerUser > 0 && !s.makeRoomLocked(job.Owner) {
+		err = ErrTooManyJobs
+		job.finish(JobFailed, RankedSearch{}, nil, fmt.Sprintf("You already have %d search jobs queued or running, wait for one to finish or cancel it", s.maxPerUser))
+	}
+	job.ID = proposedID
+	for {
+		if _, ok := s.jobs[job.ID]; jobIDPattern.MatchString(job.ID) && !ok {
+			break
+		}
+		b := make([]byte, 8)
+		rand.Read(b)
+		job.ID = hex.EncodeToString(b)
+	}
+	job.Created = time.Now()
+	s.jobs[job.ID] = job
+	return job.ID, err
+}
+
+// DROPS THE OLDEST FINISHED JOBS OF owner UNTIL IT HAS LESS THAN maxPerUser. FALSE IF IT STILL HAS maxPerUser QUEUED
+// OR RUNNING ONES
+func (s *SearchJobStore) makeRoomLocked(owner string) bool {
+	var kept []*SearchJob
+	for _, job := range s.jobs {
+		if job.Owner == owner {
+			kept = append(kept, job)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].Created.Before(kept[j].Created) })
+	count := len(kept)
+	for _, job := range kept {
+		if count < s.maxPerUser {
+			break
+		}
+		if !job.active() {
+			delete(s.jobs, job.ID)
+			count--
+		}
+	}
+	return count < s.maxPerUser
+}
+
+// RETURNS THE JOB id IF IT EXISTS, DID NOT EXPIRE AND SEARCHED THE CONVERSATION OF client
+func (s *SearchJobStore) Get(id string, client string) (*SearchJob, bool) {
+	job, ok := s.Find(id)
+	if !ok {
+		return nil, false
+	}
+	return job, job.Client == client
+}
+
+// RETURNS THE JOB id IF IT EXISTS AND DID NOT EXPIRE, WHATEVER CONVERSATION IT SEARCHED
+func (s *SearchJobStore) Find(id string) (*SearchJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	if job.expired(s.ttl) {
+		job.Cancel()
+		delete(s.jobs, id)
+		return nil, false
+	}
+	return job, true
+}
+
+// DROPS THE EXPIRED JOBS, CANCELLING THOSE STILL QUEUED OR RUNNING, AND RETURNS HOW MANY
+func (s *SearchJobStore) Sweep() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.purgeLocked()
+}
+
+// SWEEPS THE STORE EVERY interval, SO THE RESULTS OF THE JOBS NOBODY ASKS FOR ANY MORE DO NOT STAY IN MEMORY UNTIL
+// THE NEXT SEARCH
+func (s *SearchJobStore) StartSweeper(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if n := s.Sweep(); n > 0 {
+				log.Infof("Dropped %d expired search jobs", n)
+			}
+		}
+	}()
+}
+
+func (s *SearchJobStore) purgeLocked() int {
+	dropped := 0
+	for id, job := range s.jobs {
+		if job.expired(s.ttl) {
+			if job.Cancel() {
+				log.Warnf("Search job %s cancelled, it was not done after %v", id, s.ttl)
+			}
+			delete(s.jobs, id)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// QUEUES A NEW JOB FOR THE SEARCH WORKERS, UNLESS ITS OWNER HAS TOO MANY ALREADY. A SUBSCRIBED CLIENT IS TOLD THE
+// JOB ID RIGHT AWAY
+func SubmitSearchJob(job *SearchJob, proposedID string) {
+	if _, err := searchJobs.Add(job, proposedID); err != nil {
+		log.Warnf("Search job %s of %s rejected: %v", job.ID, job.Owner, err)
+	} else {
+		select {
+		case searchQueue <- job:
+			log.Infof("Search job %s queued for %s", job.ID, job.Client)
+		default:
+			job.finish(JobFailed, RankedSearch{}, nil, "The search queue is full, try again later")
+			log.Warnf("Search job %s rejected, the queue is full", job.ID)
+		}
+	}
+	if job.Subscribe {
+		SendJobStatus(job.Status(), job.SessID, job.Timestamp)
+	}
+}
+
+// STORES A NEW JOB AS FAILED WITH reason WITHOUT RUNNING IT. A SUBSCRIBED CLIENT IS TOLD RIGHT AWAY, WITH THE
+// STATUS AND THE PAGE IT WAITS FOR
+func RejectSearchJob(job *SearchJob, proposedID string, reason string) {
+	searchJobs.Add(job, proposedID)
+	job.finish(JobFailed, RankedSearch{}, nil, reason)
+	log.Warnf("Search job %s rejected: %s", job.ID, reason)
+	if job.Subscribe {
+		SendJobStatus(job.Status(), job.SessID, job.Timestamp)
+		SendSearchPage(nil, job.SessID, job.Timestamp, SearchOptions{}, reason)
+	}
+}
+
+// STARTS n WORKERS RUNNING THE QUEUED SEARCH JOBS
+func StartSearchWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		go func() {
+			for job := range searchQueue {
+				RunSearchJob(job)
+			}
+		}()
+	}
+}
+
+// RUNS A QUEUED JOB: SEARCHES sentence (ONLY AMONG THE RESULTS OF THE PARENT JOB IF IT REFINES ONE) PUBLISHING ITS
+// PROGRESS TO A SUBSCRIBED CLIENT AT MOST EVERY search.progressintervalms. A SUBSCRIBED CLIENT ALSO GETS THE FIRST
+// PAGE (OR THE REASON THERE IS NONE) WHEN THE JOB ENDS
+func RunSearchJob(job *SearchJob) {
+	if !job.start() {
+		return
+	}
+	log.Infof("Running search job %s", job.ID)
+	interval := time.Duration(viper.GetInt("search.progressintervalms")) * time.Millisecond
+	var lastPush time.Time
+	progress := func(scanned int, total int, matches int) {
+		job.setProgress(scanned, total, matches)
+		if job.Subscribe && time.Since(lastPush) >= interval {
+			lastPush = time.Now()
+			SendJobStatus(job.Status(), job.SessID, job.Timestamp)
+		}
+	}
+
+	ranked, lines, err := SearchJobConversation(job, progress)
+	if err != nil {
+		if job.ctx.Err() != nil {
+			log.Infof("Search job %s cancelled", job.ID)
+		} else {
+			log.Errorf("Search job %s failed: %v", job.ID, err)
+			job.finish(JobFailed, RankedSearch{}, nil, err.Error())
+		}
+	} else if job.finish(JobDone, ranked, lines, "") {
+		log.Infof("Search job %s done with %d matches", job.ID, ranked.TotalMatches)
+	}
+
+	if job.Subscribe {
+		status := job.Status()
+		SendJobStatus(status, job.SessID, job.Timestamp)
+		if status.Status == JobDone {
+			err = SendSearchPage(job, job.SessID, job.Timestamp, job.Options, "")
+		} else {
+			err = SendSearchPage(nil, job.SessID, job.Timestamp, SearchOptions{}, JobStatusMessage(status))
+		}
+		if err != nil {
+			log.Errorf("Could not send first page of search job %s: %v", job.ID, err)
+		}
+	}
+}
+
+// SEARCHES THE CONVERSATION OF A JOB, REFINING THE RESULT OF ITS PARENT IF IT HAS ONE
+func SearchJobConversation(job *SearchJob, progress ProgressFunc) (RankedSearch, []ConversationLine, error) {
+	opts := job.Options
+	var parent *SearchJob
+	if job.Parent != "" {
+		var ok bool
+		parent, ok = searchJobs.Get(job.Parent, job.Client)
+		if !ok || parent.Status().Status != JobDone {
+			return RankedSearch{}, nil, fmt.Errorf("Search job %s does not exist or expired", job.Parent)
+		}
+		// Every match is needed, the ones dropped by search.maxresults could still be results of the parent
+		opts.MaxResults = 0
+	}
+	ranked, lines, err := SearchConversation(job.ctx, job.Client, job.Sentence, opts, progress)
+	if err != nil {
+		return RankedSearch{}, nil, err
+	}
+	if parent != nil {
+		parentSearch, _ := parent.Result()
+		ranked = RefineResults(ranked, parentSearch, job.Options.MaxResults)
+	}
+	return ranked, lines, nil
+}
+
+// HUMAN READABLE EXPLANATION OF WHY A JOB HAS NO RESULTS TO SHOW
+func JobStatusMessage(status JobStatus) string {
+	if status.Error != "" {
+		return status.Error
+	}
+	return fmt.Sprintf("Search job %s is %s", status.JobID, status.Status)
+}
+
+// ANSWERS A JOB REQUEST (COMMAND 7). THE action ATTRIBUTE IS "status" (DEFAULT) OR "cancel" AND jobID THE JOB, WHICH
+// ONLY ITS OWNER OR AN ADMIN MAY USE
+func ProcessJobMessage(msg *sqs.Message, claims TokenClaims) error {
+	sessID := *msg.MessageAttributes["sessionID"].StringValue
+	timestamp := *msg.MessageAttributes["timestamp"].StringValue
+	id := GetAttribute(msg, "jobID")
+
+	job, ok := searchJobs.Find(id)
+	if !ok {
+		status := JobStatus{JobID: id, Status: "unknown", Error: fmt.Sprintf("Search job %s does not exist or expired", id)}
+		return SendJobStatus(status, sessID, timestamp)
+	}
+	if !claims.CanUseJob(job) {
+		status := JobStatus{JobID: id, Status: "unknown", Error: fmt.Sprintf("Search job %s belongs to another user", id)}
+		return SendJobStatus(status, sessID, timestamp)
+	}
+	if GetAttribute(msg, "action") == "cancel" {
+		if job.Cancel() {
+			log.Infof("Search job %s cancelled by session %s", id, sessID)
+		}
+	}
+	return SendJobStatus(job.Status(), sessID, timestamp)
+}
+
+// SENDS THE STATUS OF A JOB AS JSON THROUGH THE OUTBOX QUEUE (COMMAND 7)
+func SendJobStatus(status JobStatus, sessID string, timestamp string) error {
+	body, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("Could not encode job status: %v", err)
+	}
+	msgTX := &sqs.SendMessageInput{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"clientName": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(FirstNonEmpty(status.Client, "-")),
+			},
+			"sessionID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(sessID),
+			},
+			"timestamp": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(timestamp),
+			},
+			"cmd": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String("7"),
+			},
+			"jobID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(FirstNonEmpty(status.JobID, "-")),
+			},
+		},
+		MessageBody: aws.String(string(body)),
+		QueueUrl:    &outboxURL,
+	}
+	result, err := sqssvc.SendMessage(msgTX)
+	if err != nil {
+		log.Errorf("Could not send message to SQS queue: %v", err)
+	} else {
+		log.Debugf("Status of search job %s sent. MessageID: %v", status.JobID, *result.MessageId)
+	}
+	return nil
+}
+
+// KEEPS THE RESULTS OF ranked WHOSE LINES WERE ALSO RESULTS OF parent AND THE FIRST maxResults OF THEM. LINES ARE
+// COMPARED BY CONTENT SO A PARENT BUILT FROM AN OLDER VERSION OF THE CONVERSATION CAN STILL BE REFINED
+func RefineResults(ranked RankedSearch, parent RankedSearch, maxResults int) RankedSearch {
+	candidates := make(map[ConversationLine]bool, len(parent.Results))
+	for _, result := range parent.Results {
+		candidates[result.Line] = true
+	}
+	var results []SearchResult
+	for _, result := range ranked.Results {
+		if candidates[result.Line] {
+			results = append(results, result)
+		}
+	}
+	return RankedSearch{Results: RankResults(results, maxResults), TotalMatches: len(results)}
+}
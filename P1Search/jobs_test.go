@@ -0,0 +1,211 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSearchJobStore(t *testing.T) {
+	store := NewSearchJobStore(time.Minute, 0)
+	first, _ := store.Add(NewSearchJob("alice", "deploy", SearchOptions{}), "")
+	second, _ := store.Add(NewSearchJob("alice", "deploy", SearchOptions{}), first)
+	if len(first) != 16 || first == second {
+		t.Fatalf("job IDs %q and %q, want two different 16 hex digit IDs", first, second)
+	}
+	for proposed, kept := range map[string]bool{"myjob42": true, "bad id!": false, "abc": false} {
+		if id, _ := store.Add(NewSearchJob("alice", "x", SearchOptions{}), proposed); (id == proposed) != kept {
+			t.Errorf("proposed ID %q kept = %v, want %v", proposed, id == proposed, kept)
+		}
+	}
+
+	if job, ok := store.Get(first, "alice"); !ok || job.Sentence != "deploy" {
+		t.Errorf("Get(%s, alice) = %v %v", first, job, ok)
+	}
+	if _, ok := store.Get(first, "bob"); ok {
+		t.Errorf("job of alice returned to bob")
+	}
+	if _, ok := store.Find(first); !ok {
+		t.Errorf("Find(%s) did not return the job", first)
+	}
+}
+
+func TestSearchJobExpiry(t *testing.T) {
+	store := NewSearchJobStore(time.Minute, 0)
+	queued := NewSearchJob("alice", "deploy", SearchOptions{})
+	store.Add(queued, "")
+	done := NewSearchJob("alice", "deploy", SearchOptions{})
+	store.Add(done, "")
+	done.start()
+	done.finish(JobDone, RankedSearch{TotalMatches: 1}, nil, "")
+
+	// A job finished long after it was submitted lives ttl from its end
+	done.Created = time.Now().Add(-2 * time.Minute)
+	if _, ok := store.Find(done.ID); !ok {
+		t.Errorf("finished job expired before its TTL")
+	}
+	done.finished = time.Now().Add(-2 * time.Minute)
+	if _, ok := store.Find(done.ID); ok {
+		t.Errorf("finished job returned after its TTL")
+	}
+	queued.Created = time.Now().Add(-2 * time.Minute)
+	if _, ok := store.Find(queued.ID); ok {
+		t.Errorf("job still queued after its TTL returned")
+	}
+	if status := queued.Status(); status.Status != JobCancelled || queued.ctx.Err() == nil {
+		t.Errorf("expired queued job = %+v, want it cancelled so no worker runs it", status)
+	}
+}
+
+func TestSearchJobSweep(t *testing.T) {
+	store := NewSearchJobStore(time.Minute, 0)
+	jobs := make(map[string]*SearchJob)
+	for _, state := range []string{JobQueued, JobRunning, JobDone, JobFailed, "fresh"} {
+		jobs[state] = NewSearchJob("alice", state, SearchOptions{})
+		store.Add(jobs[state], "")
+	}
+	for state, job := range jobs {
+		if state == JobRunning {
+			job.start()
+		} else if state == JobDone || state == JobFailed {
+			job.finish(state, RankedSearch{TotalMatches: 1}, []ConversationLine{{Text: "deploy"}}, "")
+			job.finished = time.Now().Add(-2 * time.Minute)
+		}
+		if state != "fresh" {
+			job.Created = time.Now().Add(-2 * time.Minute)
+		}
+	}
+
+	// Nobody polls these jobs any more, the sweep alone must drop them and their results
+	if dropped := store.Sweep(); dropped != 4 {
+		t.Errorf("Sweep() dropped %d jobs, want 4", dropped)
+	}
+	if len(store.jobs) != 1 || store.jobs[jobs["fresh"].ID] == nil {
+		t.Errorf("jobs left after the sweep = %v, want only the fresh one", store.jobs)
+	}
+	if jobs[JobRunning].ctx.Err() == nil || jobs[JobQueued].Status().Status != JobCancelled {
+		t.Errorf("expired running or queued job not cancelled")
+	}
+	if store.Sweep() != 0 {
+		t.Errorf("second sweep dropped jobs again")
+	}
+}
+
+func TestSearchJobCap(t *testing.T) {
+	store := NewSearchJobStore(time.Minute, 2)
+	newJob := func(owner string) *SearchJob {
+		job := NewSearchJob("alice", "deploy", SearchOptions{})
+		job.Owner = owner
+		return job
+	}
+	oldest, next := newJob("alice"), newJob("alice")
+	store.Add(oldest, "")
+	oldest.Created = time.Now().Add(-time.Second)
+	store.Add(next, "")
+
+	// Both jobs of alice still queued: the third one is kept failed, without running
+	refused := newJob("alice")
+	if _, err := store.Add(refused, ""); err != ErrTooManyJobs {
+		t.Errorf("Add() over the cap error = %v, want %v", err, ErrTooManyJobs)
+	}
+	if status := refused.Status(); status.Status != JobFailed || status.Error == "" {
+		t.Errorf("job over the cap = %+v, want failed with a reason", status)
+	}
+	if _, err := store.Add(newJob("bob"), ""); err != nil {
+		t.Errorf("job of another user refused: %v", err)
+	}
+
+	// Once a job of alice finished, the oldest finished one makes room for the new one
+	oldest.finish(JobDone, RankedSearch{}, nil, "")
+	accepted := newJob("alice")
+	if _, err := store.Add(accepted, ""); err != nil {
+		t.Errorf("Add() with finished jobs to drop error = %v", err)
+	}
+	if _, ok := store.Find(oldest.ID); ok {
+		t.Errorf("oldest finished job kept over the cap")
+	}
+	if _, ok := store.Find(next.ID); !ok {
+		t.Errorf("queued job dropped to make room")
+	}
+}
+
+func TestSearchJobCancel(t *testing.T) {
+	job := NewSearchJob("alice", "deploy", SearchOptions{})
+	if !job.Cancel() {
+		t.Fatalf("queued job could not be cancelled")
+	}
+	if job.ctx.Err() == nil {
+		t.Errorf("context of a cancelled job still alive")
+	}
+	if job.Cancel() || job.start() {
+		t.Errorf("cancelled job cancelled again or started")
+	}
+	// A worker picking the cancelled job must leave it alone
+	RunSearchJob(job)
+	if status := job.Status(); status.Status != JobCancelled || JobStatusMessage(status) != "Search job  is cancelled" {
+		t.Errorf("status = %+v", status)
+	}
+
+	running := NewSearchJob("alice", "deploy", SearchOptions{})
+	running.start()
+	running.setProgress(2, 5, 3)
+	if !running.Cancel() || running.finish(JobDone, RankedSearch{TotalMatches: 9}, nil, "") {
+		t.Errorf("running job not cancelled or finished after being cancelled")
+	}
+	if status := running.Status(); status.Status != JobCancelled || status.SessionsScanned != 2 || status.Matches != 3 {
+		t.Errorf("status after cancel = %+v, want the progress kept", status)
+	}
+}
+
+func TestSearchJobMissingParent(t *testing.T) {
+	searchJobs = NewSearchJobStore(time.Minute, 0)
+	job := NewSearchJob("alice", "deploy", SearchOptions{Refine: "missing1"})
+	if _, _, err := SearchJobConversation(job, nil); err == nil {
+		t.Errorf("refinement of a missing job did not fail")
+	}
+
+	failed := NewSearchJob("alice", "x", SearchOptions{})
+	failed.finish(JobFailed, RankedSearch{}, nil, "boom")
+	if msg := JobStatusMessage(failed.Status()); msg != "boom" {
+		t.Errorf("JobStatusMessage() = %q, want the error", msg)
+	}
+}
+
+func TestRefineResults(t *testing.T) {
+	deploy := ConversationLine{Timestamp: "10-Mar-2024 09:00:00", Text: "deploy failed"}
+	again := ConversationLine{Timestamp: "10-Mar-2024 10:00:00", Text: "deploy failed again"}
+	lunch := ConversationLine{Timestamp: "10-Mar-2024 12:00:00", Text: "failed lunch"}
+	parent := RankedSearch{Results: []SearchResult{{Line: deploy}, {Line: again}}, TotalMatches: 2}
+	ranked := RankedSearch{Results: []SearchResult{{Line: lunch, Score: 3}, {Line: again, Score: 2}, {Line: deploy, Score: 1}}, TotalMatches: 3}
+
+	refined := RefineResults(ranked, parent, 0)
+	if refined.TotalMatches != 2 || len(refined.Results) != 2 {
+		t.Fatalf("RefineResults() = %v, want the two lines also matched by the parent", refined)
+	}
+	for _, result := range refined.Results {
+		if result.Line == lunch {
+			t.Errorf("line outside the parent results kept")
+		}
+	}
+	if limited := RefineResults(ranked, parent, 1); limited.TotalMatches != 2 || len(limited.Results) != 1 {
+		t.Errorf("RefineResults(max 1) = %d results of %d, want 1 of 2", len(limited.Results), limited.TotalMatches)
+	}
+}
+
+func TestSubmitSearchJobQueueFull(t *testing.T) {
+	searchJobs = NewSearchJobStore(time.Minute, 0)
+	searchQueue = make(chan *SearchJob, 1)
+	queued := NewSearchJob("alice", "deploy", SearchOptions{})
+	rejected := NewSearchJob("alice", "lunch", SearchOptions{})
+	SubmitSearchJob(queued, "")
+	SubmitSearchJob(rejected, "")
+
+	if got := <-searchQueue; got != queued {
+		t.Errorf("queued job = %v, want the first one", got)
+	}
+	if status := rejected.Status(); status.Status != JobFailed || status.Error == "" {
+		t.Errorf("job over the queue size = %+v, want failed with a reason", status)
+	}
+	if _, ok := searchJobs.Find(rejected.ID); !ok {
+		t.Errorf("rejected job not kept, its status could not be asked")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const timestampLayout = "02-Jan-2006 15:04:05"
+
+// ONE LINE OF A STORED CONVERSATION ([TIMESTAMP]|||[TEXT]), THE SESSION IT BELONGS TO AND ITS POSITION IN THAT
+// SESSION (FROM 1, COUNTING ONLY WELL FORMED LINES)
+type ConversationLine struct {
+	Session   string
+	Line      int
+	Timestamp string
+	Text      string
+}
+
+// ONE RANKED MATCH. Index IS THE POSITION OF THE LINE IN THE COMBINED CONVERSATION, Offsets ARE
+// [START, END) BYTE POSITIONS OF EVERY MATCH INSIDE Text AND Variants THE WORDS MATCHED BY A FUZZY SEARCH
+type SearchResult struct {
+	Index    int
+	Line     ConversationLine
+	Score    float64
+	Offsets  [][2]int
+	Variants []string
+}
+
+// SPLITS A RAW CONVERSATION LINE IN TIMESTAMP AND TEXT. RETURNS FALSE IF THE LINE IS NOT WELL FORMED
+func ParseConversationLine(raw string) (ConversationLine, bool) {
+	columns := strings.SplitN(raw, "|||", 2)
+	if len(columns) != 2 {
+		return ConversationLine{}, false
+	}
+	return ConversationLine{Timestamp: columns[0], Text: columns[1]}, true
+}
+
+// RETURNS THE POSITION OF EVERY (NON OVERLAPPING) OCCURRENCE OF sentence IN text
+func FindMatchOffsets(text string, sentence string) [][2]int {
+	var offsets [][2]int
+	if sentence == "" {
+		return offsets
+	}
+	start := 0
+	for {
+		i := strings.Index(text[start:], sentence)
+		if i < 0 {
+			break
+		}
+		offsets = append(offsets, [2]int{start + i, start + i + len(sentence)})
+		start += i + len(sentence)
+	}
+	return offsets
+}
+
+// SCORES A MATCHING LINE: TERM FREQUENCY PLUS A RECENCY BONUS THAT DECAYS EXPONENTIALLY WITH THE AGE OF THE LINE
+func ScoreLine(line ConversationLine, termFrequency int, now time.Time, recencyWeight float64, halfLife time.Duration) float64 {
+	score := float64(termFrequency)
+	t, err := time.ParseInLocation(timestampLayout, line.Timestamp, time.Local)
+	if err != nil || halfLife <= 0 {
+		return score
+	}
+	age := now.Sub(t)
+	if age < 0 {
+		age = 0
+	}
+	return score + recencyWeight*math.Pow(0.5, float64(age)/float64(halfLife))
+}
+
+// SORTS RESULTS BY SCORE (HIGHEST FIRST, OLDER LINES FIRST ON TIES) AND KEEPS ONLY THE FIRST maxResults. maxResults <= 0 KEEPS THEM ALL
+func RankResults(results []SearchResult, maxResults int) []SearchResult {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if maxResults > 0 && len(results) > maxResults {
+		results = results[:maxResults]
+	}
+	return results
+}
+
+// ENCODES A RESULT AS [TIMESTAMP]|||[TEXT]|||[SCORE]|||[START]-[END],[START]-[END]...|||[VARIANT],[VARIANT]...
+// |||[SESSION]|||[LINE]
+func FormatSearchResult(result SearchResult) string {
+	offsets := make([]string, 0, len(result.Offsets))
+	for _, o := range result.Offsets {
+		offsets = append(offsets, fmt.Sprintf("%d-%d", o[0], o[1]))
+	}
+	return fmt.Sprintf("%s|||%s|||%.3f|||%s|||%s|||%s|||%d", result.Line.Timestamp, result.Line.Text, result.Score, strings.Join(offsets, ","), strings.Join(result.Variants, ","), result.Line.Session, result.Line.Line)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindMatchOffsets(t *testing.T) {
+	tests := []struct {
+		text     string
+		sentence string
+		want     [][2]int
+	}{
+		{"hello world", "bye", nil},
+		{"hello world", "", nil},
+		{"hello world", "world", [][2]int{{6, 11}}},
+		{"ab ab ab", "ab", [][2]int{{0, 2}, {3, 5}, {6, 8}}},
+		{"aaaa", "aa", [][2]int{{0, 2}, {2, 4}}},
+		{"Hello hello", "hello", [][2]int{{6, 11}}},
+	}
+	for _, tt := range tests {
+		if got := FindMatchOffsets(tt.text, tt.sentence); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("FindMatchOffsets(%q, %q) = %v, want %v", tt.text, tt.sentence, got, tt.want)
+		}
+	}
+}
+
+func TestScoreLineRecency(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.Local)
+	line := func(timestamp string) ConversationLine {
+		return ConversationLine{Timestamp: timestamp, Text: "text"}
+	}
+
+	if got := ScoreLine(line("10-Mar-2024 12:00:00"), 1, now, 1, 24*time.Hour); got != 2 {
+		t.Errorf("a line written now scores %v, want 2", got)
+	}
+	if got := ScoreLine(line("09-Mar-2024 12:00:00"), 1, now, 1, 24*time.Hour); got != 1.5 {
+		t.Errorf("a line one half life old scores %v, want 1.5", got)
+	}
+	if got := ScoreLine(line("08-Mar-2024 12:00:00"), 2, now, 2, 24*time.Hour); got != 2.5 {
+		t.Errorf("a line two half lives old scores %v, want 2.5", got)
+	}
+	if got := ScoreLine(line("11-Mar-2024 12:00:00"), 1, now, 1, 24*time.Hour); got != 2 {
+		t.Errorf("a line from the future scores %v, want 2 like a new one", got)
+	}
+	if got := ScoreLine(line("yesterday"), 3, now, 1, 24*time.Hour); got != 3 {
+		t.Errorf("a line with a malformed timestamp scores %v, want only its term frequency 3", got)
+	}
+	if got := ScoreLine(line("10-Mar-2024 12:00:00"), 3, now, 1, 0); got != 3 {
+		t.Errorf("without half life a line scores %v, want only its term frequency 3", got)
+	}
+}
+
+func TestRankResults(t *testing.T) {
+	results := []SearchResult{
+		{Line: ConversationLine{Text: "a"}, Score: 1},
+		{Line: ConversationLine{Text: "b"}, Score: 3},
+		{Line: ConversationLine{Text: "c"}, Score: 2},
+		{Line: ConversationLine{Text: "d"}, Score: 3},
+	}
+	texts := func(results []SearchResult) string {
+		s := ""
+		for _, result := range results {
+			s += result.Line.Text
+		}
+		return s
+	}
+
+	if got := texts(RankResults(append([]SearchResult(nil), results...), 0)); got != "bdca" {
+		t.Errorf("RankResults() order = %q, want highest first and older first on ties (bdca)", got)
+	}
+	if got := texts(RankResults(append([]SearchResult(nil), results...), 2)); got != "bd" {
+		t.Errorf("RankResults(2) = %q, want bd", got)
+	}
+	if got := RankResults(nil, 3); len(got) != 0 {
+		t.Errorf("RankResults(nil) = %v, want no results", got)
+	}
+}
+
+func TestFormatSearchResult(t *testing.T) {
+	result := SearchResult{
+		Line:    ConversationLine{Timestamp: "10-Mar-2024 12:00:00", Text: "hello hello", Session: "Ab12Cd", Line: 7},
+		Score:   2.5,
+		Offsets: [][2]int{{0, 5}, {6, 11}},
+	}
+	want := "10-Mar-2024 12:00:00|||hello hello|||2.500|||0-5,6-11||||||Ab12Cd|||7"
+	if got := FormatSearchResult(result); got != want {
+		t.Errorf("FormatSearchResult() = %q, want %q", got, want)
+	}
+
+	result.Variants = []string{"helo", "hallo"}
+	want = "10-Mar-2024 12:00:00|||hello hello|||2.500|||0-5,6-11|||helo,hallo|||Ab12Cd|||7"
+	if got := FormatSearchResult(result); got != want {
+		t.Errorf("FormatSearchResult() of a fuzzy match = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// COMMANDS OF THIS APP WITH A RATE LIMIT, READ FROM ratelimit.[NAME].perminute AND ratelimit.[NAME].burst. NEW
+// SEARCHES AND PAGES OF FINISHED ONES ARE LIMITED APART SINCE ONLY THE FIRST SCAN THE WHOLE CONVERSATION
+var rateLimitNames = []string{"search", "pages", "stats", "jobs"}
+
+// NAME OF THE RATE LIMIT OF A MESSAGE, "" IF IT HAS NONE
+func RateLimitName(cmd int, msg *sqs.Message) string {
+	if cmd == 2 && GetAttribute(msg, "jobID") == "" {
+		return "search"
+	} else if cmd == 2 {
+		return "pages"
+	} else if cmd == 4 {
+		return "stats"
+	} else if cmd == 7 {
+		return "jobs"
+	}
+	return ""
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSearchRateLimitName(t *testing.T) {
+	cases := map[string]struct {
+		cmd        int
+		attributes map[string]string
+	}{
+		"search": {2, nil},
+		"pages":  {2, map[string]string{"jobID": "abc123"}},
+		"stats":  {4, nil},
+		"jobs":   {7, map[string]string{"jobID": "abc123"}},
+		"":       {3, nil},
+	}
+	for want, c := range cases {
+		if got := RateLimitName(c.cmd, searchMessage(c.attributes)); got != want {
+			t.Errorf("RateLimitName(%d, %v) = %q, want %q", c.cmd, c.attributes, got, want)
+		}
+	}
+}
+
+func TestRateLimiterPerUser(t *testing.T) {
+	l := NewRateLimiter(map[string]RateLimit{"search": {PerMinute: 6, Burst: 2}})
+	l.Allow("alice", "search")
+	l.Allow("alice", "search")
+	ok, wait := l.Allow("alice", "search")
+	if ok || wait < 9*time.Second || wait > 10*time.Second {
+		t.Errorf("third search of alice = %v, retry in %v, want rejected for 10s", ok, wait)
+	}
+	if ok, _ := l.Allow("bob", "search"); !ok {
+		t.Errorf("bob limited by the searches of alice")
+	}
+	if ok, _ := l.Allow("alice", "stats"); !ok {
+		t.Errorf("stats limited by the searches")
+	}
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	aws "github.com/aws/aws-sdk-go/aws"
 	session "github.com/aws/aws-sdk-go/aws/session"
@@ -26,14 +30,25 @@ var sess *session.Session = session.Must(session.NewSessionWithOptions(session.O
 }))
 var sqssvc *sqs.SQS = sqs.New(sess)
 var s3svc *s3.S3 = s3.New(sess)
+var searchCache *SearchCache
+var searchJobs *SearchJobStore
+var searchQueue chan *SearchJob
+var downloadMu sync.Mutex
+
+// RETURNED BY ProcessRXMessage FOR MESSAGES LEFT IN THE INBOX FOR THE ECHO APP
+var errOtherApp = errors.New("This message was not for the search app.")
 
 func main() {
-	initConfig() // Set config file, logs and queues URLs
+	initConfig()                                        // Set config file, logs and queues URLs
+	StartSearchWorkers(viper.GetInt("search.workers"))  // Run the searches in background
+	StartHealthServer(viper.GetString("health.listen")) // Serve /healthz, /readyz and /metrics
 
 	// MAIN LOOP (RECEIVE, PROCESS AND DELETE IF PROCESSED, IF NOT GO BACK TO RECEIVE)
 	for {
+		Metrics.Polled()
 		RXmsg := &sqs.ReceiveMessageInput{
-			MessageAttributeNames: aws.StringSlice([]string{"clientName", "timestamp", "sessionID", "cmd"}),
+			MessageAttributeNames: aws.StringSlice([]string{"clientName", "timestamp", "sessionID", "cmd", "maxResults", "before", "after", "fuzzy", "maxDistance", "pageSize", "cursor", "similar", "jobID", "refine", "newJob", "subscribe", "action", "token"}),
+			AttributeNames:        aws.StringSlice([]string{"SentTimestamp"}),
 			QueueUrl:              &inboxURL,
 			MaxNumberOfMessages:   aws.Int64(1),
 			WaitTimeSeconds:       aws.Int64(1),
@@ -51,9 +66,15 @@ func main() {
 		}
 
 		// Process message
+		start := time.Now()
+		cmd := Metrics.Received(resultRX.Messages[0])
 		err = ProcessRXMessage(resultRX.Messages[0])
-		if err != nil {
+		if err == errOtherApp {
+			Metrics.Inc(metricSkipped, cmd)
+			continue
+		} else if err != nil {
 			log.Errorf("Could not process message: %v", err)
+			Metrics.Finished(resultRX.Messages[0], cmd, start, err)
 			continue
 		}
 
@@ -62,6 +83,7 @@ func main() {
 			QueueUrl:      &inboxURL,
 			ReceiptHandle: resultRX.Messages[0].ReceiptHandle,
 		})
+		Metrics.Finished(resultRX.Messages[0], cmd, start, err)
 
 		if err != nil {
 			log.Errorf("Error when trying to delete message after processing: %v", err)
@@ -133,11 +155,31 @@ func initConfig() {
 	inboxURL = viper.GetString("sqs.inboxURL")
 	outboxURL = viper.GetString("sqs.outboxURL")
 
+	// SECRET SHARED WITH THE ECHO APP TO VERIFY SESSION TOKENS
+	var err error
+	tokenSecret, err = ReadTokenSecret()
+	if err != nil {
+		log.Errorf("[INIT] %v", err)
+		os.Exit(1)
+	}
+
+	// RATE LIMITS PER USER AND COMMAND
+	Limits = NewRateLimiter(ReadRateLimits())
+
+	// SEARCH CACHE
+	searchCache = NewSearchCache(time.Duration(viper.GetInt("search.cachettlseconds"))*time.Second, viper.GetInt("search.cachemaxentries"))
+	searchJobs = NewSearchJobStore(time.Duration(viper.GetInt("search.jobttlseconds"))*time.Second, viper.GetInt("search.maxjobsperuser"))
+	searchJobs.StartSweeper(time.Duration(viper.GetInt("search.jobsweepseconds")) * time.Second)
+	searchQueue = make(chan *SearchJob, viper.GetInt("search.queuesize"))
+
 	return
 }
 
-// CHECK IF MSG IS FOR SEARCH APP, DOWNLOAD ALL SESSIONS FOR THAT CLIENT, FILTER THEM USING THE KEY SENTENCE
-// AND COMBINE THEM IN ONE FILE BEFORE SENDING IT TO THE CLIENT THROUGH OUTBOX QUEUE. REMOVES ALL TEMPORAL FILES AFTER SENDING TO SQS.
+// CHECK IF MSG IS FOR SEARCH APP. A NEW SEARCH IS QUEUED AS A JOB FOR THE SEARCH WORKERS, WHICH DOWNLOAD ALL SESSIONS
+// FOR THAT CLIENT AND FILTER THEM USING THE KEY SENTENCE. PAGES OF FINISHED JOBS ARE SENT TO THE CLIENT THROUGH OUTBOX QUEUE.
+// STATISTICS REQUESTS (COMMAND 4) ARE ALSO ANSWERED HERE SINCE THEY NEED THE SAME CONVERSATION, AND SO ARE THE
+// STATUS AND CANCELLATION REQUESTS OF THE SEARCH JOBS (COMMAND 7). EVERY COMMAND MUST CARRY A VALID TOKEN OF A USER
+// ALLOWED TO READ THE CONVERSATION OR USE THE JOB IT NAMES.
 // RETURNS ERROR IF THE MESSAGE WAS NOT FOR THE SEARCH APP OR SOMETHING WENT WRONG
 func ProcessRXMessage(msg *sqs.Message) error {
 	cmd := *msg.MessageAttributes["cmd"].StringValue
@@ -146,79 +188,255 @@ func ProcessRXMessage(msg *sqs.Message) error {
 	timestamp := *msg.MessageAttributes["timestamp"].StringValue
 	log.Infof("New message received. Client: %s\tCommand: %s", clientName, cmd)
 	cRX, _ := strconv.Atoi(cmd)
+	var claims TokenClaims
+	if cRX == 2 || cRX == 4 || cRX == 7 {
+		var err error
+		claims, err = Authenticate(msg)
+		if err != nil {
+			log.Warnf("Rejecting command %s over %s: %v", cmd, clientName, err)
+			return SendUnauthorized(msg, err)
+		}
+		log.Infof("Command %s over %s sent by %s", cmd, clientName, claims.User)
+	}
+	if (cRX == 2 || cRX == 4) && !claims.CanAccess(clientName) {
+		log.Warnf("Rejecting command %s of %s: no access to the conversation of %s", cmd, claims.User, clientName)
+		return SendUnauthorized(msg, fmt.Errorf("User %s may not access the conversation of %s", claims.User, clientName))
+	}
+	// A NEW SEARCH OVER ITS LIMIT IS STILL STORED AS A FAILED JOB SO A CLIENT POLLING ITS STATUS LEARNS WHY
+	name := RateLimitName(cRX, msg)
+	allowed, wait := Limits.Allow(claims.User, name)
+	if !allowed {
+		log.Warnf("Rejecting command %s of %s: rate limit of %s reached", cmd, claims.User, name)
+		if name != "search" {
+			return SendRejected(msg, RateLimitedBody, RateLimitMessage(name, wait), wait)
+		}
+	}
 	// SEARCH
 	if cRX == 2 {
-		text := *msg.Body
-		err := DownloadConversation(clientName)
-		if err != nil {
-			return fmt.Errorf("Could not download conversation %v", err)
+		opts := ParseSearchOptions(msg)
+		if opts.JobID == "" {
+			if parent, ok := searchJobs.Find(opts.Refine); ok && !claims.CanUseJob(parent) {
+				return SendUnauthorized(msg, fmt.Errorf("Search job %s belongs to another user", opts.Refine))
+			}
+			job := NewSearchJob(clientName, *msg.Body, opts)
+			job.Owner = claims.User
+			job.SessID, job.Timestamp = sessID, timestamp
+			job.Subscribe = GetAttribute(msg, "subscribe") == "true"
+			if !allowed {
+				RejectSearchJob(job, GetAttribute(msg, "newJob"), RateLimitMessage(name, wait))
+				return nil
+			}
+			SubmitSearchJob(job, GetAttribute(msg, "newJob"))
+			return nil
+		}
+		// PAGE OF A STORED JOB
+		job, ok := searchJobs.Get(opts.JobID, clientName)
+		if !ok {
+			return SendSearchPage(nil, sessID, timestamp, opts, fmt.Sprintf("Search job %s does not exist or expired", opts.JobID))
 		}
-		filtFileName, err := CreateFilteredConversationFile(clientName, text)
+		if !claims.CanUseJob(job) {
+			return SendSearchPage(nil, sessID, timestamp, opts, fmt.Sprintf("Search job %s belongs to another user", opts.JobID))
+		}
+		if status := job.Status(); status.Status != JobDone {
+			return SendSearchPage(nil, sessID, timestamp, opts, JobStatusMessage(status))
+		}
+		return SendSearchPage(job, sessID, timestamp, opts, "")
+	} else if cRX == 4 { // STATS
+		return ProcessStatsMessage(msg)
+	} else if cRX == 7 { // SEARCH JOB STATUS AND CANCELLATION
+		return ProcessJobMessage(msg, claims)
+	} else {
+		sqssvc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          &inboxURL,
+			ReceiptHandle:     msg.ReceiptHandle,
+			VisibilityTimeout: aws.Int64(0),
+		})
+		return errOtherApp
+	}
+}
+
+// SENDS THE REQUESTED PAGE OF A FINISHED JOB THROUGH THE OUTBOX QUEUE (COMMAND 2). WITHOUT A JOB errMsg IS SENT
+// INSTEAD, TELLING THE CLIENT WHY THERE IS NO PAGE
+func SendSearchPage(job *SearchJob, sessID string, timestamp string, opts SearchOptions, errMsg string) error {
+	client := "-"
+	filtFileStr := "EMPTY CONVERSATION"
+	var page SearchPage
+	if job != nil {
+		client = job.Client
+		ranked, lines := job.Result()
+		filtFileName, jobPage, err := CreateFilteredConversationFile(client+"_"+job.ID, lines, ranked, job.Fingerprint, opts)
 		if err != nil {
 			return fmt.Errorf("Could not filter file: %v", err)
 		}
+		page = jobPage
 
 		filtFile, err := ioutil.ReadFile(filtFileName)
 		if err != nil {
 			return fmt.Errorf("Could not read filtered file before sending it to SQS: %v", err)
 		}
-		filtFileStr := string(filtFile)
-		if filtFileStr == "" {
-			filtFileStr = "EMPTY CONVERSATION"
-		}
-
-		msgTX := &sqs.SendMessageInput{
-			MessageAttributes: map[string]*sqs.MessageAttributeValue{
-				"clientName": &sqs.MessageAttributeValue{
-					DataType:    aws.String("String"),
-					StringValue: aws.String(clientName),
-				},
-				"sessionID": &sqs.MessageAttributeValue{
-					DataType:    aws.String("String"),
-					StringValue: aws.String(sessID),
-				},
-				"timestamp": &sqs.MessageAttributeValue{
-					DataType:    aws.String("String"),
-					StringValue: aws.String(timestamp),
-				},
-				"cmd": &sqs.MessageAttributeValue{
-					DataType:    aws.String("String"),
-					StringValue: aws.String(cmd),
-				},
+		DeleteTemporalConversation(client + "_" + job.ID + "_filtered")
+		if len(filtFile) > 0 {
+			filtFileStr = string(filtFile)
+		}
+	}
+
+	msgTX := &sqs.SendMessageInput{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"clientName": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(client),
+			},
+			"sessionID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(sessID),
+			},
+			"timestamp": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(timestamp),
+			},
+			"cmd": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String("2"),
+			},
+			"totalMatches": &sqs.MessageAttributeValue{
+				DataType:    aws.String("Number"),
+				StringValue: aws.String(strconv.Itoa(page.TotalMatches)),
 			},
-			MessageBody: aws.String(filtFileStr),
-			QueueUrl:    &outboxURL,
+		},
+		MessageBody: aws.String(filtFileStr),
+		QueueUrl:    &outboxURL,
+	}
+	if job != nil {
+		msgTX.MessageAttributes["jobID"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(job.ID),
 		}
-		DeleteTemporalConversation(clientName + "_filtered")
-		log.Infof("Sending filtered conversation to %s", clientName)
-		result, err := sqssvc.SendMessage(msgTX)
-		if err != nil {
-			log.Errorf("Could not send message to SQS queue: %v", err)
-		} else {
-			log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
-			newFileName := viper.GetString("s3.conversationspath") + "/" + clientName + ".txt"
-			os.Remove(newFileName)
+	} else {
+		msgTX.MessageAttributes["error"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(errMsg),
 		}
+	}
+	// SQS rejects empty attribute values, so cursors are only sent when there is a page in that direction
+	if page.NextCursor != "" {
+		msgTX.MessageAttributes["nextCursor"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(page.NextCursor),
+		}
+	}
+	if page.PrevCursor != "" {
+		msgTX.MessageAttributes["prevCursor"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(page.PrevCursor),
+		}
+	}
+	log.Infof("Sending filtered conversation to %s", client)
+	result, err := sqssvc.SendMessage(msgTX)
+	if err != nil {
+		log.Errorf("Could not send message to SQS queue: %v", err)
 	} else {
-		sqssvc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
-			QueueUrl:          &inboxURL,
-			ReceiptHandle:     msg.ReceiptHandle,
-			VisibilityTimeout: aws.Int64(0),
-		})
-		return fmt.Errorf("This message was not for the search app.")
+		log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
 	}
 	return nil
 }
 
-// DOWNLOAD FILE FROM S3 AND STORE IT IN LOCAL FOLDER conversations
-func DownloadConversation(client string) error {
+// RANKS THE LINES OF THE CONVERSATION OF client MATCHING sentence, REPORTING THE PROGRESS AFTER EVERY SESSION.
+// THE MATCHES ARE ONLY COMPUTED AGAIN WHEN THE SESSIONS STORED IN S3 CHANGED SINCE THEY WERE CACHED.
+// RETURNS ctx.Err() IF ctx IS CANCELLED BEFORE THE SEARCH ENDS
+func SearchConversation(ctx context.Context, client string, sentence string, opts SearchOptions, progress ProgressFunc) (RankedSearch, []ConversationLine, error) {
+	lines, version, err := LoadConversation(client)
+	if err != nil {
+		return RankedSearch{}, nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return RankedSearch{}, nil, err
+	}
+
+	key := SearchCacheKey(client, sentence, opts)
+	ranked, ok := searchCache.GetResults(key, version)
+	if !ok {
+		var match LineMatcher
+		if opts.Similar {
+			match = searchCache.GetIndex(client, version, lines).Matcher(sentence, viper.GetFloat64("search.minsimilarity"))
+		} else {
+			match = TextMatcher(sentence, opts)
+		}
+		results, err := ScanConversation(ctx, lines, match, progress)
+		if err != nil {
+			return RankedSearch{}, nil, err
+		}
+		ranked = RankedSearch{Results: RankResults(results, opts.MaxResults), TotalMatches: len(results)}
+		searchCache.PutResults(key, version, ranked)
+	} else {
+		sessions := CountSessions(lines)
+		progress(sessions, sessions, ranked.TotalMatches)
+	}
+	stats := searchCache.Stats()
+	if ok {
+		log.Infof("Search cache hit for %s", client)
+	} else {
+		log.Infof("Search cache miss for %s", client)
+	}
+	log.Infof("Search cache stats. Hits: %d\tMisses: %d\tDownloads: %d\tInvalidations: %d", stats.Hits, stats.Misses, stats.Downloads, stats.Invalidations)
+	return ranked, lines, nil
+}
+
+// RETURNS EVERY LINE OF THE CONVERSATION OF client AND THE VERSION THEY WERE READ FROM. THE SESSIONS ARE ONLY
+// DOWNLOADED AGAIN WHEN THEY CHANGED IN S3 SINCE THEY WERE CACHED
+func LoadConversation(client string) ([]ConversationLine, string, error) {
+	items, err := ListConversationSessions(client)
+	if err != nil {
+		return nil, "", fmt.Errorf("Could not list conversation sessions: %v", err)
+	}
+	version := ConversationVersion(items)
+
+	// Sessions are downloaded to fixed paths, so only one conversation is downloaded at a time
+	downloadMu.Lock()
+	defer downloadMu.Unlock()
+	lines, ok := searchCache.GetConversation(client, version)
+	if !ok {
+		err = DownloadConversation(client, items)
+		if err != nil {
+			return nil, "", fmt.Errorf("Could not download conversation %v", err)
+		}
+		lines, err = LoadConversationLines(items)
+		if err != nil {
+			return nil, "", fmt.Errorf("Could not read conversation: %v", err)
+		}
+		searchCache.PutConversation(client, version, lines)
+	}
+	return lines, version, nil
+}
+
+// LIST ALL SESSIONS FOR THE CLIENT STORED IN S3 ([S3_CONVERSATIONS_PATH]/[USERNAME]_[SESSION_ID].txt)
+func ListConversationSessions(client string) (*s3.ListObjectsV2Output, error) {
 	bucketname := viper.GetString("s3.bucketname")
-	// List all sessions for the client stored in S3
-	resp, err := s3svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(bucketname), Prefix: aws.String(viper.GetString("s3.conversationspath") + "/" + client)})
+	prefix := viper.GetString("s3.conversationspath") + "/" + client + "_"
+	resp := &s3.ListObjectsV2Output{}
+	err := s3svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String(bucketname), Prefix: aws.String(prefix)}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, item := range page.Contents {
+			if IsSessionOf(prefix, *item.Key) {
+				resp.Contents = append(resp.Contents, item)
+			}
+		}
+		return true
+	})
 	if err != nil {
-		return fmt.Errorf("Unable to list items in bucket %q, %v", bucketname, err)
+		return nil, fmt.Errorf("Unable to list items in bucket %q, %v", bucketname, err)
 	}
+	return resp, nil
+}
 
+// TRUE IF key IS A SESSION FILE OF THE USER OF prefix ([S3_CONVERSATIONS_PATH]/[USERNAME]_). THE PREFIX ALSO MATCHES
+// [S3_CONVERSATIONS_PATH]/[USERNAME]_[OTHER]_[SESSION_ID].txt, WHICH BELONGS TO ANOTHER USER
+func IsSessionOf(prefix string, key string) bool {
+	name := strings.TrimSuffix(strings.TrimPrefix(key, prefix), ".txt")
+	return strings.HasPrefix(key, prefix) && name != "" && !strings.Contains(name, "_")
+}
+
+// DOWNLOAD THE LISTED SESSIONS FROM S3 AND STORE THEM IN LOCAL FOLDER conversations
+func DownloadConversation(client string, resp *s3.ListObjectsV2Output) error {
+	bucketname := viper.GetString("s3.bucketname")
 	// Create a downloader with the session and default options
 	downloader := s3manager.NewDownloader(sess)
 	// Iterate over the session list for the client and download them to local path conversations
@@ -242,37 +460,46 @@ func DownloadConversation(client string) error {
 			log.Errorf("Failed to download file %s, %v", downloadPath, err)
 		}
 	}
-
-	// Combine all sessions in one file and remove them
-	err = CombineSessionsToFile(client, resp)
-	if err != nil {
-		return fmt.Errorf("Could not combine sessions to file: %v", err)
-	}
 	log.Infof("Whole conversation of client %s has been downloaded.", client)
 	return nil
 }
 
-// COMBINES ALL LOCAL (ALREADY DOWNLOADED) SESSIONS FOR A CLIENT IN ONE FILE AND DELETES THEM WHEN DONE, LEAVING ONLY THE COMBINED FILE
-func CombineSessionsToFile(client string, items *s3.ListObjectsV2Output) error {
-	newFileName := viper.GetString("s3.conversationspath") + "/" + client + ".txt"
-	os.Remove(newFileName)
-	newFile, err := os.OpenFile(newFileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
-	defer newFile.Close()
+// DELETES A TEMPORAL FILE FROM LOCAL PATH conversations
+func DeleteTemporalConversation(client string) error {
+	err := os.Remove(fmt.Sprintf("%s/%s.txt", viper.GetString("s3.conversationspath"), client))
+
 	if err != nil {
-		return fmt.Errorf("Failed to create file %q: %v", newFileName, err)
+		log.Errorf("Could not delete temporal conversation file for client %s: %v", client, err)
+		return err
 	}
+	return nil
+}
+
+// READS ALL LOCAL (ALREADY DOWNLOADED) SESSIONS LINE BY LINE, TAGGING EVERY LINE WITH ITS SESSION, AND DELETES THEM WHEN DONE
+func LoadConversationLines(items *s3.ListObjectsV2Output) ([]ConversationLine, error) {
+	var lines []ConversationLine
 	for _, item := range items.Contents {
+		sessionID := SessionFromKey(*item.Key)
 		pieceFile, err := os.Open(*item.Key)
 		if err != nil {
 			log.Warnf("Failed to open piece file for reading: %v", err)
+			continue
 		}
-		defer pieceFile.Close()
-
-		n, err := io.Copy(newFile, pieceFile)
-		if err != nil {
-			log.Warnf("Failed to append piece file to big file: %v", err)
+		scanner := bufio.NewScanner(pieceFile)
+		position := 0
+		for scanner.Scan() {
+			line, ok := ParseConversationLine(scanner.Text())
+			if !ok {
+				continue
+			}
+			position++
+			line.Session = sessionID
+			line.Line = position
+			lines = append(lines, line)
+		}
+		if err := scanner.Err(); err != nil {
+			log.Warnf("Failed to read piece file %s: %v", *item.Key, err)
 		}
-		log.Infof("Wrote %d bytes of %s to the end of %s\n", n, *item.Key, newFileName)
 
 		// Delete the old input file
 		pieceFile.Close()
@@ -280,56 +507,183 @@ func CombineSessionsToFile(client string, items *s3.ListObjectsV2Output) error {
 			log.Errorf("Failed to remove piece file %s: %v", *item.Key, err)
 		}
 	}
-	newFile.Close()
-	return nil
+	return lines, nil
 }
 
-// DELETES A TEMPORAL FILE FROM LOCAL PATH conversations
-func DeleteTemporalConversation(client string) error {
-	err := os.Remove(fmt.Sprintf("%s/%s.txt", viper.GetString("s3.conversationspath"), client))
+// EXTRACTS THE SESSION ID FROM A SESSION KEY ([S3_CONVERSATIONS_PATH]/[USERNAME]_[SESSION_ID].txt)
+func SessionFromKey(key string) string {
+	name := strings.TrimSuffix(key[strings.LastIndex(key, "/")+1:], ".txt")
+	return name[strings.LastIndex(name, "_")+1:]
+}
 
-	if err != nil {
-		log.Errorf("Could not delete temporal conversation file for client %s: %v", client, err)
-		return err
+// REPORTS HOW MANY SESSIONS OF A CONVERSATION WERE SCANNED AND HOW MANY MATCHES WERE FOUND SO FAR
+type ProgressFunc func(sessionsScanned int, totalSessions int, matches int)
+
+// DECIDES IF THE LINE AT index MATCHES A QUERY AND SCORES IT
+type LineMatcher func(index int, line ConversationLine) (SearchResult, bool)
+
+// MATCHES THE LINES CONTAINING sentence (APPROXIMATELY IN FUZZY MODE), SCORED BY TERM FREQUENCY AND RECENCY
+func TextMatcher(sentence string, opts SearchOptions) LineMatcher {
+	now := time.Now()
+	recencyWeight := viper.GetFloat64("search.recencyweight")
+	halfLife := time.Duration(viper.GetFloat64("search.recencyhalflifehours") * float64(time.Hour))
+	return func(index int, line ConversationLine) (SearchResult, bool) {
+		var offsets [][2]int
+		var variants []string
+		if opts.Fuzzy {
+			offsets, variants = FindFuzzyMatches(line.Text, sentence, opts.MaxDistance)
+		} else {
+			offsets = FindMatchOffsets(line.Text, sentence)
+		}
+		if len(offsets) == 0 {
+			return SearchResult{}, false
+		}
+		return SearchResult{
+			Index:    index,
+			Line:     line,
+			Score:    ScoreLine(line, len(offsets), now, recencyWeight, halfLife),
+			Offsets:  offsets,
+			Variants: variants,
+		}, true
 	}
-	return nil
 }
 
-// READS THE CLIENT COMBINED FILE LINE BY LINE AND APPENDS EVERY LINE CONTAINING sentence TO ANOTHER ONE AND REMOVES THE COMBINED ONE WHEN DONE
-func CreateFilteredConversationFile(client string, sentence string) (string, error) {
-	mainFileName := viper.GetString("s3.conversationspath") + "/" + client + ".txt"
-	mainFile, err := os.Open(mainFileName)
-	if err != nil {
-		mainFile.Close()
-		os.Remove(mainFileName)
-		return "", fmt.Errorf("Failed to open main file %s for filtering: %v", mainFileName, err)
+// RUNS match OVER EVERY LINE, ONE SESSION AFTER ANOTHER. THE PROGRESS IS REPORTED AFTER EVERY SESSION AND THE SCAN
+// STOPS WITH ctx.Err() AS SOON AS ctx IS CANCELLED
+func ScanConversation(ctx context.Context, lines []ConversationLine, match LineMatcher, progress ProgressFunc) ([]SearchResult, error) {
+	total := CountSessions(lines)
+	scanned := 0
+	var results []SearchResult
+	for index, line := range lines {
+		if index > 0 && line.Session != lines[index-1].Session {
+			scanned++
+			progress(scanned, total, len(results))
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if result, ok := match(index, line); ok {
+			results = append(results, result)
+		}
 	}
-	defer mainFile.Close()
+	progress(total, total, len(results))
+	return results, nil
+}
+
+// NUMBER OF SESSIONS OF A CONVERSATION. THE LINES OF EVERY SESSION ARE CONTIGUOUS
+func CountSessions(lines []ConversationLine) int {
+	sessions := 0
+	for index, line := range lines {
+		if index == 0 || line.Session != lines[index-1].Session {
+			sessions++
+		}
+	}
+	return sessions
+}
 
+// WRITES THE REQUESTED PAGE OF RANKED RESULTS (WITH THEIR CONTEXT LINES IF REQUESTED) TO THE CLIENT FILTERED FILE.
+// RETURNS THE FILTERED FILE NAME, THE TOTAL NUMBER OF MATCHES AND THE CURSORS OF THE NEIGHBOUR PAGES
+func CreateFilteredConversationFile(client string, lines []ConversationLine, ranked RankedSearch, fingerprint string, opts SearchOptions) (string, SearchPage, error) {
+	page := SearchPage{TotalMatches: ranked.TotalMatches}
 	filtFileName := viper.GetString("s3.conversationspath") + "/" + client + "_filtered.txt"
 	filtFile, err := os.OpenFile(filtFileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
-	defer filtFile.Close()
 	if err != nil {
-		filtFile.Close()
-		os.Remove(mainFileName)
-		return "", fmt.Errorf("Failed to create file %q: %v", filtFileName, err)
-	}
-
-	scanner := bufio.NewScanner(mainFile)
-	for scanner.Scan() {
-		currentLine := scanner.Text()
-		if strings.Contains(currentLine, sentence) {
-			_, err = filtFile.WriteString(currentLine + "///")
-			if err != nil {
-				log.Warnf("Could not write new line to file: %v", err)
-			}
+		return "", page, fmt.Errorf("Failed to create file %q: %v", filtFileName, err)
+	}
+	defer filtFile.Close()
+
+	results := PaginateResults(ranked.Results, opts, fingerprint, &page)
+
+	var filtered string
+	if opts.Before > 0 || opts.After > 0 {
+		hits := make(map[int]SearchResult, len(results))
+		indexes := make([]int, 0, len(results))
+		for _, result := range results {
+			hits[result.Index] = result
+			indexes = append(indexes, result.Index)
+		}
+		filtered = FormatContextWindows(lines, MergeContextWindows(indexes, opts.Before, opts.After, len(lines)), hits)
+	} else {
+		for _, result := range results {
+			filtered += FormatSearchResult(result) + "///"
 		}
 	}
+	_, err = filtFile.WriteString(filtered)
+	if err != nil {
+		log.Warnf("Could not write filtered lines to file: %v", err)
+	}
+
+	return filtFileName, page, nil
+}
+
+// OPTIONS OF A SEARCH REQUEST, TAKEN FROM THE MESSAGE ATTRIBUTES OR FROM THE CONFIG FILE WHEN MISSING
+type SearchOptions struct {
+	MaxResults  int    // Ranked hits kept over all the pages
+	Before      int    // Context lines shown before every hit
+	After       int    // Context lines shown after every hit
+	Fuzzy       bool   // Match every word of the sentence approximately instead of the exact sentence
+	MaxDistance int    // Edits allowed per word in fuzzy mode
+	Similar     bool   // Rank lines by TF-IDF similarity to the sentence instead of matching it
+	PageSize    int    // Ranked hits per page, 0 returns all of them at once
+	Cursor      string // Opaque cursor of the requested page, empty for the first one
+	JobID       string // Stored search job to take the page from instead of searching again
+	Refine      string // Search job whose results are searched instead of the whole conversation
+}
 
-	mainFile.Close()
-	filtFile.Close()
+// READS THE SEARCH OPTIONS CARRIED BY A MESSAGE. CONTEXT IS CAPPED BY search.maxcontext, THE FUZZY
+// DISTANCE BY search.fuzzymaxdistance AND THE PAGE SIZE BY search.maxpagesize
+func ParseSearchOptions(msg *sqs.Message) SearchOptions {
+	opts := SearchOptions{MaxResults: viper.GetInt("search.maxresults")}
+	if n, err := strconv.Atoi(GetAttribute(msg, "maxResults")); err == nil && n > 0 {
+		opts.MaxResults = n
+	}
+	maxContext := viper.GetInt("search.maxcontext")
+	if n, err := strconv.Atoi(GetAttribute(msg, "before")); err == nil && n > 0 {
+		opts.Before = n
+	}
+	if n, err := strconv.Atoi(GetAttribute(msg, "after")); err == nil && n > 0 {
+		opts.After = n
+	}
+	if maxContext > 0 && opts.Before > maxContext {
+		opts.Before = maxContext
+	}
+	if maxContext > 0 && opts.After > maxContext {
+		opts.After = maxContext
+	}
+	opts.Fuzzy = GetAttribute(msg, "fuzzy") == "true"
+	opts.MaxDistance = viper.GetInt("search.fuzzymaxdistance")
+	if n, err := strconv.Atoi(GetAttribute(msg, "maxDistance")); err == nil && n >= 0 && n < opts.MaxDistance {
+		opts.MaxDistance = n
+	}
+	opts.Similar = GetAttribute(msg, "similar") == "true"
+	opts.PageSize = viper.GetInt("search.pagesize")
+	if n, err := strconv.Atoi(GetAttribute(msg, "pageSize")); err == nil && n > 0 {
+		opts.PageSize = n
+	}
+	if maxPageSize := viper.GetInt("search.maxpagesize"); maxPageSize > 0 && opts.PageSize > maxPageSize {
+		opts.PageSize = maxPageSize
+	}
+	opts.Cursor = GetAttribute(msg, "cursor")
+	opts.JobID = GetAttribute(msg, "jobID")
+	opts.Refine = GetAttribute(msg, "refine")
+	return opts
+}
 
-	os.Remove(mainFileName)
+// RETURNS THE FIRST NON EMPTY STRING
+func FirstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
 
-	return filtFileName, nil
+// RETURNS THE STRING VALUE OF AN OPTIONAL MESSAGE ATTRIBUTE OR "" IF THE MESSAGE DOES NOT CARRY IT
+func GetAttribute(msg *sqs.Message, name string) string {
+	attr, ok := msg.MessageAttributes[name]
+	if !ok || attr.StringValue == nil {
+		return ""
+	}
+	return *attr.StringValue
 }
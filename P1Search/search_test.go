@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	viper "github.com/theherk/viper"
+)
+
+// SEARCH MESSAGE CARRYING THE GIVEN ATTRIBUTES
+func searchMessage(attributes map[string]string) *sqs.Message {
+	msg := &sqs.Message{Body: aws.String("hello"), MessageAttributes: map[string]*sqs.MessageAttributeValue{}}
+	for name, value := range attributes {
+		msg.MessageAttributes[name] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+	}
+	return msg
+}
+
+func TestParseSearchOptions(t *testing.T) {
+	viper.Set("search.maxresults", 100)
+	viper.Set("search.maxcontext", 5)
+	viper.Set("search.fuzzymaxdistance", 2)
+	viper.Set("search.pagesize", 20)
+	viper.Set("search.maxpagesize", 50)
+
+	defaults := ParseSearchOptions(searchMessage(nil))
+	if defaults != (SearchOptions{MaxResults: 100, MaxDistance: 2, PageSize: 20}) {
+		t.Errorf("ParseSearchOptions() without attributes = %+v", defaults)
+	}
+
+	opts := ParseSearchOptions(searchMessage(map[string]string{
+		"maxResults":  "10",
+		"before":      "9",
+		"after":       "3",
+		"fuzzy":       "true",
+		"maxDistance": "7",
+		"pageSize":    "500",
+		"cursor":      "abc",
+	}))
+	want := SearchOptions{MaxResults: 10, Before: 5, After: 3, Fuzzy: true, MaxDistance: 2, PageSize: 50, Cursor: "abc"}
+	if opts != want {
+		t.Errorf("ParseSearchOptions() = %+v, want the limits applied %+v", opts, want)
+	}
+
+	opts = ParseSearchOptions(searchMessage(map[string]string{"before": "-1", "maxDistance": "1", "pageSize": "x"}))
+	if opts.Before != 0 || opts.MaxDistance != 1 || opts.PageSize != 20 {
+		t.Errorf("ParseSearchOptions() = %+v, want no context, distance 1 and the default page size", opts)
+	}
+}
+
+func TestIsSessionOf(t *testing.T) {
+	prefix := "conversations/bob_"
+	keys := map[string]bool{
+		"conversations/bob_Ab12Cd.txt":       true,
+		"conversations/bob_ann_Ab12Cd.txt":   false,
+		"conversations/bobby_Ab12Cd.txt":     false,
+		"conversations/bob_.txt":             false,
+		"conversations/alice_bob_Ab12Cd.txt": false,
+	}
+	for key, want := range keys {
+		if got := IsSessionOf(prefix, key); got != want {
+			t.Errorf("IsSessionOf(%q, %q) = %v, want %v", prefix, key, got, want)
+		}
+	}
+}
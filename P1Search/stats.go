@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/sirupsen/logrus"
+	viper "github.com/theherk/viper"
+)
+
+// WORDS TOO COMMON TO BE INTERESTING IN THE TOP WORDS OF A CONVERSATION
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "but": true, "not": true, "you": true, "all": true,
+	"any": true, "can": true, "had": true, "her": true, "was": true, "one": true, "our": true, "out": true,
+	"has": true, "have": true, "him": true, "his": true, "how": true, "its": true, "let": true, "she": true,
+	"that": true, "this": true, "with": true, "from": true, "they": true, "them": true, "then": true,
+	"there": true, "what": true, "when": true, "will": true, "your": true, "about": true, "would": true,
+}
+
+// STATISTICS OF THE CONVERSATION OF ONE USER, SENT AS JSON TO THE CLIENTS
+type ConversationStats struct {
+	User           string      `json:"user"`
+	Messages       int         `json:"messages"`
+	Sessions       int         `json:"sessions"`
+	FirstActivity  string      `json:"firstActivity"`
+	LastActivity   string      `json:"lastActivity"`
+	MessagesPerDay []DayCount  `json:"messagesPerDay"`
+	TopWords       []WordCount `json:"topWords"`
+}
+
+type DayCount struct {
+	Day      string `json:"day"`
+	Messages int    `json:"messages"`
+}
+
+type WordCount struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+// COMPUTES THE STATISTICS OF A CONVERSATION. DAYS ARE SORTED CHRONOLOGICALLY AND ONLY THE topWords MOST USED WORDS ARE KEPT
+func ComputeConversationStats(client string, lines []ConversationLine, topWords int) ConversationStats {
+	stats := ConversationStats{User: client, Messages: len(lines), MessagesPerDay: []DayCount{}, TopWords: []WordCount{}}
+	sessions := make(map[string]bool)
+	days := make(map[string]int)
+	words := make(map[string]int)
+	var first, last time.Time
+	for _, line := range lines {
+		sessions[line.Session] = true
+		for _, token := range Tokenize(strings.ToLower(line.Text)) {
+			if len([]rune(token.Text)) >= 3 && !stopWords[token.Text] {
+				words[token.Text]++
+			}
+		}
+		t, err := time.ParseInLocation(timestampLayout, line.Timestamp, time.Local)
+		if err != nil {
+			continue
+		}
+		days[t.Format("2006-01-02")]++
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if t.After(last) {
+			last = t
+		}
+	}
+	stats.Sessions = len(sessions)
+	if !first.IsZero() {
+		stats.FirstActivity = first.Format(timestampLayout)
+		stats.LastActivity = last.Format(timestampLayout)
+	}
+
+	for day, n := range days {
+		stats.MessagesPerDay = append(stats.MessagesPerDay, DayCount{Day: day, Messages: n})
+	}
+	sort.Slice(stats.MessagesPerDay, func(i, j int) bool { return stats.MessagesPerDay[i].Day < stats.MessagesPerDay[j].Day })
+
+	for word, n := range words {
+		stats.TopWords = append(stats.TopWords, WordCount{Word: word, Count: n})
+	}
+	sort.Slice(stats.TopWords, func(i, j int) bool {
+		if stats.TopWords[i].Count != stats.TopWords[j].Count {
+			return stats.TopWords[i].Count > stats.TopWords[j].Count
+		}
+		return stats.TopWords[i].Word < stats.TopWords[j].Word
+	})
+	if topWords > 0 && len(stats.TopWords) > topWords {
+		stats.TopWords = stats.TopWords[:topWords]
+	}
+	return stats
+}
+
+// COMPUTES THE STATISTICS OF THE CONVERSATION OF THE USER IN clientName AND SENDS THEM AS JSON THROUGH THE OUTBOX QUEUE
+func ProcessStatsMessage(msg *sqs.Message) error {
+	cmd := *msg.MessageAttributes["cmd"].StringValue
+	sessID := *msg.MessageAttributes["sessionID"].StringValue
+	clientName := *msg.MessageAttributes["clientName"].StringValue
+	timestamp := *msg.MessageAttributes["timestamp"].StringValue
+
+	lines, _, err := LoadConversation(clientName)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(ComputeConversationStats(clientName, lines, viper.GetInt("stats.topwords")))
+	if err != nil {
+		return fmt.Errorf("Could not encode statistics: %v", err)
+	}
+
+	msgTX := &sqs.SendMessageInput{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"clientName": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(clientName),
+			},
+			"sessionID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(sessID),
+			},
+			"timestamp": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(timestamp),
+			},
+			"cmd": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(cmd),
+			},
+		},
+		MessageBody: aws.String(string(body)),
+		QueueUrl:    &outboxURL,
+	}
+	log.Infof("Sending conversation statistics of %s", clientName)
+	result, err := sqssvc.SendMessage(msgTX)
+	if err != nil {
+		log.Errorf("Could not send message to SQS queue: %v", err)
+	} else {
+		log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComputeConversationStats(t *testing.T) {
+	lines := []ConversationLine{
+		{Session: "a", Timestamp: "10-Mar-2024 09:00:00", Text: "Deploy the build"},
+		{Session: "a", Timestamp: "10-Mar-2024 18:30:00", Text: "deploy failed, the build is red"},
+		{Session: "b", Timestamp: "09-Mar-2024 23:59:59", Text: "lunch?"},
+		{Session: "b", Timestamp: "garbage", Text: "deploy again"},
+	}
+	stats := ComputeConversationStats("alice", lines, 2)
+
+	if stats.User != "alice" || stats.Messages != 4 || stats.Sessions != 2 {
+		t.Errorf("counts = %s %d messages %d sessions, want alice 4 messages 2 sessions", stats.User, stats.Messages, stats.Sessions)
+	}
+	if stats.FirstActivity != "09-Mar-2024 23:59:59" || stats.LastActivity != "10-Mar-2024 18:30:00" {
+		t.Errorf("activity from %q to %q", stats.FirstActivity, stats.LastActivity)
+	}
+	wantDays := []DayCount{{Day: "2024-03-09", Messages: 1}, {Day: "2024-03-10", Messages: 2}}
+	if !reflect.DeepEqual(stats.MessagesPerDay, wantDays) {
+		t.Errorf("MessagesPerDay = %v, want %v (lines with a malformed timestamp are not counted)", stats.MessagesPerDay, wantDays)
+	}
+	// Stop words and words shorter than 3 letters are left out, ties are sorted by word
+	wantWords := []WordCount{{Word: "deploy", Count: 3}, {Word: "build", Count: 2}}
+	if !reflect.DeepEqual(stats.TopWords, wantWords) {
+		t.Errorf("TopWords = %v, want %v", stats.TopWords, wantWords)
+	}
+}
+
+func TestComputeConversationStatsEmpty(t *testing.T) {
+	stats := ComputeConversationStats("bob", nil, 10)
+	if stats.Messages != 0 || stats.FirstActivity != "" || stats.MessagesPerDay == nil || stats.TopWords == nil {
+		t.Errorf("stats of an empty conversation = %+v, want zero counts and empty (not null) lists", stats)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// TF-IDF VECTORS OF EVERY LINE OF A CONVERSATION. VECTORS ARE NORMALISED SO THE COSINE SIMILARITY OF TWO LINES IS
+// THE DOT PRODUCT OF THEIR VECTORS
+type TFIDFIndex struct {
+	idf     map[string]float64
+	vectors []map[string]float64
+}
+
+// RETURNS THE TERMS OF text USED BY THE TF-IDF VECTORS: LOWER CASE WORDS OF TWO OR MORE LETTERS THAT ARE NOT STOP WORDS
+func IndexTerms(text string) []Token {
+	var terms []Token
+	for _, token := range Tokenize(strings.ToLower(text)) {
+		if len([]rune(token.Text)) >= 2 && !stopWords[token.Text] {
+			terms = append(terms, token)
+		}
+	}
+	return terms
+}
+
+// BUILDS THE TF-IDF VECTOR OF EVERY LINE. THE INVERSE DOCUMENT FREQUENCY IS SMOOTHED (ln((1+N)/(1+df))+1) SO TERMS
+// USED IN EVERY LINE STILL COUNT A LITTLE
+func BuildTFIDFIndex(lines []ConversationLine) *TFIDFIndex {
+	index := &TFIDFIndex{idf: make(map[string]float64), vectors: make([]map[string]float64, len(lines))}
+	frequencies := make([]map[string]float64, len(lines))
+	documents := make(map[string]int)
+	for i, line := range lines {
+		frequencies[i] = TermFrequencies(line.Text)
+		for term := range frequencies[i] {
+			documents[term]++
+		}
+	}
+	n := float64(len(lines))
+	for term, df := range documents {
+		index.idf[term] = math.Log((1+n)/(1+float64(df))) + 1
+	}
+	for i, tf := range frequencies {
+		index.vectors[i] = index.weigh(tf)
+	}
+	return index
+}
+
+// COUNTS HOW MANY TIMES EVERY TERM APPEARS IN text
+func TermFrequencies(text string) map[string]float64 {
+	tf := make(map[string]float64)
+	for _, term := range IndexTerms(text) {
+		tf[term.Text]++
+	}
+	return tf
+}
+
+// RETURNS THE NORMALISED TF-IDF VECTOR OF text. TERMS THAT DO NOT APPEAR IN THE CONVERSATION ARE DROPPED SINCE
+// THEY CANNOT ADD SIMILARITY TO ANY LINE
+func (index *TFIDFIndex) Vector(text string) map[string]float64 {
+	return index.weigh(TermFrequencies(text))
+}
+
+func (index *TFIDFIndex) weigh(tf map[string]float64) map[string]float64 {
+	vector := make(map[string]float64, len(tf))
+	var norm float64
+	for term, n := range tf {
+		idf, ok := index.idf[term]
+		if !ok {
+			continue
+		}
+		vector[term] = n * idf
+		norm += vector[term] * vector[term]
+	}
+	if norm == 0 {
+		return vector
+	}
+	norm = math.Sqrt(norm)
+	for term := range vector {
+		vector[term] /= norm
+	}
+	return vector
+}
+
+// COSINE SIMILARITY OF TWO NORMALISED VECTORS
+func CosineSimilarity(a map[string]float64, b map[string]float64) float64 {
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+	var dot float64
+	for term, weight := range a {
+		dot += weight * b[term]
+	}
+	return dot
+}
+
+// MATCHES THE LINES WHOSE COSINE SIMILARITY WITH text IS AT LEAST minSimilarity, SCORED BY THAT SIMILARITY.
+// THE OFFSETS POINT TO THE WORDS THE LINE SHARES WITH text SO THE CLIENTS CAN HIGHLIGHT THEM
+func (index *TFIDFIndex) Matcher(text string, minSimilarity float64) LineMatcher {
+	query := index.Vector(text)
+	return func(i int, line ConversationLine) (SearchResult, bool) {
+		similarity := CosineSimilarity(query, index.vectors[i])
+		if similarity <= 0 || similarity < minSimilarity {
+			return SearchResult{}, false
+		}
+		var offsets [][2]int
+		for _, term := range IndexTerms(line.Text) {
+			if _, ok := query[term.Text]; ok {
+				offsets = append(offsets, [2]int{term.Start, term.End})
+			}
+		}
+		return SearchResult{Index: i, Line: line, Score: similarity, Offsets: offsets}, true
+	}
+}
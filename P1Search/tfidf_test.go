@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestIndexTerms(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"The cat and the dog", []string{"cat", "dog"}},
+		{"I saw a UFO", []string{"saw", "ufo"}},
+		{"go, go, GO!", []string{"go", "go", "go"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, term := range IndexTerms(tt.text) {
+			got = append(got, term.Text)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("IndexTerms(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTFIDFSimilarity(t *testing.T) {
+	lines := []ConversationLine{
+		{Text: "the deploy failed again"},
+		{Text: "lunch at noon"},
+		{Text: "deploy finished"},
+		{Text: "who broke the deploy build"},
+	}
+	index := BuildTFIDFIndex(lines)
+	tests := []struct {
+		name  string
+		query string
+		line  int
+		want  float64
+		cmp   string
+	}{
+		{"same text", "lunch at noon", 1, 1, "eq"},
+		{"no shared terms", "lunch at noon", 0, 0, "eq"},
+		{"unknown terms", "holidays", 0, 0, "eq"},
+		{"only stop words", "the and", 0, 0, "eq"},
+		{"shared terms", "deploy failed", 0, 0.5, "gt"},
+		{"common term weighs less", "deploy", 2, 0.9, "lt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CosineSimilarity(index.Vector(tt.query), index.vectors[tt.line])
+			ok := math.Abs(got-tt.want) < 1e-9
+			if tt.cmp == "gt" {
+				ok = got > tt.want
+			} else if tt.cmp == "lt" {
+				ok = got > 0 && got < tt.want
+			}
+			if !ok {
+				t.Errorf("similarity of %q with line %d = %v, want %s %v", tt.query, tt.line, got, tt.cmp, tt.want)
+			}
+		})
+	}
+}
+
+func TestTFIDFMatcher(t *testing.T) {
+	lines := []ConversationLine{
+		{Text: "the deploy failed again"},
+		{Text: "lunch at noon"},
+		{Text: "Deploy finished"},
+	}
+	index := BuildTFIDFIndex(lines)
+	tests := []struct {
+		name          string
+		query         string
+		minSimilarity float64
+		line          int
+		wantMatch     bool
+		wantOffsets   [][2]int
+	}{
+		{"shared word is highlighted", "deploy", 0, 2, true, [][2]int{{0, 6}}},
+		{"every shared word", "deploy failed", 0, 0, true, [][2]int{{4, 10}, {11, 17}}},
+		{"nothing in common", "deploy", 0, 1, false, nil},
+		{"under the minimum", "deploy failed", 0.99, 0, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, ok := index.Matcher(tt.query, tt.minSimilarity)(tt.line, lines[tt.line])
+			if ok != tt.wantMatch {
+				t.Fatalf("Matcher(%q) on line %d matched = %v, want %v", tt.query, tt.line, ok, tt.wantMatch)
+			}
+			if ok && !reflect.DeepEqual(result.Offsets, tt.wantOffsets) {
+				t.Errorf("Matcher(%q) on line %d offsets = %v, want %v", tt.query, tt.line, result.Offsets, tt.wantOffsets)
+			}
+		})
+	}
+}
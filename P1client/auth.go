@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/sirupsen/logrus"
+	term "golang.org/x/term"
+)
+
+// TOKEN SENT BY THE ECHO APP ON LOGIN. EVERY COMMAND CARRIES IT SO THE APPS KNOW WHO SENT IT
+var authToken string
+var tokenClaims TokenClaims
+var loginTimeout = 30 * time.Second
+
+// CONTENT OF THE TOKEN. Shares ARE THE USERS THAT SHARED THEIR CONVERSATION WITH User
+type TokenClaims struct {
+	User    string   `json:"user"`
+	Roles   []string `json:"roles,omitempty"`
+	Shares  []string `json:"shares,omitempty"`
+	Expires int64    `json:"exp"`
+}
+
+// READS THE CLAIMS OF A TOKEN WITHOUT VERIFYING IT (ONLY THE APPS KNOW THE SECRET, THEY REJECT FORGED TOKENS ANYWAY)
+func ParseTokenClaims(token string) (TokenClaims, error) {
+	var claims TokenClaims
+	payload := strings.SplitN(token, ".", 2)[0]
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return claims, fmt.Errorf("Malformed token: %v", err)
+	}
+	err = json.Unmarshal(b, &claims)
+	if err != nil {
+		return claims, fmt.Errorf("Malformed token: %v", err)
+	}
+	return claims, nil
+}
+
+// TRUE IF THE LOGGED USER MAY READ THE CONVERSATION OF target: ITS OWN, ONE SHARED WITH IT OR ANY IF ADMIN OR AUDITOR.
+// DOWNLOADS GO STRAIGHT TO S3 SO THIS IS THE ONLY CHECK THEY GET
+func CanAccess(target string) bool {
+	if target == tokenClaims.User {
+		return true
+	}
+	for _, role := range tokenClaims.Roles {
+		if role == "admin" || role == "auditor" {
+			return true
+		}
+	}
+	for _, owner := range tokenClaims.Shares {
+		if owner == target {
+			return true
+		}
+	}
+	return false
+}
+
+// ASKS FOR USER NAME AND PASSWORD UNTIL THE ECHO APP ACCEPTS THEM. SETS clientName AND authToken
+func Login(reader *bufio.Reader) {
+	for {
+		fmt.Printf("Write your user name: ")
+		name, err := reader.ReadString('\n')
+		if err != nil {
+			log.Errorf("Could not read string: %v", err)
+			os.Exit(1)
+		}
+		name = strings.TrimSuffix(name, "\n")
+		password, err := ReadPassword(reader, "Password: ")
+		if err != nil {
+			log.Errorf("%v", err)
+			os.Exit(1)
+		}
+		token, err := RequestToken(name, password)
+		if err != nil {
+			fmt.Printf("Login failed: %v\n", err)
+			continue
+		}
+		claims, err := ParseTokenClaims(token)
+		if err != nil {
+			fmt.Printf("Login failed: %v\n", err)
+			continue
+		}
+		clientName, authToken, tokenClaims = name, token, claims
+		return
+	}
+}
+
+// READS A PASSWORD FROM THE CONSOLE WITHOUT ECHOING IT (OR A PLAIN LINE IF STDIN IS NOT A TERMINAL)
+func ReadPassword(reader *bufio.Reader, prompt string) (string, error) {
+	fmt.Print(prompt)
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println("")
+		if err != nil {
+			return "", fmt.Errorf("Could not read password: %v", err)
+		}
+		return string(b), nil
+	}
+	text, err := reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("Could not read password: %v", err)
+	}
+	return strings.TrimSuffix(text, "\n"), nil
+}
+
+// SENDS A LOGIN COMMAND (8) TO THE ECHO APP AND WAITS FOR ITS ANSWER. RETURNS THE TOKEN OR WHY THE LOGIN FAILED
+func RequestToken(name string, password string) (string, error) {
+	timestamp := time.Now().Format("02-Jan-2006 15:04:05")
+
+	msg := &sqs.SendMessageInput{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"clientName": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(name),
+			},
+			"sessionID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(sessID),
+			},
+			"timestamp": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(timestamp),
+			},
+			"cmd": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String("8"),
+			},
+		},
+		MessageBody: aws.String(password),
+		QueueUrl:    &inboxURL,
+	}
+	if allowed, wait := Limits.Allow(LoginKey(name, sessID), "login"); !allowed {
+		return "", fmt.Errorf("%s", RateLimitMessage("login", wait))
+	}
+	result, err := sqssvc.SendMessage(msg)
+	if err != nil {
+		return "", fmt.Errorf("Could not send message to SQS queue: %v", err)
+	}
+	log.Infof("Login sent to SQS. MessageID: %v", *result.MessageId)
+
+	// The receiving thread is not running yet, wait here for the answer of this session
+	deadline := time.Now().Add(loginTimeout)
+	for time.Now().Before(deadline) {
+		resultRX, err := sqssvc.ReceiveMessage(&sqs.ReceiveMessageInput{
+			MessageAttributeNames: aws.StringSlice([]string{"sessionID", "cmd", "token", "error"}),
+			QueueUrl:              &outboxURL,
+			MaxNumberOfMessages:   aws.Int64(1),
+			WaitTimeSeconds:       aws.Int64(1),
+		})
+		if err != nil {
+			log.Errorf("Error while receiving message: %v", err)
+			continue
+		}
+		if len(resultRX.Messages) == 0 {
+			continue
+		}
+		msgRX := resultRX.Messages[0]
+		if GetAttribute(msgRX, "sessionID") != sessID || GetAttribute(msgRX, "cmd") != "8" {
+			sqssvc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          &outboxURL,
+				ReceiptHandle:     msgRX.ReceiptHandle,
+				VisibilityTimeout: aws.Int64(0),
+			})
+			continue
+		}
+		err = DeleteMSGSQS(resultRX)
+		if err != nil {
+			log.Errorf("Could not delete msg after processing: %v", err)
+		}
+		if errRX := GetAttribute(msgRX, "error"); errRX != "" {
+			return "", fmt.Errorf("%s", errRX)
+		}
+		return GetAttribute(msgRX, "token"), nil
+	}
+	return "", fmt.Errorf("The echo app did not answer in %v", loginTimeout)
+}
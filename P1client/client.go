@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -26,30 +27,39 @@ var cfgFile string = "config/config.toml"
 var command int
 var seededRand *rand.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
 var sessID string
+var pageSize int
+var searchTimeout = 2 * time.Minute
+
+// CURSORS OF THE PAGES AROUND THE LAST SEARCH PAGE RECEIVED, EMPTY WHEN THERE IS NO PAGE IN THAT DIRECTION
+type SearchPageStruct struct {
+	JobID      string
+	NextCursor string
+	PrevCursor string
+}
+
+var SearchPages = make(chan SearchPageStruct, 1)
 var sess *session.Session = session.Must(session.NewSessionWithOptions(session.Options{
 	SharedConfigState: session.SharedConfigEnable,
 }))
 var sqssvc *sqs.SQS = sqs.New(sess)
 
 func main() {
+	flag.IntVar(&pageSize, "page", 0, "Number of search results shown per page (0 uses the search app default)")
+	flag.Parse()
+
 	initConfig()                  // Set config file, logs and queues URLs
 	sessID = StringWithCharset(6) // Generate random session ID
 
-	// READ USERNAME FROM CONSOLE
-	fmt.Printf("Write your user name: ")
+	// LOG IN WITH USERNAME AND PASSWORD READ FROM CONSOLE
 	reader := bufio.NewReader(os.Stdin)
-	clientName, err := reader.ReadString('\n')
-	if err != nil {
-		log.Errorf("Could not read string: %v", err)
-	}
-	clientName = strings.TrimSuffix(clientName, "\n")
+	Login(reader)
 	log.Infof("USER: %s\tSESSION_ID: %s", clientName, sessID)
 
 	// MAIN LOOP
 	for {
 		// MAIN MENU
 		log.Infof("Client name: %s\n\n", clientName)
-		fmt.Printf("1-ECHO\n2-SEARCH\n3-DOWNLOAD\nSelect the command(number + ENTER): ")
+		fmt.Printf("1-ECHO\n2-SEARCH\n3-DOWNLOAD\n4-STATS\n5-SAVED SEARCHES\n6-SEARCH JOBS\nSelect the command(number + ENTER): ")
 		for {
 			c, err := reader.ReadString('\n')
 			if err != nil {
@@ -59,7 +69,7 @@ func main() {
 
 			c = strings.TrimSuffix(c, "\n")
 			command, err = strconv.Atoi(c)
-			if err != nil || (command != 1 && command != 2 && command != 3) {
+			if err != nil || (command < 1 || command > 6) {
 				fmt.Printf("Invalid command, try again: ")
 				continue
 			} else {
@@ -101,11 +111,18 @@ func main() {
 							DataType:    aws.String("String"),
 							StringValue: aws.String(fmt.Sprintf("%d", command)),
 						},
+						"token": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(authToken),
+						},
 					},
 					MessageBody: aws.String(text),
 					QueueUrl:    &inboxURL,
 				}
 
+				if text != "END" && !CheckRateLimit("echo") {
+					continue
+				}
 				log.Infof("Sending message to AWS echo app. MESSAGE: %s", text)
 				result, err := sqssvc.SendMessage(msg)
 				if err != nil {
@@ -124,6 +141,10 @@ func main() {
 					log.Errorf("Could not read string: %v", err)
 				}
 				clientSearch = strings.TrimSuffix(clientSearch, "\n")
+				if !CanAccess(clientSearch) {
+					fmt.Printf("You are not allowed to search the conversation of %s.\n", clientSearch)
+					break
+				}
 
 				fmt.Printf("Write the sentence to search: ")
 				sentenceSearch, err := reader.ReadString('\n')
@@ -132,6 +153,18 @@ func main() {
 				}
 				sentenceSearch = strings.TrimSuffix(sentenceSearch, "\n")
 
+				similar := ReadYesNo(reader, "Find messages similar to the sentence instead of containing it? (y/N): ")
+				before := ReadNumber(reader, "Context lines before every match (ENTER for none): ")
+				after := ReadNumber(reader, "Context lines after every match (ENTER for none): ")
+				maxDistance := 0
+				if !similar {
+					maxDistance = ReadNumber(reader, "Typos allowed per word for a fuzzy search (ENTER for exact search): ")
+				}
+				cursor := ""
+				jobID := ""
+				refine := ""
+
+			SEARCHPAGE:
 				timestamp := time.Now().Format("02-Jan-2006 15:04:05")
 
 				msg := &sqs.SendMessageInput{
@@ -152,20 +185,108 @@ func main() {
 							DataType:    aws.String("String"),
 							StringValue: aws.String(fmt.Sprintf("%d", command)),
 						},
+						"token": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(authToken),
+						},
+						"before": &sqs.MessageAttributeValue{
+							DataType:    aws.String("Number"),
+							StringValue: aws.String(strconv.Itoa(before)),
+						},
+						"after": &sqs.MessageAttributeValue{
+							DataType:    aws.String("Number"),
+							StringValue: aws.String(strconv.Itoa(after)),
+						},
+						"fuzzy": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(strconv.FormatBool(maxDistance > 0)),
+						},
+						"maxDistance": &sqs.MessageAttributeValue{
+							DataType:    aws.String("Number"),
+							StringValue: aws.String(strconv.Itoa(maxDistance)),
+						},
+						"similar": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(strconv.FormatBool(similar)),
+						},
+						"subscribe": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String("true"),
+						},
+						"pageSize": &sqs.MessageAttributeValue{
+							DataType:    aws.String("Number"),
+							StringValue: aws.String(strconv.Itoa(pageSize)),
+						},
 					},
 					MessageBody: aws.String(sentenceSearch),
 					QueueUrl:    &inboxURL,
 				}
+				if cursor != "" {
+					msg.MessageAttributes["cursor"] = &sqs.MessageAttributeValue{
+						DataType:    aws.String("String"),
+						StringValue: aws.String(cursor),
+					}
+				}
+				if jobID != "" {
+					msg.MessageAttributes["jobID"] = &sqs.MessageAttributeValue{
+						DataType:    aws.String("String"),
+						StringValue: aws.String(jobID),
+					}
+				}
+				if refine != "" {
+					msg.MessageAttributes["refine"] = &sqs.MessageAttributeValue{
+						DataType:    aws.String("String"),
+						StringValue: aws.String(refine),
+					}
+				}
 
+				limit := "search"
+				if jobID != "" {
+					limit = "pages"
+				}
+				if !CheckRateLimit(limit) {
+					break
+				}
+
+				// Discard any page left by a search that timed out
+				select {
+				case <-SearchPages:
+				default:
+				}
 				log.Infof("Sending search command to AWS search app. KEYWORD: %s", sentenceSearch)
 				result, err := sqssvc.SendMessage(msg)
 				if err != nil {
 					log.Errorf("Could not send message to SQS queue: %v", err)
 					continue
-				} else {
-					log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+				}
+				log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+
+				// WAIT FOR THE PAGE AND OFFER THE NEIGHBOUR ONES
+				var page SearchPageStruct
+				select {
+				case page = <-SearchPages:
+				case <-time.After(searchTimeout):
+					log.Warnf("The search app did not answer in %v. A running search keeps going in background, check it with the SEARCH JOBS command", searchTimeout)
+				}
+				if page.JobID == "" {
 					break
 				}
+				choice, next := ReadPageChoice(reader, page)
+				if choice == "page" { // Take the page from the job instead of searching again
+					cursor, jobID, refine = next, page.JobID, ""
+					goto SEARCHPAGE
+				} else if choice == "refine" { // Search only among the results of the job
+					fmt.Printf("Write the sentence to search among these results: ")
+					sentenceSearch, err = reader.ReadString('\n')
+					if err != nil {
+						log.Errorf("Could not read string: %v", err)
+						break
+					}
+					sentenceSearch = strings.TrimSuffix(sentenceSearch, "\n")
+					cursor, jobID, refine = "", "", page.JobID
+					goto SEARCHPAGE
+				}
+				break
 
 			} else if command == 3 { // DOWNLOAD
 				fmt.Printf("Write the name of the user you want to download: ")
@@ -175,11 +296,221 @@ func main() {
 					break
 				}
 				clientDownload = strings.TrimSuffix(clientDownload, "\n")
+				if !CanAccess(clientDownload) {
+					fmt.Printf("You are not allowed to download the conversation of %s.\n", clientDownload)
+					break
+				}
 				err = DownloadConversation(clientDownload)
 				if err != nil {
 					log.Errorf("Could not download conversation %v", err)
 				}
 				break
+			} else if command == 4 { // STATS
+				fmt.Printf("Write the name of the user you want statistics of: ")
+				clientStats, err := reader.ReadString('\n')
+				if err != nil {
+					log.Errorf("Could not read string: %v", err)
+					break
+				}
+				clientStats = strings.TrimSuffix(clientStats, "\n")
+				if !CanAccess(clientStats) {
+					fmt.Printf("You are not allowed to read the conversation of %s.\n", clientStats)
+					break
+				}
+
+				timestamp := time.Now().Format("02-Jan-2006 15:04:05")
+
+				msg := &sqs.SendMessageInput{
+					MessageAttributes: map[string]*sqs.MessageAttributeValue{
+						"clientName": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(clientStats),
+						},
+						"sessionID": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(sessID),
+						},
+						"timestamp": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(timestamp),
+						},
+						"cmd": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(fmt.Sprintf("%d", command)),
+						},
+						"token": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(authToken),
+						},
+					},
+					MessageBody: aws.String("STATS"),
+					QueueUrl:    &inboxURL,
+				}
+
+				if !CheckRateLimit("stats") {
+					break
+				}
+				log.Infof("Sending stats command to AWS search app. USER: %s", clientStats)
+				result, err := sqssvc.SendMessage(msg)
+				if err != nil {
+					log.Errorf("Could not send message to SQS queue: %v", err)
+					continue
+				}
+				log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+				break
+			} else if command == 5 { // SAVED SEARCHES
+				fmt.Printf("a-ADD\nl-LIST\nd-DELETE\nSelect the action: ")
+				action, err := reader.ReadString('\n')
+				if err != nil {
+					log.Errorf("Could not read string: %v", err)
+					break
+				}
+				action = strings.TrimSuffix(action, "\n")
+
+				body, target, searchID := "LIST", "", ""
+				if action == "a" {
+					fmt.Printf("Write the name of the user to watch (ENTER for yourself): ")
+					target, _ = reader.ReadString('\n')
+					target = strings.TrimSuffix(target, "\n")
+					if target != "" && !CanAccess(target) {
+						fmt.Printf("You are not allowed to read the conversation of %s.\n", target)
+						break
+					}
+					fmt.Printf("Write the sentence to watch for: ")
+					body, _ = reader.ReadString('\n')
+					body = strings.TrimSuffix(body, "\n")
+					if body == "" {
+						fmt.Println("The sentence cannot be empty.")
+						continue
+					}
+					action = "add"
+				} else if action == "d" {
+					fmt.Printf("Write the ID of the saved search to delete: ")
+					searchID, _ = reader.ReadString('\n')
+					searchID = strings.TrimSuffix(searchID, "\n")
+					body = "DELETE"
+					action = "delete"
+				} else {
+					action = "list"
+				}
+
+				timestamp := time.Now().Format("02-Jan-2006 15:04:05")
+
+				msg := &sqs.SendMessageInput{
+					MessageAttributes: map[string]*sqs.MessageAttributeValue{
+						"clientName": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(clientName),
+						},
+						"sessionID": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(sessID),
+						},
+						"timestamp": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(timestamp),
+						},
+						"cmd": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(fmt.Sprintf("%d", command)),
+						},
+						"token": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(authToken),
+						},
+						"action": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(action),
+						},
+					},
+					MessageBody: aws.String(body),
+					QueueUrl:    &inboxURL,
+				}
+				if target != "" {
+					msg.MessageAttributes["target"] = &sqs.MessageAttributeValue{
+						DataType:    aws.String("String"),
+						StringValue: aws.String(target),
+					}
+				}
+				if searchID != "" {
+					msg.MessageAttributes["searchID"] = &sqs.MessageAttributeValue{
+						DataType:    aws.String("String"),
+						StringValue: aws.String(searchID),
+					}
+				}
+
+				if !CheckRateLimit("savedsearches") {
+					break
+				}
+				log.Infof("Sending saved search command to AWS echo app. ACTION: %s", action)
+				result, err := sqssvc.SendMessage(msg)
+				if err != nil {
+					log.Errorf("Could not send message to SQS queue: %v", err)
+					continue
+				}
+				log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+				break
+			} else if command == 6 { // SEARCH JOBS
+				fmt.Printf("Write the ID of the search job: ")
+				jobID, err := reader.ReadString('\n')
+				if err != nil {
+					log.Errorf("Could not read string: %v", err)
+					break
+				}
+				jobID = strings.TrimSuffix(jobID, "\n")
+				action := "status"
+				if ReadYesNo(reader, "Cancel the job? (y/N): ") {
+					action = "cancel"
+				}
+
+				timestamp := time.Now().Format("02-Jan-2006 15:04:05")
+
+				msg := &sqs.SendMessageInput{
+					MessageAttributes: map[string]*sqs.MessageAttributeValue{
+						"clientName": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(clientName),
+						},
+						"sessionID": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(sessID),
+						},
+						"timestamp": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(timestamp),
+						},
+						"cmd": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String("7"),
+						},
+						"token": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(authToken),
+						},
+						"jobID": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(jobID),
+						},
+						"action": &sqs.MessageAttributeValue{
+							DataType:    aws.String("String"),
+							StringValue: aws.String(action),
+						},
+					},
+					MessageBody: aws.String(strings.ToUpper(action)),
+					QueueUrl:    &inboxURL,
+				}
+
+				if !CheckRateLimit("jobs") {
+					break
+				}
+				log.Infof("Sending search job command to AWS search app. JOB: %s\tACTION: %s", jobID, action)
+				result, err := sqssvc.SendMessage(msg)
+				if err != nil {
+					log.Errorf("Could not send message to SQS queue: %v", err)
+					continue
+				}
+				log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+				break
 			}
 		}
 
@@ -191,7 +522,7 @@ func main() {
 func ReceiveMSGS() {
 	for {
 		RXmsginput := &sqs.ReceiveMessageInput{
-			MessageAttributeNames: aws.StringSlice([]string{"clientName", "sessionID", "cmd"}),
+			MessageAttributeNames: aws.StringSlice([]string{"clientName", "sessionID", "cmd", "totalMatches", "nextCursor", "prevCursor", "jobID", "error", "target", "query"}),
 			QueueUrl:              &outboxURL,
 			MaxNumberOfMessages:   aws.Int64(1),
 			WaitTimeSeconds:       aws.Int64(1),
@@ -212,6 +543,15 @@ func ReceiveMSGS() {
 		sessIDRX := *msgRX.MessageAttributes["sessionID"].StringValue
 		cmdRX := *msgRX.MessageAttributes["cmd"].StringValue
 		cRX, _ := strconv.Atoi(cmdRX)
+		// Notifications are addressed to the user instead of a session
+		if cRX == 6 && GetAttribute(&msgRX, "clientName") == clientName {
+			PrintNotification(textRX, GetAttribute(&msgRX, "target"), GetAttribute(&msgRX, "query"))
+			err = DeleteMSGSQS(resultRX)
+			if err != nil {
+				log.Errorf("Could not delete msg after processing: %v", err)
+			}
+			continue
+		}
 		if sessID != sessIDRX {
 			sqssvc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
 				QueueUrl:          &outboxURL,
@@ -221,13 +561,38 @@ func ReceiveMSGS() {
 			continue
 		}
 
-		if cRX == 1 { // ECHO
+		if errRX := GetAttribute(&msgRX, "error"); errRX != "" && cRX != 2 {
+			log.Warnf("%s", errRX)
+		} else if cRX == 1 { // ECHO
 			log.Infof("Echoed message: %s", textRX)
 		} else if cRX == 2 { // SEARCH
-			if textRX == "EMPTY CONVERSATION" {
+			if errRX := GetAttribute(&msgRX, "error"); errRX != "" {
+				log.Warnf("%s", errRX)
+			} else if textRX == "EMPTY CONVERSATION" {
 				log.Warnf("Could not find any lines containing that sentence for that client.")
 			} else {
-				PrintFilteredFile(textRX)
+				PrintFilteredFile(textRX, GetAttribute(&msgRX, "totalMatches"))
+			}
+			// Never block the receiving thread if nobody is waiting for the page anymore
+			select {
+			case SearchPages <- SearchPageStruct{
+				JobID:      GetAttribute(&msgRX, "jobID"),
+				NextCursor: GetAttribute(&msgRX, "nextCursor"),
+				PrevCursor: GetAttribute(&msgRX, "prevCursor"),
+			}:
+			default:
+			}
+		} else if cRX == 4 { // STATS
+			err = PrintStats(textRX)
+			if err != nil {
+				log.Errorf("Could not print statistics: %v", err)
+			}
+		} else if cRX == 5 { // SAVED SEARCHES
+			PrintSavedSearches(textRX)
+		} else if cRX == 7 { // SEARCH JOB STATUS
+			err = PrintJobStatus(textRX)
+			if err != nil {
+				log.Errorf("Could not print search job status: %v", err)
 			}
 		}
 		err = DeleteMSGSQS(resultRX)
@@ -252,6 +617,15 @@ func DeleteMSGSQS(resultRX *sqs.ReceiveMessageOutput) error {
 	return nil
 }
 
+// RETURNS THE STRING VALUE OF AN OPTIONAL MESSAGE ATTRIBUTE OR "" IF THE MESSAGE DOES NOT CARRY IT
+func GetAttribute(msg *sqs.Message, name string) string {
+	attr, ok := msg.MessageAttributes[name]
+	if !ok || attr.StringValue == nil {
+		return ""
+	}
+	return *attr.StringValue
+}
+
 // INIT CONFIG FILE, LOGS ETC
 func initConfig() {
 	// CONFIG FILE
@@ -313,9 +687,78 @@ func initConfig() {
 	inboxURL = viper.GetString("sqs.inboxURL")
 	outboxURL = viper.GetString("sqs.outboxURL")
 
+	// RATE LIMITS OF THE COMMANDS SENT BY THIS CLIENT
+	Limits = NewRateLimiter(ReadRateLimits())
+
 	return
 }
 
+// ASKS A YES/NO QUESTION. ANYTHING BUT y OR yes (CASE INSENSITIVE) IS TAKEN AS NO
+func ReadYesNo(reader *bufio.Reader, prompt string) bool {
+	fmt.Print(prompt)
+	text, err := reader.ReadString('\n')
+	if err != nil {
+		log.Errorf("Could not read string: %v", err)
+		return false
+	}
+	text = strings.ToLower(strings.TrimSpace(text))
+	return text == "y" || text == "yes"
+}
+
+// ASKS FOR A NON NEGATIVE NUMBER UNTIL ONE IS WRITTEN. AN EMPTY LINE MEANS 0
+func ReadNumber(reader *bufio.Reader, prompt string) int {
+	fmt.Print(prompt)
+	for {
+		text, err := reader.ReadString('\n')
+		if err != nil {
+			log.Errorf("Could not read string: %v", err)
+			return 0
+		}
+		text = strings.TrimSuffix(text, "\n")
+		if text == "" {
+			return 0
+		}
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 0 {
+			fmt.Printf("Invalid number, try again: ")
+			continue
+		}
+		return n
+	}
+}
+
+// ASKS WHAT TO DO WITH THE SEARCH JOB SHOWN. RETURNS "page" AND THE CURSOR OF THE CHOSEN PAGE, "refine" TO SEARCH AMONG
+// ITS RESULTS OR "" TO GO BACK TO THE MENU
+func ReadPageChoice(reader *bufio.Reader, page SearchPageStruct) (string, string) {
+	options := []string{}
+	if page.NextCursor != "" {
+		options = append(options, "n-NEXT PAGE")
+	}
+	if page.PrevCursor != "" {
+		options = append(options, "p-PREVIOUS PAGE")
+	}
+	options = append(options, fmt.Sprintf("r-REFINE THESE RESULTS (JOB %s)", page.JobID))
+	fmt.Printf("%s\nENTER to go back to the menu: ", strings.Join(options, "\n"))
+	for {
+		text, err := reader.ReadString('\n')
+		if err != nil {
+			log.Errorf("Could not read string: %v", err)
+			return "", ""
+		}
+		text = strings.TrimSuffix(text, "\n")
+		if text == "" {
+			return "", ""
+		} else if text == "n" && page.NextCursor != "" {
+			return "page", page.NextCursor
+		} else if text == "p" && page.PrevCursor != "" {
+			return "page", page.PrevCursor
+		} else if text == "r" {
+			return "refine", ""
+		}
+		fmt.Printf("Invalid option, try again: ")
+	}
+}
+
 // GENERATE RANDOM SESSION ID
 func StringWithCharset(length int) string {
 	charset := "abcdefghijklmnopqrstuvwxyz" + "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -328,13 +771,12 @@ func StringWithCharset(length int) string {
 
 // DOWNLOAD CONVERSATION DIRECTLY FROM S3 GIVEN THE USERNAME
 func DownloadConversation(client string) error {
-	s3svc := s3.New(sess)
 	bucketname := viper.GetString("s3.bucketname")
 
-	// List all conversations ([S3_CONVERSATIONS_PATH]/[USERNAME]_[SESSION_ID].txt) that begin with [S3_CONVERSATIONS_PATH]/[USERNAME]
-	resp, err := s3svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(bucketname), Prefix: aws.String(viper.GetString("s3.conversationspath") + "/" + client)})
+	// List all conversations ([S3_CONVERSATIONS_PATH]/[USERNAME]_[SESSION_ID].txt) of the user
+	resp, err := ListConversationSessions(client)
 	if err != nil {
-		return fmt.Errorf("Unable to list items in bucket %q, %v", bucketname, err)
+		return err
 	}
 
 	// Download every session from our user and when finished, combine all those files in a local one called [S3_CONVERSATIONS_PATH]/[USERNAME].txt
@@ -365,6 +807,33 @@ func DownloadConversation(client string) error {
 	return nil
 }
 
+// LIST ALL SESSIONS FOR THE CLIENT STORED IN S3 ([S3_CONVERSATIONS_PATH]/[USERNAME]_[SESSION_ID].txt)
+func ListConversationSessions(client string) (*s3.ListObjectsV2Output, error) {
+	s3svc := s3.New(sess)
+	bucketname := viper.GetString("s3.bucketname")
+	prefix := viper.GetString("s3.conversationspath") + "/" + client + "_"
+	resp := &s3.ListObjectsV2Output{}
+	err := s3svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String(bucketname), Prefix: aws.String(prefix)}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, item := range page.Contents {
+			if IsSessionOf(prefix, *item.Key) {
+				resp.Contents = append(resp.Contents, item)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list items in bucket %q, %v", bucketname, err)
+	}
+	return resp, nil
+}
+
+// TRUE IF key IS A SESSION FILE OF THE USER OF prefix ([S3_CONVERSATIONS_PATH]/[USERNAME]_). THE PREFIX ALSO MATCHES
+// [S3_CONVERSATIONS_PATH]/[USERNAME]_[OTHER]_[SESSION_ID].txt, WHICH BELONGS TO ANOTHER USER
+func IsSessionOf(prefix string, key string) bool {
+	name := strings.TrimSuffix(strings.TrimPrefix(key, prefix), ".txt")
+	return strings.HasPrefix(key, prefix) && name != "" && !strings.Contains(name, "_")
+}
+
 // COMBINE MULTIPLE SESSION FILES ([S3_CONVERSATIONS_PATH]/[USERNAME]_[SESSION_ID].txt) IN ONE ([S3_CONVERSATIONS_PATH]/[USERNAME].txt)
 func CombineSessionsToFile(client string, items *s3.ListObjectsV2Output) error {
 	newFileName := "conversations/" + client + ".txt"
@@ -400,15 +869,92 @@ func CombineSessionsToFile(client string, items *s3.ListObjectsV2Output) error {
 	return nil
 }
 
-// PRINT FILTERED FILE ON CONSOLE
-func PrintFilteredFile(file string) {
+// PRINT FILTERED FILE ON CONSOLE, HIGHLIGHTING THE MATCHES OF EVERY RANKED LINE. CONTEXT LINES ARE PRINTED
+// WITHOUT SCORE AND CONTEXT GROUPS ARE SEPARATED BY --
+func PrintFilteredFile(file string, totalMatches string) {
 	fmt.Println("\nFiltered conversation:")
 	lines := strings.Split(file, "///")
+	shown := 0
 	for _, line := range lines {
-		if line != "" {
+		if line == "--" {
+			fmt.Println("--")
+		} else if line != "" {
 			columns := strings.Split(line, "|||")
-			fmt.Printf("%s\t%s\n", columns[0], columns[1])
+			if len(columns) >= 4 && columns[2] == "" {
+				fmt.Printf("%s\t\t\033[2m%s\033[0m\n", columns[0], columns[1])
+			} else if len(columns) >= 4 {
+				fmt.Printf("%s\t[%s]\t%s", columns[0], columns[2], HighlightMatches(columns[1], ParseMatchOffsets(columns[3])))
+				if len(columns) >= 5 && columns[4] != "" {
+					fmt.Printf("\t(matched: %s)", columns[4])
+				}
+				fmt.Println("")
+				shown++
+			} else {
+				fmt.Printf("%s\t%s\n", columns[0], columns[1])
+				shown++
+			}
+		}
+	}
+	if totalMatches != "" {
+		fmt.Printf("Showing %d of %s matching lines.\n", shown, totalMatches)
+	}
+	fmt.Println("")
+}
+
+// PRINT THE SAVED SEARCHES OF THE USER ([ID]|||[TARGET]|||[QUERY]|||[CREATED] SEPARATED BY ///) ON CONSOLE
+func PrintSavedSearches(list string) {
+	fmt.Println("\nSaved searches:")
+	if list == "NO SAVED SEARCHES" {
+		fmt.Println("None")
+	}
+	for _, line := range strings.Split(list, "///") {
+		columns := strings.Split(line, "|||")
+		if len(columns) == 4 {
+			fmt.Printf("%s\tUser: %s\tSentence: %s\tSince: %s\n", columns[0], columns[1], columns[2], columns[3])
 		}
 	}
 	fmt.Println("")
 }
+
+// PRINT A SAVED SEARCH MATCH ([TIMESTAMP]|||[TEXT]) ON CONSOLE
+func PrintNotification(body string, target string, query string) {
+	columns := strings.SplitN(body, "|||", 2)
+	if len(columns) != 2 {
+		return
+	}
+	fmt.Printf("\n[NOTIFICATION] New message of %s matching %q at %s: %s\n", target, query, columns[0], columns[1])
+}
+
+// PARSES THE OFFSETS COLUMN OF A SEARCH RESULT ([START]-[END],[START]-[END]...). MALFORMED PAIRS ARE SKIPPED
+func ParseMatchOffsets(column string) [][2]int {
+	var offsets [][2]int
+	for _, pair := range strings.Split(column, ",") {
+		bounds := strings.Split(pair, "-")
+		if len(bounds) != 2 {
+			continue
+		}
+		start, err1 := strconv.Atoi(bounds[0])
+		end, err2 := strconv.Atoi(bounds[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		offsets = append(offsets, [2]int{start, end})
+	}
+	return offsets
+}
+
+// WRAPS EVERY MATCH OF text IN ANSI BOLD RED ESCAPE CODES
+func HighlightMatches(text string, offsets [][2]int) string {
+	var b strings.Builder
+	last := 0
+	for _, o := range offsets {
+		if o[0] < last || o[1] > len(text) || o[0] >= o[1] {
+			continue
+		}
+		b.WriteString(text[last:o[0]])
+		b.WriteString("\033[1;31m" + text[o[0]:o[1]] + "\033[0m")
+		last = o[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
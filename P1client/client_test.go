@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestHighlightMatches(t *testing.T) {
+	const on, off = "\033[1;31m", "\033[0m"
+	tests := []struct {
+		text    string
+		offsets [][2]int
+		want    string
+	}{
+		{"hello", nil, "hello"},
+		{"say hello now", [][2]int{{4, 9}}, "say " + on + "hello" + off + " now"},
+		{"ab ab", [][2]int{{0, 2}, {3, 5}}, on + "ab" + off + " " + on + "ab" + off},
+		{"hello", [][2]int{{0, 3}, {2, 5}}, on + "hel" + off + "lo"},
+		{"hi", [][2]int{{1, 7}}, "hi"},
+	}
+	for _, tt := range tests {
+		if got := HighlightMatches(tt.text, tt.offsets); got != tt.want {
+			t.Errorf("HighlightMatches(%q, %v) = %q, want %q", tt.text, tt.offsets, got, tt.want)
+		}
+	}
+}
+
+func TestIsSessionOf(t *testing.T) {
+	if !IsSessionOf("conversations/bob_", "conversations/bob_Ab12Cd.txt") {
+		t.Errorf("session of bob not listed")
+	}
+	if IsSessionOf("conversations/bob_", "conversations/bob_ann_Ab12Cd.txt") {
+		t.Errorf("session of user bob_ann listed for bob")
+	}
+}
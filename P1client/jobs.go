@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// STATUS OF A SEARCH JOB AS SENT BY THE SEARCH APP
+type JobStatus struct {
+	JobID           string `json:"jobID"`
+	Client          string `json:"client"`
+	Sentence        string `json:"sentence"`
+	Parent          string `json:"parent"`
+	Status          string `json:"status"`
+	SessionsScanned int    `json:"sessionsScanned"`
+	TotalSessions   int    `json:"totalSessions"`
+	Matches         int    `json:"matches"`
+	Error           string `json:"error"`
+	Created         string `json:"created"`
+}
+
+// PRINT THE STATUS OF A SEARCH JOB ON CONSOLE IN ONE LINE
+func PrintJobStatus(body string) error {
+	var status JobStatus
+	err := json.Unmarshal([]byte(body), &status)
+	if err != nil {
+		return fmt.Errorf("Could not decode job status: %v", err)
+	}
+	if status.Error != "" {
+		fmt.Printf("\nSearch job %s: %s: %s\n", status.JobID, status.Status, status.Error)
+		return nil
+	}
+	fmt.Printf("\nSearch job %s (%q in %s): %s, %d/%d sessions scanned, %d matches\n", status.JobID, status.Sentence, status.Client, status.Status, status.SessionsScanned, status.TotalSessions, status.Matches)
+	return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// COMMANDS WITH A RATE LIMIT, READ FROM ratelimit.[NAME].perminute AND ratelimit.[NAME].burst. THE WORKERS ENFORCE
+// THE SAME LIMITS, CHECKING THEM HERE TOO SAVES SENDING MESSAGES THAT WOULD BE REJECTED
+var rateLimitNames = []string{"echo", "search", "pages", "stats", "savedsearches", "jobs", "login"}
+
+// TAKES A TOKEN OF THE LIMIT name BEFORE SENDING A COMMAND. WHEN THERE IS NONE SAYS HOW LONG TO WAIT AND RETURNS FALSE
+func CheckRateLimit(name string) bool {
+	allowed, wait := Limits.Allow(clientName, name)
+	if !allowed {
+		log.Warnf("Rate limit of %s reached", name)
+		fmt.Printf("%s.\n", RateLimitMessage(name, wait))
+	}
+	return allowed
+}
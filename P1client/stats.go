@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// STATISTICS OF THE CONVERSATION OF ONE USER AS SENT BY THE SEARCH APP
+type ConversationStats struct {
+	User           string `json:"user"`
+	Messages       int    `json:"messages"`
+	Sessions       int    `json:"sessions"`
+	FirstActivity  string `json:"firstActivity"`
+	LastActivity   string `json:"lastActivity"`
+	MessagesPerDay []struct {
+		Day      string `json:"day"`
+		Messages int    `json:"messages"`
+	} `json:"messagesPerDay"`
+	TopWords []struct {
+		Word  string `json:"word"`
+		Count int    `json:"count"`
+	} `json:"topWords"`
+}
+
+// PRINT THE STATISTICS OF A CONVERSATION ON CONSOLE AS TABLES
+func PrintStats(body string) error {
+	var stats ConversationStats
+	err := json.Unmarshal([]byte(body), &stats)
+	if err != nil {
+		return fmt.Errorf("Malformed statistics: %v", err)
+	}
+
+	fmt.Printf("\nStatistics of %s:\n", stats.User)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Messages\t%d\n", stats.Messages)
+	fmt.Fprintf(w, "Sessions\t%d\n", stats.Sessions)
+	fmt.Fprintf(w, "First activity\t%s\n", stats.FirstActivity)
+	fmt.Fprintf(w, "Last activity\t%s\n", stats.LastActivity)
+	w.Flush()
+
+	fmt.Println("\nMessages per day:")
+	for _, day := range stats.MessagesPerDay {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", day.Day, day.Messages, strings.Repeat("#", min(day.Messages, 50)))
+	}
+	w.Flush()
+
+	fmt.Println("\nTop words:")
+	for _, word := range stats.TopWords {
+		fmt.Fprintf(w, "%s\t%d\n", word.Word, word.Count)
+	}
+	w.Flush()
+	fmt.Println("")
+	return nil
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	s3 "github.com/aws/aws-sdk-go/service/s3"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/sirupsen/logrus"
+	viper "github.com/theherk/viper"
+)
+
+// QUEUE SHOWN IN THE DASHBOARD. DEAD-LETTER QUEUES (sqs.inboxDLQURL AND sqs.outboxDLQURL) HOLD THE MESSAGES SQS
+// MOVED OUT OF Source AFTER TOO MANY FAILED RECEIVES, THEY CAN BE REQUEUED TO IT
+type AdminQueueStruct struct {
+	Name     string
+	URL      string
+	Source   string
+	Visible  int64
+	InFlight int64
+	Delayed  int64
+	Error    string
+}
+
+// ERROR OF A WORKER: A REPLY WITH AN error ATTRIBUTE READ FROM THE OUTBOX OR A MESSAGE FOUND IN A DEAD-LETTER QUEUE.
+// ID IS THE SQS ID OF THE MESSAGE AND Queue WHERE IT WAS FOUND
+type WorkerErrorStruct struct {
+	ID     string
+	Queue  string
+	Time   time.Time
+	Cmd    int
+	Client string
+	SessID string
+	Body   string
+	Error  string
+}
+
+// BUILDS THE ERROR reason OF THE WORKERS FROM msg, READ FROM queue. THE BODY OF A LOGIN IS A PASSWORD, IT IS LEFT OUT
+func NewWorkerError(msg *sqs.Message, queue string, reason string) WorkerErrorStruct {
+	cmd, _ := strconv.Atoi(GetAttribute(msg, "cmd"))
+	sent := time.Now()
+	if ms, err := strconv.ParseInt(aws.StringValue(msg.Attributes["SentTimestamp"]), 10, 64); err == nil {
+		sent = time.Unix(0, ms*int64(time.Millisecond))
+	}
+	body := aws.StringValue(msg.Body)
+	if cmd == 8 {
+		body = ""
+	}
+	return WorkerErrorStruct{
+		ID:     aws.StringValue(msg.MessageId),
+		Queue:  queue,
+		Time:   sent,
+		Cmd:    cmd,
+		Client: GetAttribute(msg, "clientName"),
+		SessID: GetAttribute(msg, "sessionID"),
+		Body:   body,
+		Error:  reason,
+	}
+}
+
+var commandNames = map[int]string{1: "echo", 2: "search", 4: "stats", 5: "saved searches", 6: "notification", 7: "search job", 8: "login"}
+
+func (e WorkerErrorStruct) Command() string {
+	if name, ok := commandNames[e.Cmd]; ok {
+		return name
+	}
+	return fmt.Sprintf("command %d", e.Cmd)
+}
+
+// WEB SESSION AS SHOWN IN THE DASHBOARD, WITHOUT ITS TOKENS
+type AdminSessionStruct struct {
+	Client     string
+	SessID     string
+	Created    time.Time
+	LastSeen   time.Time
+	Transcript int
+}
+
+// CONVERSATION FILES OF A USER IN THE BUCKET
+type UserStorageStruct struct {
+	User         string
+	Sessions     int
+	Bytes        int64
+	LastModified time.Time
+}
+
+func (u UserStorageStruct) Size() string {
+	return FormatBytes(u.Bytes)
+}
+
+// DATA OF THE ADMIN DASHBOARD. Message SAYS WHAT THE LAST ACTION DID
+type AdminViewStruct struct {
+	Queues        []AdminQueueStruct
+	Errors        []WorkerErrorStruct
+	Sessions      []AdminSessionStruct
+	Storage       []UserStorageStruct
+	StorageError  string
+	StorageTotal  int64
+	StorageCached time.Time
+	Message       string
+}
+
+func (v AdminViewStruct) StorageTotalSize() string {
+	return FormatBytes(v.StorageTotal)
+}
+
+// LAST ERRORS OF THE WORKERS, IN THE ORDER THEY WERE FOUND. ONLY THE LAST max ARE KEPT
+type WorkerErrorLog struct {
+	mu      sync.Mutex
+	max     int
+	entries []WorkerErrorStruct
+}
+
+var WorkerErrors = NewWorkerErrorLog(50)
+
+func NewWorkerErrorLog(max int) *WorkerErrorLog {
+	if max <= 0 {
+		max = 50
+	}
+	return &WorkerErrorLog{max: max}
+}
+
+// KEEPS entry UNLESS A MESSAGE WITH ITS ID IS ALREADY KEPT: REPLIES FOR OTHER CLIENTS ARE RECEIVED AGAIN UNTIL THEIR
+// CLIENT TAKES THEM AND DEAD LETTERS ARE READ ON EVERY LOAD OF THE DASHBOARD
+func (l *WorkerErrorLog) Add(entry WorkerErrorStruct) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, kept := range l.entries {
+		if entry.ID != "" && kept.ID == entry.ID {
+			return
+		}
+	}
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > l.max {
+		l.entries = append([]WorkerErrorStruct(nil), l.entries[len(l.entries)-l.max:]...)
+	}
+}
+
+// THE ERRORS KEPT, NEWEST FIRST
+func (l *WorkerErrorLog) List() []WorkerErrorStruct {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := make([]WorkerErrorStruct, 0, len(l.entries))
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		list = append(list, l.entries[i])
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Time.After(list[j].Time)
+	})
+	return list
+}
+
+// ADDS TO WorkerErrors THE MESSAGES WAITING IN THE DEAD-LETTER QUEUES, UP TO 10 OF EACH. THE WORKERS FAILED THEM TOO
+// MANY TIMES. THEY ARE READ WITHOUT A VISIBILITY TIMEOUT SO THEY STAY AVAILABLE TO BE REQUEUED
+func CollectDeadLetters(ctx context.Context) {
+	for _, queue := range AdminQueues() {
+		if queue.Source == "" {
+			continue
+		}
+		resultRX, err := sqssvc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			MessageAttributeNames: aws.StringSlice([]string{"All"}),
+			AttributeNames:        aws.StringSlice([]string{"SentTimestamp"}),
+			QueueUrl:              aws.String(queue.URL),
+			MaxNumberOfMessages:   aws.Int64(10),
+			VisibilityTimeout:     aws.Int64(0),
+		})
+		if err != nil {
+			log.Errorf("Could not read the dead letters of %s: %v", queue.Name, err)
+			continue
+		}
+		if resultRX == nil {
+			continue
+		}
+		for _, msg := range resultRX.Messages {
+			WorkerErrors.Add(NewWorkerError(msg, queue.Name, fmt.Sprintf("Failed too many times, moved from %s to %s", queue.Source, queue.Name)))
+		}
+	}
+}
+
+// STORAGE PER USER, KEPT admin.storagecacheseconds SINCE IT LISTS THE WHOLE CONVERSATIONS PATH OF THE BUCKET. THE
+// BUCKET IS LISTED WITHOUT HOLDING THE LOCK, SO A SLOW LISTING DOES NOT BLOCK THE DASHBOARDS SERVED FROM THE CACHE
+type StorageUsageCache struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	listed time.Time
+	usage  []UserStorageStruct
+}
+
+var StorageUsage = &StorageUsageCache{ttl: time.Minute}
+
+// RETURNS THE STORAGE OF EVERY USER AND WHEN IT WAS LISTED. refresh LISTS THE BUCKET AGAIN EVEN IF IT IS CACHED
+func (c *StorageUsageCache) Get(ctx context.Context, refresh bool) ([]UserStorageStruct, time.Time, error) {
+	c.mu.Lock()
+	if !refresh && c.usage != nil && time.Since(c.listed) < c.ttl {
+		defer c.mu.Unlock()
+		return c.usage, c.listed, nil
+	}
+	c.mu.Unlock()
+	usage, err := ListStorageUsage(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	listed := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if listed.After(c.listed) {
+		c.usage, c.listed = usage, listed
+	}
+	return usage, listed, nil
+}
+
+// ADDS UP THE SESSION FILES [S3_CONVERSATIONS_PATH]/[USERNAME]_[SESSION_ID].txt OF EVERY USER, LARGEST FIRST
+func ListStorageUsage(ctx context.Context) ([]UserStorageStruct, error) {
+	s3svc := s3.New(sess)
+	bucketname := viper.GetString("s3.bucketname")
+	prefix := viper.GetString("s3.conversationspath") + "/"
+	users := make(map[string]*UserStorageStruct)
+	err := s3svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucketname), Prefix: aws.String(prefix)}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, item := range page.Contents {
+			name := strings.TrimSuffix(strings.TrimPrefix(*item.Key, prefix), ".txt")
+			i := strings.LastIndex(name, "_")
+			if i <= 0 {
+				continue
+			}
+			user := name[:i]
+			if users[user] == nil {
+				users[user] = &UserStorageStruct{User: user}
+			}
+			users[user].Sessions++
+			users[user].Bytes += aws.Int64Value(item.Size)
+			if modified := aws.TimeValue(item.LastModified); modified.After(users[user].LastModified) {
+				users[user].LastModified = modified
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list items in bucket %q, %v", bucketname, err)
+	}
+	usage := make([]UserStorageStruct, 0, len(users))
+	for _, u := range users {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Bytes != usage[j].Bytes {
+			return usage[i].Bytes > usage[j].Bytes
+		}
+		return usage[i].User < usage[j].User
+	})
+	return usage, nil
+}
+
+// 1536 -> "1.5 KiB"
+func FormatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v := float64(n)
+	for _, unit := range []string{"KiB", "MiB", "GiB"} {
+		v /= 1024
+		if v < 1024 || unit == "GiB" {
+			return fmt.Sprintf("%.1f %s", v, unit)
+		}
+	}
+	return ""
+}
+
+// INBOX, OUTBOX AND THEIR DEAD-LETTER QUEUES IF CONFIGURED
+func AdminQueues() []AdminQueueStruct {
+	queues := []AdminQueueStruct{{Name: "inbox", URL: inboxURL}, {Name: "outbox", URL: outboxURL}}
+	if url := viper.GetString("sqs.inboxDLQURL"); url != "" {
+		queues = append(queues, AdminQueueStruct{Name: "inbox-dlq", URL: url, Source: "inbox"})
+	}
+	if url := viper.GetString("sqs.outboxDLQURL"); url != "" {
+		queues = append(queues, AdminQueueStruct{Name: "outbox-dlq", URL: url, Source: "outbox"})
+	}
+	return queues
+}
+
+func FindAdminQueue(name string) (AdminQueueStruct, bool) {
+	for _, queue := range AdminQueues() {
+		if queue.Name == name {
+			return queue, true
+		}
+	}
+	return AdminQueueStruct{}, false
+}
+
+// MOVES UP TO max MESSAGES OF THE DEAD-LETTER QUEUE dlq BACK TO ITS SOURCE QUEUE, WITH THEIR BODY AND ATTRIBUTES.
+// EACH MESSAGE IS DELETED FROM dlq ONLY ONCE IT WAS SENT, SO A FAILURE CAN DUPLICATE IT BUT NEVER LOSE IT
+func RequeueMessages(dlq AdminQueueStruct, max int) (int, error) {
+	source, ok := FindAdminQueue(dlq.Source)
+	if !ok || dlq.Source == "" {
+		return 0, fmt.Errorf("%s is not a dead-letter queue", dlq.Name)
+	}
+	moved := 0
+	for moved < max {
+		batch := int64(max - moved)
+		if batch > 10 {
+			batch = 10
+		}
+		resultRX, err := sqssvc.ReceiveMessage(&sqs.ReceiveMessageInput{
+			MessageAttributeNames: aws.StringSlice([]string{"All"}),
+			QueueUrl:              aws.String(dlq.URL),
+			MaxNumberOfMessages:   aws.Int64(batch),
+			VisibilityTimeout:     aws.Int64(30),
+			WaitTimeSeconds:       aws.Int64(1),
+		})
+		if err != nil {
+			return moved, fmt.Errorf("Could not receive from %s: %v", dlq.Name, err)
+		}
+		if resultRX == nil || len(resultRX.Messages) == 0 {
+			return moved, nil
+		}
+		for _, msg := range resultRX.Messages {
+			_, err := sqssvc.SendMessage(&sqs.SendMessageInput{
+				MessageAttributes: msg.MessageAttributes,
+				MessageBody:       msg.Body,
+				QueueUrl:          aws.String(source.URL),
+			})
+			if err != nil {
+				return moved, fmt.Errorf("Could not send message %s to %s: %v", aws.StringValue(msg.MessageId), source.Name, err)
+			}
+			_, err = sqssvc.DeleteMessage(&sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(dlq.URL),
+				ReceiptHandle: msg.ReceiptHandle,
+			})
+			if err != nil {
+				return moved, fmt.Errorf("Could not delete message %s from %s: %v", aws.StringValue(msg.MessageId), dlq.Name, err)
+			}
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// RUNS action (purge OR requeue) ON THE QUEUE NAMED queueName FOR user AND SAYS WHAT IT DID. A PURGE NEEDS confirm
+// EQUAL TO THE QUEUE NAME, A REQUEUE ONLY WORKS ON A DEAD-LETTER QUEUE
+func AdminQueueAction(user string, action string, queueName string, confirm string) (string, error) {
+	queue, found := FindAdminQueue(queueName)
+	if !found {
+		return "", fmt.Errorf("Unknown queue")
+	}
+	if action == "purge" {
+		if confirm != queue.Name {
+			return "", fmt.Errorf("Write %s to confirm the purge", queue.Name)
+		}
+		if _, err := sqssvc.PurgeQueue(&sqs.PurgeQueueInput{QueueUrl: aws.String(queue.URL)}); err != nil {
+			log.Errorf("Could not purge queue %s: %v", queue.Name, err)
+			return "", fmt.Errorf("Could not purge %s: %v", queue.Name, err)
+		}
+		log.Warnf("Queue %s purged by %s", queue.Name, user)
+		return fmt.Sprintf("%s purged. SQS may take up to a minute to delete its messages.", queue.Name), nil
+	}
+	if action == "requeue" {
+		if queue.Source == "" {
+			return "", fmt.Errorf("%s is not a dead-letter queue", queue.Name)
+		}
+		max := viper.GetInt("admin.requeuemax")
+		if max <= 0 {
+			max = 100
+		}
+		moved, err := RequeueMessages(queue, max)
+		log.Warnf("%d messages of %s requeued by %s", moved, queue.Name, user)
+		if err != nil {
+			log.Errorf("Could not requeue messages of %s: %v", queue.Name, err)
+			return "", fmt.Errorf("Requeued %d messages, then failed: %v", moved, err)
+		}
+		return fmt.Sprintf("%d messages of %s requeued to %s", moved, queue.Name, queue.Source), nil
+	}
+	return "", fmt.Errorf("Unknown action")
+}
+
+// TRUE IF THE TOKEN OF THE LOGGED USER IS VALID AND HAS THE admin ROLE. ENOUGH TO SHOW THE DASHBOARD, ACTIONS
+// CONFIRM IT WITH THE ECHO APP FIRST (SEE ConfirmAdmin)
+func IsAdmin(ClientData ClientStruct) bool {
+	claims, err := VerifyToken(ClientData.AuthToken)
+	return err == nil && claims.HasRole("admin")
+}
+
+// RENEWS THE TOKEN OF session WITH THE ECHO APP, WHICH ONLY SIGNS VALID TOKENS AND READS THE ROLES FROM THE ACCOUNTS
+// AGAIN, AND FAILS UNLESS THE RENEWED ONE STILL HAS THE admin ROLE. THE TOKEN COULD BE FORGED OR ITS ROLE REVOKED
+// SINCE LOGIN AND NO WORKER CHECKS THE QUEUE ACTIONS. A TOKEN THE ECHO APP REJECTS LOGS THE SESSION OUT
+func ConfirmAdmin(ctx context.Context, ClientData *ClientStruct, session WebSession) error {
+	token, err := RenewToken(ctx, *ClientData)
+	if errors.Is(err, ErrTokenRejected) {
+		log.Warnf("Logging out %s: %v", ClientData.Client, err)
+		Sessions.Delete(session.Token)
+		Replies.RemoveSession(session.SessID)
+		return fmt.Errorf("Your session is no longer valid, log in again")
+	} else if err != nil {
+		return fmt.Errorf("Could not confirm your admin role, try again later: %v", err)
+	}
+	Sessions.SetAuthToken(session.Token, token)
+	ClientData.AuthToken = token
+	ClientData.IsAdmin = IsAdmin(*ClientData)
+	if !ClientData.IsAdmin {
+		return fmt.Errorf("User %s is not an administrator", ClientData.Client)
+	}
+	return nil
+}
+
+// ADMIN DASHBOARD: QUEUES, RECENT WORKER ERRORS, WEB SESSIONS AND STORAGE PER USER. POST RUNS AN ACTION ON A QUEUE
+// (action=purge OR action=requeue WITH queue=[NAME]) OR LISTS THE BUCKET AGAIN (action=refresh)
+func admin(w http.ResponseWriter, r *http.Request) {
+	ClientData, session, ok := SessionClientData(w, r, 10)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if !ClientData.IsAdmin {
+		log.Warnf("%s tried to open the admin dashboard", ClientData.Client)
+		ClientData.Error = fmt.Sprintf("User %s is not an administrator", ClientData.Client)
+		w.WriteHeader(http.StatusForbidden)
+		err := tpl.ExecuteTemplate(w, "admin.gohtml", ClientData)
+		if err != nil {
+			log.Errorf("Could not render admin.gohtml: %v", err)
+		}
+		return
+	}
+
+	view := &AdminViewStruct{}
+	ClientData.Admin = view
+	refresh := false
+	if r.Method == http.MethodPost {
+		var err error
+		if r.FormValue("action") == "refresh" {
+			refresh = true
+		} else if err = ConfirmAdmin(r.Context(), &ClientData, session); err != nil {
+			log.Warnf("Admin action %s of %s refused: %v", r.FormValue("action"), ClientData.Client, err)
+			ClientData.Admin = nil
+			ClientData.Error = err.Error()
+			w.WriteHeader(http.StatusForbidden)
+			err = tpl.ExecuteTemplate(w, "admin.gohtml", ClientData)
+			if err != nil {
+				log.Errorf("Could not render admin.gohtml: %v", err)
+			}
+			return
+		} else if view.Message, err = AdminQueueAction(ClientData.Client, r.FormValue("action"), r.FormValue("queue"), r.FormValue("confirm")); err != nil {
+			ClientData.Error = err.Error()
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout())
+	defer cancel()
+	for _, queue := range AdminQueues() {
+		depth, err := QueueDepth(ctx, queue.URL)
+		if err != nil {
+			log.Errorf("%v", err)
+			queue.Error = "Could not read the queue"
+		} else {
+			queue.Visible, queue.InFlight, queue.Delayed = depth["visible"], depth["inflight"], depth["delayed"]
+		}
+		view.Queues = append(view.Queues, queue)
+	}
+
+	CollectDeadLetters(ctx)
+	view.Errors = WorkerErrors.List()
+
+	Sessions.Each(func(session WebSession) {
+		view.Sessions = append(view.Sessions, AdminSessionStruct{
+			Client:     session.Client,
+			SessID:     session.SessID,
+			Created:    session.Created,
+			LastSeen:   session.LastSeen,
+			Transcript: len(session.Transcript),
+		})
+	})
+	sort.Slice(view.Sessions, func(i, j int) bool {
+		return view.Sessions[i].LastSeen.After(view.Sessions[j].LastSeen)
+	})
+
+	usage, listed, err := StorageUsage.Get(r.Context(), refresh)
+	if err != nil {
+		log.Errorf("Could not list storage usage: %v", err)
+		view.StorageError = "Could not list the bucket, try again later"
+	}
+	view.Storage, view.StorageCached = usage, listed
+	for _, u := range usage {
+		view.StorageTotal += u.Bytes
+	}
+
+	err = tpl.ExecuteTemplate(w, "admin.gohtml", ClientData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
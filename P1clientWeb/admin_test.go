@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	viper "github.com/theherk/viper"
+)
+
+func TestWorkerErrorLog(t *testing.T) {
+	l := NewWorkerErrorLog(3)
+	for i := 1; i <= 5; i++ {
+		l.Add(WorkerErrorStruct{Cmd: 2, Error: fmt.Sprintf("error %d", i)})
+	}
+	list := l.List()
+	if len(list) != 3 || list[0].Error != "error 5" || list[2].Error != "error 3" {
+		t.Errorf("List() = %+v, want errors 5, 4 and 3", list)
+	}
+	if name := list[0].Command(); name != "search" {
+		t.Errorf("Command() = %q", name)
+	}
+	if name := (WorkerErrorStruct{Cmd: 42}).Command(); name != "command 42" {
+		t.Errorf("Command() of an unknown command = %q", name)
+	}
+}
+
+func TestWorkerErrorsFromMessages(t *testing.T) {
+	message := func(id string, cmd string, body string, sent string) *sqs.Message {
+		return &sqs.Message{
+			MessageId:  aws.String(id),
+			Body:       aws.String(body),
+			Attributes: map[string]*string{"SentTimestamp": aws.String(sent)},
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				"cmd":        {DataType: aws.String("String"), StringValue: aws.String(cmd)},
+				"clientName": {DataType: aws.String("String"), StringValue: aws.String("alice")},
+				"sessionID":  {DataType: aws.String("String"), StringValue: aws.String("sess01")},
+			},
+		}
+	}
+	l := NewWorkerErrorLog(10)
+	l.Add(NewWorkerError(message("m1", "2", "deploy", "1710061200000"), "outbox", "Could not read the conversation"))
+	l.Add(NewWorkerError(message("m2", "8", "hunter2", "1710064800000"), "inbox-dlq", "Failed too many times"))
+	// The same reply received again before its client took it, and the same dead letter on the next load
+	l.Add(NewWorkerError(message("m1", "2", "deploy", "1710061200000"), "outbox", "Could not read the conversation"))
+	l.Add(NewWorkerError(message("m2", "8", "hunter2", "1710064800000"), "inbox-dlq", "Failed too many times"))
+
+	list := l.List()
+	if len(list) != 2 {
+		t.Fatalf("List() = %+v, want each message once", list)
+	}
+	login, search := list[0], list[1]
+	if login.ID != "m2" || login.Queue != "inbox-dlq" || login.Command() != "login" || login.Body != "" {
+		t.Errorf("dead letter of a login = %+v, want it first and without the password", login)
+	}
+	if search.Client != "alice" || search.SessID != "sess01" || search.Body != "deploy" || !search.Time.Equal(time.Unix(1710061200, 0)) {
+		t.Errorf("error reply = %+v", search)
+	}
+}
+
+func TestAdminQueueAction(t *testing.T) {
+	viper.Set("sqs.inboxDLQURL", "https://sqs.example/inbox-dlq")
+	defer viper.Reset()
+
+	if queues := AdminQueues(); len(queues) != 3 || queues[2].Name != "inbox-dlq" || queues[2].Source != "inbox" {
+		t.Fatalf("AdminQueues() = %+v", queues)
+	}
+	// NONE OF THESE REACH SQS
+	tests := []struct {
+		action, queue, confirm string
+		want                   string
+	}{
+		{"purge", "inbox", "", "Write inbox to confirm the purge"},
+		{"purge", "inbox", "outbox", "Write inbox to confirm the purge"},
+		{"purge", "outbox-dlq", "outbox-dlq", "Unknown queue"},
+		{"requeue", "inbox", "", "inbox is not a dead-letter queue"},
+		{"drop", "inbox", "inbox", "Unknown action"},
+	}
+	for _, tt := range tests {
+		message, err := AdminQueueAction("root", tt.action, tt.queue, tt.confirm)
+		if err == nil || err.Error() != tt.want || message != "" {
+			t.Errorf("AdminQueueAction(%q, %q, %q) = %q, %v, want %q", tt.action, tt.queue, tt.confirm, message, err, tt.want)
+		}
+	}
+	if _, err := RequeueMessages(AdminQueueStruct{Name: "outbox", URL: outboxURL}, 10); err == nil {
+		t.Errorf("requeued from a queue without a source")
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	tokens := map[string]bool{
+		testToken(TokenClaims{User: "root", Roles: []string{"admin"}}): true,
+		testToken(TokenClaims{User: "alice", Roles: []string{"user"}}): false,
+		expiredToken("root"):            false,
+		forgedToken("mallory", "admin"): false,
+		"garbage":                       false,
+	}
+	for token, want := range tokens {
+		if got := IsAdmin(ClientStruct{AuthToken: token}); got != want {
+			t.Errorf("IsAdmin(%q) = %v, want %v", token, got, want)
+		}
+	}
+}
+
+func TestAdminForbidden(t *testing.T) {
+	tpl = template.Must(LoadTemplates())
+	Sessions = NewSessionStore(time.Hour, "", 0, false)
+	session, _ := Sessions.Create("alice", "sess01", testToken(TokenClaims{User: "alice", Roles: []string{"user"}}))
+
+	r := httptest.NewRequest(http.MethodPost, "/admin", strings.NewReader("action=purge&queue=inbox&confirm=inbox"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: session.Token})
+	w := httptest.NewRecorder()
+	admin(w, r)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "not an administrator") {
+		t.Errorf("admin() for a user = %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestStorageUsageCache(t *testing.T) {
+	listed := time.Now().Add(-time.Second)
+	c := &StorageUsageCache{ttl: time.Minute, listed: listed, usage: []UserStorageStruct{{User: "alice", Bytes: 1536}}}
+	usage, when, err := c.Get(context.Background(), false)
+	if err != nil || len(usage) != 1 || !when.Equal(listed) {
+		t.Errorf("Get() of a fresh cache = %+v, %v, %v", usage, when, err)
+	}
+	if size := usage[0].Size(); size != "1.5 KiB" {
+		t.Errorf("Size() = %q", size)
+	}
+	for n, want := range map[int64]string{0: "0 B", 1023: "1023 B", 5 << 20: "5.0 MiB", 3 << 40: "3072.0 GiB"} {
+		if got := FormatBytes(n); got != want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SAME JOB IDS THE SEARCH APP ACCEPTS
+var apiJobID = regexp.MustCompile(`^[A-Za-z0-9]{6,32}$`)
+
+// ERROR OF EVERY FAILED API CALL, ALWAYS SENT AS {"error": {"code": ..., "message": ...}}
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type APIErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+type APILoginRequest struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// SESSION OPENED THROUGH THE API. Token GOES IN THE "Authorization: Bearer" HEADER OF THE NEXT CALLS
+type APISession struct {
+	Token     string    `json:"token,omitempty"`
+	User      string    `json:"user"`
+	SessionID string    `json:"sessionID"`
+	Created   time.Time `json:"created"`
+}
+
+type APIEchoRequest struct {
+	Message string `json:"message"`
+}
+
+type APIEchoResponse struct {
+	Seq     int64  `json:"seq,omitempty"`
+	Message string `json:"message"`
+	Echo    string `json:"echo"`
+}
+
+type APISearchRequest struct {
+	User        string `json:"user"`
+	Sentence    string `json:"sentence"`
+	Before      int    `json:"before"`
+	After       int    `json:"after"`
+	Fuzzy       bool   `json:"fuzzy"`
+	MaxDistance int    `json:"maxDistance"`
+	Similar     bool   `json:"similar"`
+	Refine      string `json:"refine,omitempty"`
+}
+
+// STATUS OF A SEARCH JOB AND, ONCE IT IS DONE, THE REQUESTED PAGE OF ITS RESULTS
+type APISearchResponse struct {
+	JobStatusStruct
+	TotalMatches string            `json:"totalMatches,omitempty"`
+	NextCursor   string            `json:"nextCursor,omitempty"`
+	PrevCursor   string            `json:"prevCursor,omitempty"`
+	Results      []APISearchResult `json:"results,omitempty"`
+}
+
+// ONE RANKED HIT OR CONTEXT LINE. Matches ARE THE [START, END) BYTE OFFSETS OF THE MATCHES IN Text
+type APISearchResult struct {
+	Timestamp string   `json:"timestamp"`
+	Text      string   `json:"text"`
+	Score     string   `json:"score,omitempty"`
+	Matches   [][2]int `json:"matches,omitempty"`
+	Variants  string   `json:"variants,omitempty"`
+	Context   bool     `json:"context,omitempty"`
+	NewGroup  bool     `json:"newGroup,omitempty"`
+}
+
+type APIConversation struct {
+	User  string                `json:"user"`
+	Lines []APIConversationLine `json:"lines"`
+}
+
+type APIConversationLine struct {
+	Timestamp string `json:"timestamp"`
+	Text      string `json:"text"`
+}
+
+// POST LOGS IN AND RETURNS THE SESSION TOKEN, GET RETURNS THE CURRENT SESSION AND DELETE CLOSES IT
+func apiSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var req APILoginRequest
+		if !DecodeJSON(w, r, &req) {
+			return
+		}
+		if req.User == "" || req.Password == "" {
+			WriteAPIError(w, http.StatusBadRequest, "bad_request", "user and password are required")
+			return
+		}
+		session, err := Login(r.Context(), req.User, req.Password, RemoteHost(r))
+		var limited *RateLimitError
+		var unsent *SendError
+		if errors.Is(err, ErrReplyTimeout) || errors.As(err, &unsent) {
+			APIReplyError(w, r, err)
+			return
+		} else if errors.As(err, &limited) {
+			WriteRateLimited(w, "rate_limited", limited.Message, limited.RetryAfter)
+			return
+		} else if err != nil {
+			if r.Context().Err() == nil {
+				WriteAPIError(w, http.StatusUnauthorized, "unauthorized", err.Error())
+			}
+			return
+		}
+		WriteJSON(w, http.StatusCreated, APISession{Token: session.Token, User: session.Client, SessionID: session.SessID, Created: session.Created})
+		return
+	}
+
+	session, ok := Sessions.FromRequest(r)
+	if !ok {
+		WriteAPIError(w, http.StatusUnauthorized, "unauthorized", "Log in first with POST /api/v1/sessions")
+		return
+	}
+	if r.Method == http.MethodGet {
+		WriteJSON(w, http.StatusOK, APISession{User: session.Client, SessionID: session.SessID, Created: session.Created})
+	} else if r.Method == http.MethodDelete {
+		Sessions.Delete(session.Token)
+		Replies.RemoveSession(session.SessID)
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		MethodNotAllowed(w, "GET, POST, DELETE")
+	}
+}
+
+// SENDS A MESSAGE TO THE ECHO APP AND RETURNS ITS ECHO. THE EXCHANGE IS ADDED TO THE TRANSCRIPT OF THE SESSION
+func apiEcho(w http.ResponseWriter, r *http.Request) {
+	ClientData, session, ok := APIClientData(w, r, 1)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, "POST")
+		return
+	}
+	var req APIEchoRequest
+	if !DecodeJSON(w, r, &req) {
+		return
+	}
+	if req.Message == "" {
+		WriteAPIError(w, http.StatusBadRequest, "bad_request", "message is required")
+		return
+	}
+	echomsg, err := SendEcho(r.Context(), ClientData, req.Message)
+	if err != nil {
+		APIReplyError(w, r, err)
+		return
+	}
+	if WorkerError(w, echomsg) {
+		return
+	}
+	var entry EchoEntry
+	if req.Message == "END" {
+		Sessions.ClearTranscript(session.Token)
+	} else {
+		entry, _ = Sessions.AppendTranscript(session.Token, req.Message, echomsg.Body)
+	}
+	WriteJSON(w, http.StatusOK, APIEchoResponse{Seq: entry.Seq, Message: req.Message, Echo: echomsg.Body})
+}
+
+// STARTS A SEARCH JOB. ITS STATUS AND RESULTS ARE AT THE URL OF THE Location HEADER
+func apiSearch(w http.ResponseWriter, r *http.Request) {
+	ClientData, _, ok := APIClientData(w, r, 2)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, "POST")
+		return
+	}
+	var req APISearchRequest
+	if !DecodeJSON(w, r, &req) {
+		return
+	}
+	if req.User == "" || req.Sentence == "" {
+		WriteAPIError(w, http.StatusBadRequest, "bad_request", "user and sentence are required")
+		return
+	}
+	if !CanAccess(ClientData, req.User) {
+		WriteAPIError(w, http.StatusForbidden, "forbidden", AccessDenied(ClientData, req.User))
+		return
+	}
+	ClientData.SearchData = SearchStruct{
+		ClientSearch: req.User,
+		Keysentence:  req.Sentence,
+		Before:       req.Before,
+		After:        req.After,
+		Fuzzy:        req.Fuzzy || req.MaxDistance > 0,
+		MaxDistance:  req.MaxDistance,
+		Similar:      req.Similar,
+		Refine:       req.Refine,
+	}
+	if !SubmitSearch(&ClientData) {
+		if ClientData.SearchData.RetryAfter > 0 {
+			WriteRateLimited(w, "rate_limited", ClientData.SearchData.Error, ClientData.SearchData.RetryAfter)
+		} else {
+			WriteAPIError(w, http.StatusBadGateway, "worker_error", ClientData.SearchData.Error)
+		}
+		return
+	}
+	w.Header().Set("Location", "/api/v1/search/"+ClientData.SearchData.JobID)
+	WriteJSON(w, http.StatusAccepted, APISearchResponse{JobStatusStruct: *ClientData.SearchJob})
+}
+
+// GET RETURNS THE STATUS OF A SEARCH JOB (/api/v1/search/{jobID}) AND, ONCE IT IS DONE, THE PAGE OF RESULTS OF THE
+// cursor QUERY PARAMETER (THE FIRST ONE WITHOUT IT). DELETE CANCELS THE JOB
+func apiSearchJob(w http.ResponseWriter, r *http.Request) {
+	ClientData, _, ok := APIClientData(w, r, 2)
+	if !ok {
+		return
+	}
+	action := "status"
+	if r.Method == http.MethodDelete {
+		action = "cancel"
+	} else if r.Method != http.MethodGet {
+		MethodNotAllowed(w, "GET, DELETE")
+		return
+	}
+	ClientData.SearchData.JobID = strings.TrimPrefix(r.URL.Path, "/api/v1/search/")
+	if !apiJobID.MatchString(ClientData.SearchData.JobID) {
+		WriteAPIError(w, http.StatusNotFound, "not_found", "Unknown search job")
+		return
+	}
+
+	status, err := RequestJobStatus(r.Context(), ClientData, action)
+	if err != nil {
+		APIReplyError(w, r, err)
+		return
+	}
+	if status.RetryAfter > 0 {
+		WriteRateLimited(w, "rate_limited", status.Error, status.RetryAfter)
+		return
+	}
+	if status.Status == "unknown" {
+		if status.Error == "" {
+			status.Error = "Unknown search job"
+		}
+		WriteAPIError(w, http.StatusNotFound, "not_found", status.Error)
+		return
+	}
+	response := APISearchResponse{JobStatusStruct: status}
+	if status.Status == "done" && action == "status" {
+		ClientData.SearchData.ClientSearch = status.Client
+		ClientData.SearchData.Keysentence = status.Sentence
+		ClientData.SearchData.Cursor = r.URL.Query().Get("cursor")
+		if err := RequestSearchPage(r.Context(), &ClientData); err != nil {
+			APIReplyError(w, r, err)
+			return
+		}
+		if ClientData.SearchData.RetryAfter > 0 {
+			WriteRateLimited(w, "rate_limited", ClientData.SearchData.Error, ClientData.SearchData.RetryAfter)
+			return
+		} else if ClientData.SearchData.Error != "" {
+			WriteAPIError(w, http.StatusBadGateway, "worker_error", ClientData.SearchData.Error)
+			return
+		}
+		response.TotalMatches = ClientData.SearchData.TotalMatches
+		response.NextCursor = ClientData.SearchData.NextCursor
+		response.PrevCursor = ClientData.SearchData.PrevCursor
+		response.Results = []APISearchResult{}
+		for _, result := range ClientData.SearchData.SearchResults {
+			response.Results = append(response.Results, NewAPISearchResult(result))
+		}
+	}
+	WriteJSON(w, http.StatusOK, response)
+}
+
+// RETURNS THE WHOLE CONVERSATION OF A USER (/api/v1/conversations/{user})
+func apiConversation(w http.ResponseWriter, r *http.Request) {
+	ClientData, _, ok := APIClientData(w, r, 3)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodGet {
+		MethodNotAllowed(w, "GET")
+		return
+	}
+	user := strings.TrimPrefix(r.URL.Path, "/api/v1/conversations/")
+	if user == "" || strings.Contains(user, "/") {
+		WriteAPIError(w, http.StatusNotFound, "not_found", "Write the user of the conversation in the path")
+		return
+	}
+	if !CanAccess(ClientData, user) {
+		WriteAPIError(w, http.StatusForbidden, "forbidden", AccessDenied(ClientData, user))
+		return
+	}
+	// Every session of the user, oldest first, read straight from S3 without touching the local disk
+	objects, err := ListConversation(r.Context(), user)
+	if err != nil {
+		log.Errorf("Could not list the conversation of %s: %v", user, err)
+		WriteAPIError(w, http.StatusBadGateway, "storage_error", "Could not read the conversation, try again later")
+		return
+	}
+	conversation := APIConversation{User: user, Lines: []APIConversationLine{}}
+	for _, object := range objects {
+		lines, err := ReadConversationSession(r.Context(), object)
+		if err != nil {
+			log.Errorf("Could not read the conversation of %s: %v", user, err)
+			WriteAPIError(w, http.StatusBadGateway, "storage_error", "Could not read the conversation, try again later")
+			return
+		}
+		for _, line := range lines {
+			conversation.Lines = append(conversation.Lines, APIConversationLine{Timestamp: line.Timestamp, Text: line.Text})
+		}
+	}
+	WriteJSON(w, http.StatusOK, conversation)
+}
+
+// SERVES THE OPENAPI DOCUMENT OF THIS API
+func apiOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ServeAsset(w, r, "static/openapi.json")
+}
+
+// BUILDS THE TEMPLATE DATA OF AN API CALL FROM ITS SESSION. WITHOUT A VALID SESSION ANSWERS 401 AND RETURNS FALSE
+func APIClientData(w http.ResponseWriter, r *http.Request, cmd int) (ClientStruct, WebSession, bool) {
+	session, ok := Sessions.FromRequest(r)
+	if !ok {
+		WriteAPIError(w, http.StatusUnauthorized, "unauthorized", "Log in first with POST /api/v1/sessions")
+		return ClientStruct{}, session, false
+	}
+	ClientData := ClientStruct{
+		Client:    session.Client,
+		Cmd:       cmd,
+		SessID:    session.SessID,
+		AuthToken: session.AuthToken,
+	}
+	return ClientData, session, true
+}
+
+// CONVERTS A SEARCH RESULT BACK TO ITS TEXT AND MATCH OFFSETS
+func NewAPISearchResult(result SearchResultStruct) APISearchResult {
+	var text strings.Builder
+	apiResult := APISearchResult{
+		Timestamp: result.Timestamp,
+		Score:     result.Score,
+		Variants:  result.Variants,
+		Context:   result.Context,
+		NewGroup:  result.NewGroup,
+	}
+	for _, segment := range result.Segments {
+		if segment.Match {
+			apiResult.Matches = append(apiResult.Matches, [2]int{text.Len(), text.Len() + len(segment.Text)})
+		}
+		text.WriteString(segment.Text)
+	}
+	apiResult.Text = text.String()
+	return apiResult
+}
+
+// DECODES THE JSON BODY OF A REQUEST INTO v. ANSWERS 400 AND RETURNS FALSE IF IT IS NOT VALID
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err != nil {
+		WriteAPIError(w, http.StatusBadRequest, "bad_request", "Malformed JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// ANSWERS A REQUEST WITHOUT REPLY WITH THE STATUS OF ReplyStatus: 504 IF THE WORKER DID NOT REPLY IN TIME, 502 IF
+// THE REQUEST COULD NOT BE SENT. NOTHING IS WRITTEN IF THE CALLER ALREADY LEFT
+func APIReplyError(w http.ResponseWriter, r *http.Request, err error) {
+	status := ReplyStatus(err)
+	if status == 0 || errors.Is(r.Context().Err(), context.Canceled) {
+		return
+	}
+	if status == http.StatusGatewayTimeout {
+		log.Warnf("API call %s %s without reply: %v", r.Method, r.URL.Path, err)
+		WriteAPIError(w, status, "timeout", "The worker did not respond in time, try again later.")
+	} else {
+		log.Errorf("API call %s %s failed: %v", r.Method, r.URL.Path, err)
+		WriteAPIError(w, status, "worker_error", "The request could not be passed to the worker, try again later.")
+	}
+}
+
+// ANSWERS THE ERROR A WORKER REPLIED WITH, IF ANY: 401 IF IT REJECTED THE TOKEN, 429 IF A RATE LIMIT OR A QUOTA DID,
+// 502 OTHERWISE
+func WorkerError(w http.ResponseWriter, msg RXMsgStruct) bool {
+	if msg.Error == "" {
+		return false
+	}
+	if msg.Body == "UNAUTHORIZED" {
+		WriteAPIError(w, http.StatusUnauthorized, "unauthorized", msg.Error)
+	} else if msg.Body == RateLimitedBody {
+		WriteRateLimited(w, "rate_limited", msg.Error, msg.RetryAfter)
+	} else if msg.Body == QuotaExceededBody {
+		WriteRateLimited(w, "quota_exceeded", msg.Error, msg.RetryAfter)
+	} else {
+		WriteAPIError(w, http.StatusBadGateway, "worker_error", msg.Error)
+	}
+	return true
+}
+
+func MethodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	WriteAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Allowed methods: "+allowed)
+}
+
+// ANSWERS 429 WITH THE SECONDS TO WAIT IN THE Retry-After HEADER
+func WriteRateLimited(w http.ResponseWriter, code string, message string, retryAfter int) {
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	WriteAPIError(w, http.StatusTooManyRequests, code, message)
+}
+
+func WriteAPIError(w http.ResponseWriter, status int, code string, message string) {
+	WriteJSON(w, status, APIErrorResponse{Error: APIError{Code: code, Message: message}})
+}
+
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Errorf("Could not encode API response: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TOKEN WITH claims SIGNED WITH THE SECRET OF THE TESTS, EXPIRING IN AN HOUR
+func testToken(claims TokenClaims) string {
+	claims.Expires = time.Now().Add(time.Hour).Unix()
+	return signTestToken(claims)
+}
+
+func signTestToken(claims TokenClaims) string {
+	tokenSecret = []byte("secret of the tests, 32 bytes long")
+	b, _ := json.Marshal(claims)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CALLS handler AND DECODES THE ERROR CODE OF THE RESPONSE, IF ANY
+func callAPI(handler http.HandlerFunc, method string, path string, token string, body string) (int, string, *httptest.ResponseRecorder) {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	var response APIErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response.Error.Code, w
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	Sessions = NewSessionStore(time.Hour, "", 10, false)
+	Replies = NewReplyRouter(time.Minute, time.Second)
+	session, _ := Sessions.Create("alice", "sess01", testToken(TokenClaims{User: "alice"}))
+
+	if status, code, _ := callAPI(apiEcho, "POST", "/api/v1/echo", "", `{"message":"hi"}`); status != http.StatusUnauthorized || code != "unauthorized" {
+		t.Errorf("echo without a session = %d %q", status, code)
+	}
+	if status, _, _ := callAPI(apiEcho, "POST", "/api/v1/echo", "forged", `{"message":"hi"}`); status != http.StatusUnauthorized {
+		t.Errorf("echo with an unknown token = %d", status)
+	}
+
+	status, _, w := callAPI(apiSessions, "GET", "/api/v1/sessions", session.Token, "")
+	var current APISession
+	json.Unmarshal(w.Body.Bytes(), &current)
+	if status != http.StatusOK || current.User != "alice" || current.SessionID != "sess01" || current.Token != "" {
+		t.Errorf("GET /sessions = %d %+v", status, current)
+	}
+
+	if status, code, _ := callAPI(apiSessions, "POST", "/api/v1/sessions", "", `{"user":"alice"}`); status != http.StatusBadRequest || code != "bad_request" {
+		t.Errorf("login without password = %d %q", status, code)
+	}
+
+	if status, _, _ := callAPI(apiSessions, "DELETE", "/api/v1/sessions", session.Token, ""); status != http.StatusNoContent {
+		t.Errorf("DELETE /sessions = %d", status)
+	}
+	if _, ok := Sessions.Get(session.Token); ok {
+		t.Errorf("session still open after DELETE")
+	}
+}
+
+func TestAPIRequestErrors(t *testing.T) {
+	Sessions = NewSessionStore(time.Hour, "", 10, false)
+	Replies = NewReplyRouter(time.Minute, time.Second)
+	session, _ := Sessions.Create("alice", "sess01", testToken(TokenClaims{User: "alice"}))
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"echo by GET", apiEcho, "GET", "/api/v1/echo", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"unknown JSON field", apiEcho, "POST", "/api/v1/echo", `{"msg":"hi"}`, http.StatusBadRequest, "bad_request"},
+		{"malformed JSON", apiSearch, "POST", "/api/v1/search", `{"sentence":`, http.StatusBadRequest, "bad_request"},
+		{"invalid job ID", apiSearchJob, "GET", "/api/v1/search/../x", "", http.StatusNotFound, "not_found"},
+		{"job by POST", apiSearchJob, "POST", "/api/v1/search/abcdef12", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"conversation without user", apiConversation, "GET", "/api/v1/conversations/", "", http.StatusNotFound, "not_found"},
+		{"conversation of another user", apiConversation, "GET", "/api/v1/conversations/bob", "", http.StatusForbidden, "forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, code, w := callAPI(tt.handler, tt.method, tt.path, session.Token, tt.body)
+			if status != tt.wantStatus || code != tt.wantCode {
+				t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, status, code, tt.wantStatus, tt.wantCode)
+			}
+			if w.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("error sent as %q", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestWorkerError(t *testing.T) {
+	w := httptest.NewRecorder()
+	if WorkerError(w, RXMsgStruct{Body: "echo"}) || w.Body.Len() != 0 {
+		t.Errorf("reply without error answered as an error")
+	}
+	w = httptest.NewRecorder()
+	if !WorkerError(w, RXMsgStruct{Body: "UNAUTHORIZED", Error: "Token expired"}) || w.Code != http.StatusUnauthorized {
+		t.Errorf("rejected token answered with %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	if !WorkerError(w, RXMsgStruct{Body: "EMPTY CONVERSATION", Error: "Search job abc does not exist"}) || w.Code != http.StatusBadGateway {
+		t.Errorf("worker error answered with %d", w.Code)
+	}
+}
+
+func TestNewAPISearchResult(t *testing.T) {
+	result := SearchResultStruct{
+		Timestamp: "10-Mar-2024 09:00:00",
+		Score:     "2.50",
+		Segments:  []TextSegment{{Text: "the "}, {Text: "deploy", Match: true}, {Text: " is "}, {Text: "red", Match: true}},
+	}
+	got := NewAPISearchResult(result)
+	if got.Text != "the deploy is red" || !reflect.DeepEqual(got.Matches, [][2]int{{4, 10}, {14, 17}}) || got.Score != "2.50" {
+		t.Errorf("NewAPISearchResult() = %+v", got)
+	}
+}
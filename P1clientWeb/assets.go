@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TEMPLATES AND STATIC FILES BUILT INTO THE BINARY, SO THE WEB CLIENT RUNS FROM ANY DIRECTORY AND WITHOUT A CDN
+//
+//go:embed templates/*.gohtml static
+var embeddedAssets embed.FS
+
+// FILES OF THE WEB CLIENT. A FILE IN THE OVERRIDE DIRECTORY (assets.overridedir, WITH THE SAME templates/ AND
+// static/ LAYOUT) IS USED INSTEAD OF THE EMBEDDED ONE WITH THE SAME NAME
+type AssetFS struct {
+	override string
+}
+
+var Assets AssetFS
+var assetMaxAge = time.Hour
+
+func (a AssetFS) Open(name string) (fs.File, error) {
+	if a.override != "" {
+		f, err := os.DirFS(a.override).Open(name)
+		if err == nil {
+			return f, nil
+		}
+	}
+	return embeddedAssets.Open(name)
+}
+
+// PARSES THE EMBEDDED TEMPLATES AND THEN THE ONES OF THE OVERRIDE DIRECTORY, WHICH REPLACE THOSE WITH THE SAME NAME
+func LoadTemplates() (*template.Template, error) {
+	t, err := template.New("").Funcs(template.FuncMap{"pagelink": NewPageLink}).ParseFS(embeddedAssets, "templates/*.gohtml")
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse embedded templates: %v", err)
+	}
+	if Assets.override == "" {
+		return t, nil
+	}
+	overrides, err := filepath.Glob(filepath.Join(Assets.override, "templates", "*.gohtml"))
+	if err != nil || len(overrides) == 0 {
+		return t, nil
+	}
+	log.Infof("[INIT] Using %d templates from %s", len(overrides), Assets.override)
+	t, err = t.ParseFiles(overrides...)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse templates of %s: %v", Assets.override, err)
+	}
+	return t, nil
+}
+
+// SERVES THE FILES UNDER /static/. THEY ARE CACHED BY THE BROWSER FOR assets.maxageseconds AND REVALIDATED WITH
+// THEIR ETAG AFTERWARDS
+func staticfile(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean(strings.TrimPrefix(r.URL.Path, "/"))
+	if !strings.HasPrefix(name, "static/") {
+		http.NotFound(w, r)
+		return
+	}
+	ServeAsset(w, r, name)
+}
+
+func ServeAsset(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := Assets.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		log.Errorf("Could not read asset %s: %v", name, err)
+		http.Error(w, "Could not read file", http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(content)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(assetMaxAge.Seconds())))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), bytes.NewReader(content))
+}
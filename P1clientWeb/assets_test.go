@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTemplates(t *testing.T) {
+	Assets = AssetFS{}
+	tpl, err := LoadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, _ := fs.Glob(embeddedAssets, "templates/*.gohtml")
+	if len(names) == 0 {
+		t.Fatalf("no templates embedded")
+	}
+
+	// Every page renders with a logged user and search results, a job and statistics to show
+	data := ClientStruct{Client: "alice", SessID: "Ab12Cd", Cmd: 2}
+	data.SearchData.SearchResults = ParseSearchResults("t0|||hello world|||1.500|||0-5|||hello|||Ab12Cd|||1///--///t1|||context||||||||||||Ab12Cd|||3///")
+	data.SearchData.NextCursor = "next"
+	data.SearchJob = &JobStatusStruct{JobID: "job123", Status: "running", SessionsScanned: 1, TotalSessions: 4}
+	data.Stats, _ = ParseStats(`{"user":"alice","messages":3,"sessions":1,"messagesPerDay":[{"day":"2024-03-10","messages":3}],"topWords":[{"word":"hello","count":2}]}`)
+	data.Conversation = &ConversationViewStruct{User: "alice", Pages: 2, Page: 1}
+	for _, name := range names {
+		var b bytes.Buffer
+		if err := tpl.ExecuteTemplate(&b, filepath.Base(name), data); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if strings.Contains(b.String(), "cdn.") {
+			t.Errorf("%s still loads assets from a CDN", name)
+		}
+	}
+}
+
+func TestAssetOverride(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "templates"), 0700)
+	os.MkdirAll(filepath.Join(dir, "static", "css"), 0700)
+	os.WriteFile(filepath.Join(dir, "templates", "timeout.gohtml"), []byte(`custom {{.Client}}`), 0600)
+	os.WriteFile(filepath.Join(dir, "static", "css", "p1.css"), []byte(`body{}`), 0600)
+	Assets = AssetFS{override: dir}
+	defer func() { Assets = AssetFS{} }()
+
+	tpl, err := LoadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	tpl.ExecuteTemplate(&b, "timeout.gohtml", ClientStruct{Client: "alice"})
+	if b.String() != "custom alice" {
+		t.Errorf("overridden template rendered %q", b.String())
+	}
+	if err := tpl.ExecuteTemplate(&bytes.Buffer{}, "root.gohtml", ClientStruct{}); err != nil {
+		t.Errorf("embedded template lost with an override directory: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	staticfile(w, httptest.NewRequest("GET", "/static/css/p1.css", nil))
+	if w.Body.String() != "body{}" {
+		t.Errorf("overridden static file served %q", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	staticfile(w, httptest.NewRequest("GET", "/static/js/echo.js", nil))
+	if w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Errorf("embedded static file not served next to an override: %d", w.Code)
+	}
+}
+
+func TestStaticFileCaching(t *testing.T) {
+	Assets = AssetFS{}
+	w := httptest.NewRecorder()
+	staticfile(w, httptest.NewRequest("GET", "/static/css/p1.css", nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || !strings.HasPrefix(w.Header().Get("Cache-Control"), "public, max-age=") {
+		t.Fatalf("GET /static/css/p1.css = %d, ETag %q, Cache-Control %q", w.Code, etag, w.Header().Get("Cache-Control"))
+	}
+
+	r := httptest.NewRequest("GET", "/static/css/p1.css", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	staticfile(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("revalidation with the same ETag = %d, want 304", w.Code)
+	}
+
+	for _, path := range []string{"/static/../templates/root.gohtml", "/static/css", "/static/missing.js"} {
+		w = httptest.NewRecorder()
+		staticfile(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CONTENT OF THE TOKEN ISSUED BY THE ECHO APP ON LOGIN. THE TOKEN IS [BASE64(CLAIMS)].[BASE64(HMAC-SHA256(CLAIMS))]
+// SIGNED WITH THE auth.secret SHARED WITH THE WORKERS. Shares ARE THE USERS THAT SHARED THEIR CONVERSATION WITH User
+type TokenClaims struct {
+	User    string   `json:"user"`
+	Roles   []string `json:"roles,omitempty"`
+	Shares  []string `json:"shares,omitempty"`
+	Expires int64    `json:"exp"`
+}
+
+var tokenSecret []byte
+
+// RETURNS THE CLAIMS OF A TOKEN. FAILS IF THE TOKEN IS MALFORMED, FORGED OR EXPIRED. THE WORKERS REJECT SUCH A TOKEN,
+// SO MUST EVERYTHING THE WEB CLIENT SERVES WITHOUT ASKING THEM (DOWNLOADS, THE CONVERSATION VIEWER, THE ADMIN
+// DASHBOARD)
+func VerifyToken(token string) (TokenClaims, error) {
+	var claims TokenClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return claims, fmt.Errorf("Malformed token")
+	}
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal([]byte(parts[1]), []byte(base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))) {
+		return claims, fmt.Errorf("Invalid token signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, fmt.Errorf("Malformed token: %v", err)
+	}
+	err = json.Unmarshal(b, &claims)
+	if err != nil || claims.User == "" {
+		return claims, fmt.Errorf("Malformed token")
+	}
+	if time.Now().Unix() > claims.Expires {
+		return claims, fmt.Errorf("Token expired, log in again")
+	}
+	return claims, nil
+}
+
+func (claims TokenClaims) HasRole(role string) bool {
+	for _, r := range claims.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// TRUE IF THE LOGGED USER MAY READ THE CONVERSATION OF target: ITS OWN, ONE SHARED WITH IT OR ANY IF ADMIN OR AUDITOR.
+// DOWNLOADS GO STRAIGHT TO S3 SO THIS IS THE ONLY CHECK THEY GET
+func CanAccess(ClientData ClientStruct, target string) bool {
+	claims, err := VerifyToken(ClientData.AuthToken)
+	if err != nil {
+		return false
+	}
+	if target == claims.User || claims.HasRole("admin") || claims.HasRole("auditor") {
+		return true
+	}
+	for _, owner := range claims.Shares {
+		if owner == target {
+			return true
+		}
+	}
+	return false
+}
+
+// MESSAGE SHOWN WHEN THE USER ASKS FOR A CONVERSATION IT MAY NOT READ
+func AccessDenied(ClientData ClientStruct, target string) string {
+	return fmt.Sprintf("User %s may not access the conversation of %s", ClientData.Client, target)
+}
+
+// ANSWERS 403 WITH page SHOWING WHY THE CONVERSATION OF target CANNOT BE READ
+func RenderForbidden(w http.ResponseWriter, ClientData ClientStruct, target string, page string) {
+	log.Warnf("%s tried to access the conversation of %s", ClientData.Client, target)
+	ClientData.Error = AccessDenied(ClientData, target)
+	w.WriteHeader(http.StatusForbidden)
+	err := tpl.ExecuteTemplate(w, page, ClientData)
+	if err != nil {
+		log.Errorf("Could not render %s: %v", page, err)
+	}
+}
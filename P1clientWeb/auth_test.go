@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TOKEN OF user THAT EXPIRED A MINUTE AGO
+func expiredToken(user string) string {
+	return signTestToken(TokenClaims{User: user, Expires: time.Now().Add(-time.Minute).Unix()})
+}
+
+// TOKEN OF user WITH roles THAT WAS NEVER SIGNED WITH THE SECRET
+func forgedToken(user string, roles ...string) string {
+	b, _ := json.Marshal(TokenClaims{User: user, Roles: roles, Expires: time.Now().Add(time.Hour).Unix()})
+	return base64.RawURLEncoding.EncodeToString(b) + ".signature"
+}
+
+func TestCanAccess(t *testing.T) {
+	cases := map[string]struct {
+		token string
+		want  bool
+	}{
+		"own conversation": {testToken(TokenClaims{User: "alice"}), false},
+		"shared with bob":  {testToken(TokenClaims{User: "bob", Shares: []string{"carol"}}), true},
+		"auditor":          {testToken(TokenClaims{User: "dave", Roles: []string{"auditor"}}), true},
+		"other user":       {testToken(TokenClaims{User: "bob"}), false},
+		"malformed token":  {"%%%", false},
+		"expired token":    {expiredToken("carol"), false},
+		"forged admin":     {forgedToken("mallory", "admin"), false},
+	}
+	for name, c := range cases {
+		if got := CanAccess(ClientStruct{AuthToken: c.token}, "carol"); got != c.want {
+			t.Errorf("%s: CanAccess(carol) = %v, want %v", name, got, c.want)
+		}
+	}
+	if !CanAccess(ClientStruct{AuthToken: testToken(TokenClaims{User: "carol"})}, "carol") {
+		t.Errorf("owner cannot read its own conversation")
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	if claims, err := VerifyToken(testToken(TokenClaims{User: "alice", Roles: []string{"auditor"}})); err != nil || claims.User != "alice" || !claims.HasRole("auditor") {
+		t.Errorf("VerifyToken() of a signed token = %+v, %v", claims, err)
+	}
+	valid := testToken(TokenClaims{User: "alice"})
+	payload := strings.SplitN(forgedToken("alice", "admin"), ".", 2)[0]
+	signature := strings.SplitN(valid, ".", 2)[1]
+	for _, token := range []string{expiredToken("alice"), forgedToken("alice"), payload + "." + signature, "garbage", valid + ".x"} {
+		if _, err := VerifyToken(token); err == nil {
+			t.Errorf("VerifyToken(%q) accepted", token)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	s3 "github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+	viper "github.com/theherk/viper"
+)
+
+// SESSION OF A CONVERSATION AS LISTED BY THE VIEWER
+type ConversationSessionStruct struct {
+	Session  string
+	Start    string
+	End      string
+	Messages int
+}
+
+// MESSAGE OF A SESSION. Line IS ITS POSITION IN THE SESSION (FROM 1, COUNTING ONLY WELL FORMED LINES), THE SAME
+// NUMBER THE SEARCH APP SENDS WITH EVERY RESULT
+type ConversationLineStruct struct {
+	Line      int
+	Timestamp string
+	Text      string
+	Highlight bool
+}
+
+// DATA OF THE CONVERSATION PAGES: THE SESSIONS OF User AND, WITH A Session, ONE PAGE OF ITS MESSAGES
+type ConversationViewStruct struct {
+	User        string
+	Sessions    []ConversationSessionStruct
+	Session     ConversationSessionStruct
+	Lines       []ConversationLineStruct
+	Page        int
+	Pages       int
+	PrevSession string
+	NextSession string
+}
+
+// 1, 2, ... Pages FOR THE PAGE LINKS OF THE TEMPLATE
+func (v ConversationViewStruct) PageNumbers() []int {
+	numbers := make([]int, v.Pages)
+	for i := range numbers {
+		numbers[i] = i + 1
+	}
+	return numbers
+}
+
+// SUMMARIES OF THE SESSION FILES ALREADY READ, BY KEY AND ETAG, SO LISTING A CONVERSATION ONLY READS THE SESSIONS
+// THAT CHANGED SINCE THE LAST TIME
+type SessionSummaryCache struct {
+	mu        sync.Mutex
+	summaries map[string]ConversationSessionStruct
+}
+
+var SessionSummaries = &SessionSummaryCache{summaries: make(map[string]ConversationSessionStruct)}
+
+func (c *SessionSummaryCache) Get(object ConversationObject) (ConversationSessionStruct, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	summary, ok := c.summaries[object.Key+"|"+object.ETag]
+	return summary, ok && object.ETag != ""
+}
+
+func (c *SessionSummaryCache) Put(object ConversationObject, summary ConversationSessionStruct) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.summaries[object.Key+"|"+object.ETag] = summary
+}
+
+// LISTS THE SESSIONS OF A USER (/conversation/[USER]) OR SHOWS A PAGE OF ONE OF THEM
+// (/conversation/[USER]/[SESSION]?page=[N]). WITH line=[N] THE PAGE OF THAT LINE IS SHOWN AND THE LINE HIGHLIGHTED.
+// /conversation ALONE ASKS FOR THE USER
+func conversation(w http.ResponseWriter, r *http.Request) {
+	ClientData, _, ok := SessionClientData(w, r, 3)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/conversation"), "/")
+	if path == "" {
+		if user := r.FormValue("user"); user != "" {
+			http.Redirect(w, r, "/conversation/"+url.PathEscape(user), http.StatusSeeOther)
+			return
+		}
+		RenderConversation(w, ClientData, "conversation.gohtml")
+		return
+	}
+	parts := strings.Split(path, "/")
+	if len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+	view := &ConversationViewStruct{User: parts[0]}
+	ClientData.Conversation = view
+	if !CanAccess(ClientData, view.User) {
+		RenderForbidden(w, ClientData, view.User, "conversation.gohtml")
+		return
+	}
+
+	objects, err := ListConversation(r.Context(), view.User)
+	if err != nil {
+		log.Errorf("Could not list conversation of %s: %v", view.User, err)
+		ClientData.Error = "Could not read the conversation, try again later"
+		RenderConversation(w, ClientData, "conversation.gohtml")
+		return
+	}
+	if len(objects) == 0 {
+		ClientData.Error = fmt.Sprintf("%s has no conversation", view.User)
+		RenderConversation(w, ClientData, "conversation.gohtml")
+		return
+	}
+
+	if len(parts) == 1 {
+		for _, object := range objects {
+			summary, err := SummarizeSession(r.Context(), object)
+			if err != nil {
+				log.Errorf("Could not summarize session %s: %v", object.Key, err)
+				summary = ConversationSessionStruct{Session: object.Session}
+			}
+			view.Sessions = append(view.Sessions, summary)
+		}
+		RenderConversation(w, ClientData, "conversation.gohtml")
+		return
+	}
+
+	index := -1
+	for i, object := range objects {
+		if object.Session == parts[1] {
+			index = i
+		}
+	}
+	if index < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if index > 0 {
+		view.PrevSession = objects[index-1].Session
+	}
+	if index < len(objects)-1 {
+		view.NextSession = objects[index+1].Session
+	}
+	view.Session = ConversationSessionStruct{Session: objects[index].Session}
+	lines, err := ReadConversationSession(r.Context(), objects[index])
+	if err != nil {
+		log.Errorf("Could not read session %s: %v", objects[index].Key, err)
+		ClientData.Error = "Could not read the session, try again later"
+		RenderConversation(w, ClientData, "conversationsession.gohtml")
+		return
+	}
+	view.Session = SummarizeLines(objects[index].Session, lines)
+	SessionSummaries.Put(objects[index], view.Session)
+
+	pageSize := viper.GetInt("conversation.pagesize")
+	if pageSize <= 0 {
+		pageSize = 50
+	}
+	view.Pages = (len(lines) + pageSize - 1) / pageSize
+	if view.Pages == 0 {
+		view.Pages = 1
+	}
+	view.Page, _ = strconv.Atoi(r.FormValue("page"))
+	highlight, _ := strconv.Atoi(r.FormValue("line"))
+	if highlight > 0 && highlight <= len(lines) {
+		view.Page = (highlight-1)/pageSize + 1
+	}
+	if view.Page < 1 {
+		view.Page = 1
+	} else if view.Page > view.Pages {
+		view.Page = view.Pages
+	}
+	start := (view.Page - 1) * pageSize
+	end := start + pageSize
+	if end > len(lines) {
+		end = len(lines)
+	}
+	for _, line := range lines[start:end] {
+		line.Highlight = line.Line == highlight
+		view.Lines = append(view.Lines, line)
+	}
+	RenderConversation(w, ClientData, "conversationsession.gohtml")
+}
+
+func RenderConversation(w http.ResponseWriter, ClientData ClientStruct, page string) {
+	err := tpl.ExecuteTemplate(w, page, ClientData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// START, END AND MESSAGE COUNT OF A SESSION, READ FROM S3 UNLESS THE SAME VERSION WAS ALREADY READ
+func SummarizeSession(ctx context.Context, object ConversationObject) (ConversationSessionStruct, error) {
+	if summary, ok := SessionSummaries.Get(object); ok {
+		return summary, nil
+	}
+	lines, err := ReadConversationSession(ctx, object)
+	if err != nil {
+		return ConversationSessionStruct{}, err
+	}
+	summary := SummarizeLines(object.Session, lines)
+	SessionSummaries.Put(object, summary)
+	return summary, nil
+}
+
+func SummarizeLines(session string, lines []ConversationLineStruct) ConversationSessionStruct {
+	summary := ConversationSessionStruct{Session: session, Messages: len(lines)}
+	if len(lines) > 0 {
+		summary.Start = lines[0].Timestamp
+		summary.End = lines[len(lines)-1].Timestamp
+	}
+	return summary
+}
+
+// READS THE MESSAGES OF A SESSION FILE ([TIMESTAMP]|||[TEXT] PER LINE) FROM S3
+func ReadConversationSession(ctx context.Context, object ConversationObject) ([]ConversationLineStruct, error) {
+	s3svc := s3.New(sess)
+	obj, err := s3svc.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(viper.GetString("s3.bucketname")), Key: aws.String(object.Key)})
+	if err != nil {
+		return nil, fmt.Errorf("Could not read %s: %v", object.Key, err)
+	}
+	defer obj.Body.Close()
+	var lines []ConversationLineStruct
+	scanner := bufio.NewScanner(obj.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		columns := strings.SplitN(scanner.Text(), "|||", 2)
+		if len(columns) != 2 {
+			continue
+		}
+		lines = append(lines, ConversationLineStruct{Line: len(lines) + 1, Timestamp: columns[0], Text: columns[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read %s: %v", object.Key, err)
+	}
+	return lines, nil
+}
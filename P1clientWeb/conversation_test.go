@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConversationPageNumbers(t *testing.T) {
+	if got := (ConversationViewStruct{Pages: 3}).PageNumbers(); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("PageNumbers() = %v", got)
+	}
+}
+
+func TestSummarizeLines(t *testing.T) {
+	lines := []ConversationLineStruct{{Line: 1, Timestamp: "t1"}, {Line: 2, Timestamp: "t2"}, {Line: 3, Timestamp: "t3"}}
+	want := ConversationSessionStruct{Session: "Ab12Cd", Start: "t1", End: "t3", Messages: 3}
+	if got := SummarizeLines("Ab12Cd", lines); got != want {
+		t.Errorf("SummarizeLines() = %+v, want %+v", got, want)
+	}
+	if got := SummarizeLines("empty", nil); got != (ConversationSessionStruct{Session: "empty"}) {
+		t.Errorf("SummarizeLines() of an empty session = %+v", got)
+	}
+}
+
+func TestSessionSummaryCache(t *testing.T) {
+	cache := &SessionSummaryCache{summaries: make(map[string]ConversationSessionStruct)}
+	object := ConversationObject{Key: "conversations/alice_Ab12Cd.txt", ETag: `"1"`}
+	cache.Put(object, ConversationSessionStruct{Messages: 3})
+	if summary, ok := cache.Get(object); !ok || summary.Messages != 3 {
+		t.Errorf("Get() = %+v %v", summary, ok)
+	}
+	object.ETag = `"2"`
+	if _, ok := cache.Get(object); ok {
+		t.Errorf("summary of an older version of the session returned")
+	}
+	// Without an ETag there is no way to know the session did not change
+	object.ETag = ""
+	cache.Put(object, ConversationSessionStruct{Messages: 5})
+	if _, ok := cache.Get(object); ok {
+		t.Errorf("summary without ETag returned")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/subtle"
+	"mime"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const csrfCookie = "p1csrf"
+const csrfField = "csrf"
+const csrfHeader = "X-CSRF-Token"
+
+// REJECTS STATE-CHANGING REQUESTS (ANY METHOD BUT GET, HEAD AND OPTIONS) WHOSE CSRF TOKEN, SENT IN THE csrf FORM FIELD
+// OR THE X-CSRF-Token HEADER, IS NOT THE ONE OF THEIR SESSION. BEFORE LOGGING IN THE TOKEN OF THE p1csrf COOKIE IS
+// USED INSTEAD. API CALLS WITH A BEARER TOKEN OR A JSON BODY ARE NOT CHECKED: BROWSERS NEVER ADD THE FIRST BY
+// THEMSELVES AND CANNOT SEND THE SECOND TO ANOTHER SITE WITHOUT ITS CONSENT
+func CSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		expected := ""
+		if session, ok := Sessions.FromRequest(r); ok {
+			expected = session.CSRFToken
+		} else if cookie, err := r.Cookie(csrfCookie); err == nil {
+			expected = cookie.Value
+		}
+		sent := r.Header.Get(csrfHeader)
+		if sent == "" {
+			sent = r.PostFormValue(csrfField)
+		}
+		if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(sent)) != 1 {
+			log.Warnf("Rejected %s %s from %s: CSRF token mismatch", r.Method, r.URL.Path, r.RemoteAddr)
+			CSRFError(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ANSWERS A REQUEST THAT FAILED THE CSRF CHECK: JSON FOR THE API AND THE LIVE ECHO PAGE, AN ERROR PAGE OTHERWISE
+func CSRFError(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") || r.Header.Get(csrfHeader) != "" {
+		WriteAPIError(w, http.StatusForbidden, "forbidden", "Missing or invalid CSRF token, reload the page and try again")
+		return
+	}
+	ClientData := ClientStruct{Error: "The form was not sent from this site or it expired. Go back, reload the page and try again."}
+	if session, ok := Sessions.FromRequest(r); ok {
+		ClientData.Client = session.Client
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusForbidden)
+	err := tpl.ExecuteTemplate(w, "csrf.gohtml", ClientData)
+	if err != nil {
+		log.Errorf("Could not render csrf.gohtml: %v", err)
+	}
+}
+
+// RETURNS THE CSRF TOKEN A FORM SHOWN TO r MUST SEND: THE ONE OF ITS SESSION OR, BEFORE LOGGING IN, THE ONE KEPT IN
+// THE p1csrf COOKIE (CREATED HERE IF MISSING)
+func RequestCSRFToken(w http.ResponseWriter, r *http.Request) string {
+	if session, ok := Sessions.FromRequest(r); ok {
+		return session.CSRFToken
+	}
+	if cookie, err := r.Cookie(csrfCookie); err == nil && len(cookie.Value) >= 32 {
+		return cookie.Value
+	}
+	token, err := RandomToken()
+	if err != nil {
+		log.Errorf("Could not generate CSRF token: %v", err)
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   Sessions.secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	tpl = template.Must(LoadTemplates())
+	Sessions = NewSessionStore(time.Hour, "", 0, false)
+	session, err := Sessions.Create("alice", "sess01", testToken(TokenClaims{User: "alice"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withSession := &http.Cookie{Name: sessionCookie, Value: session.Token}
+	beforeLogin := &http.Cookie{Name: csrfCookie, Value: strings.Repeat("x", 43)}
+
+	tests := []struct {
+		name        string
+		method      string
+		cookie      *http.Cookie
+		field       string
+		header      map[string]string
+		wantAllowed bool
+	}{
+		{"safe method", http.MethodGet, nil, "", nil, true},
+		{"head", http.MethodHead, nil, "", nil, true},
+		{"no token", http.MethodPost, withSession, "", nil, false},
+		{"session token in the form", http.MethodPost, withSession, session.CSRFToken, nil, true},
+		{"session token in the header", http.MethodPost, withSession, "", map[string]string{csrfHeader: session.CSRFToken}, true},
+		{"wrong token", http.MethodPost, withSession, "not-the-token", nil, false},
+		{"token of the login cookie", http.MethodPost, beforeLogin, beforeLogin.Value, nil, true},
+		{"login cookie with another token", http.MethodPost, beforeLogin, session.CSRFToken, nil, false},
+		{"no session and no cookie", http.MethodPost, nil, "", nil, false},
+		{"other state-changing method", http.MethodDelete, withSession, "", nil, false},
+		{"bearer token", http.MethodPost, nil, "", map[string]string{"Authorization": "Bearer " + session.Token}, true},
+		{"json body", http.MethodPost, nil, "", map[string]string{"Content-Type": "application/json; charset=utf-8"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.field != "" {
+				form.Set(csrfField, tt.field)
+			}
+			r := httptest.NewRequest(tt.method, "/echo", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for name, value := range tt.header {
+				r.Header.Set(name, value)
+			}
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+			allowed := false
+			w := httptest.NewRecorder()
+			CSRFProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				allowed = true
+			})).ServeHTTP(w, r)
+			if allowed != tt.wantAllowed {
+				t.Errorf("request allowed = %v, want %v", allowed, tt.wantAllowed)
+			}
+			if !tt.wantAllowed && w.Code != http.StatusForbidden {
+				t.Errorf("rejected request status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestRequestCSRFToken(t *testing.T) {
+	Sessions = NewSessionStore(time.Hour, "", 0, false)
+	session, _ := Sessions.Create("alice", "sess01", testToken(TokenClaims{User: "alice"}))
+
+	// Before logging in the token is kept in a cookie so the login form can send it back
+	w := httptest.NewRecorder()
+	token := RequestCSRFToken(w, httptest.NewRequest("GET", "/", nil))
+	cookies := w.Result().Cookies()
+	if len(token) < 32 || len(cookies) != 1 || cookies[0].Name != csrfCookie || cookies[0].Value != token {
+		t.Fatalf("token %q with cookies %v", token, cookies)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	if again := RequestCSRFToken(w, r); again != token || len(w.Result().Cookies()) != 0 {
+		t.Errorf("cookie token not reused: %q", again)
+	}
+
+	r = httptest.NewRequest("GET", "/menu", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: session.Token})
+	if got := RequestCSRFToken(httptest.NewRecorder(), r); got != session.CSRFToken || got == "" {
+		t.Errorf("token of a logged user = %q, want the one of its session", got)
+	}
+}
+
+func TestCSRFErrorForAPI(t *testing.T) {
+	w := httptest.NewRecorder()
+	CSRFError(w, httptest.NewRequest("POST", "/api/v1/echo", nil))
+	if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("CSRF error of an API call = %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
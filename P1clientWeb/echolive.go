@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var echoHeartbeat = 15 * time.Second
+
+// TRANSCRIPT TEXT OF A SESSION AND THE SEQUENCE NUMBER THE LIVE VIEW OF THE ECHO PAGE STARTS FROM
+func EchoTranscript(token string) (string, int64) {
+	entries, lastSeq, _, _ := Sessions.TranscriptSince(token, -1)
+	return TranscriptText(entries), lastSeq
+}
+
+// SENDS A MESSAGE OF THE LIVE ECHO PAGE (FORM FIELD msgsent). THE ECHO REACHES THE PAGE THROUGH /echo/events, SO THE
+// ANSWER ONLY CARRIES ITS SEQUENCE NUMBER, OR THE ERROR AS IN THE API
+func echosend(w http.ResponseWriter, r *http.Request) {
+	ClientData, session, ok := APIClientData(w, r, 1)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, "POST")
+		return
+	}
+	text := r.FormValue("msgsent")
+	if text == "" {
+		WriteAPIError(w, http.StatusBadRequest, "bad_request", "msgsent is required")
+		return
+	}
+	echomsg, err := SendEcho(r.Context(), ClientData, text)
+	if err != nil {
+		APIReplyError(w, r, err)
+		return
+	}
+	if WorkerError(w, echomsg) {
+		return
+	}
+	var entry EchoEntry
+	if text == "END" {
+		Sessions.ClearTranscript(session.Token)
+	} else {
+		entry, _ = Sessions.AppendTranscript(session.Token, text, echomsg.Body)
+	}
+	WriteJSON(w, http.StatusOK, APIEchoResponse{Seq: entry.Seq, Message: text, Echo: echomsg.Body})
+}
+
+// STREAMS THE ECHO TRANSCRIPT OF THE SESSION AS SERVER-SENT EVENTS. FIRST THE ENTRIES AFTER THE Last-Event-ID HEADER
+// (SENT BY THE BROWSER WHEN IT RECONNECTS) OR THE since PARAMETER ARE REPLAYED, THEN NEW ENTRIES ARE PUSHED AS THE
+// ECHOES ARRIVE. A "reset" EVENT TELLS THE PAGE TO DROP WHAT IT SHOWS BECAUSE THE TRANSCRIPT WAS CLEARED OR TRIMMED
+func echoevents(w http.ResponseWriter, r *http.Request) {
+	session, ok := Sessions.FromRequest(r)
+	if !ok {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	since := r.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = r.FormValue("since")
+	}
+	seq, err := strconv.ParseInt(since, 10, 64)
+	if err != nil {
+		seq = -1
+	}
+
+	// SUBSCRIBE BEFORE READING THE REPLAY SO NO ENTRY FALLS BETWEEN BOTH. ENTRIES ALREADY REPLAYED ARE SKIPPED BELOW
+	ch, ok := Sessions.Subscribe(session.Token)
+	if !ok {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+	defer Sessions.Unsubscribe(session.Token, ch)
+	entries, lastSeq, reset, ok := Sessions.TranscriptSince(session.Token, seq)
+	if !ok {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	DisableTimeouts(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 2000\n\n")
+	if reset {
+		resetSeq := lastSeq
+		if len(entries) > 0 {
+			resetSeq = entries[0].Seq - 1
+		}
+		fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", resetSeq)
+	}
+	sent := seq
+	if reset {
+		sent = -1
+	}
+	for _, entry := range entries {
+		if !WriteEchoEvent(w, entry) {
+			return
+		}
+		sent = entry.Seq
+	}
+	flusher.Flush()
+	log.Debugf("Live echo connection of %s from sequence %d", session.Client, seq)
+
+	heartbeat := time.NewTicker(echoHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case entry, open := <-ch:
+			if !open {
+				// THE PAGE RECONNECTS WITH THE LAST ID IT GOT AND CATCHES UP
+				return
+			}
+			if entry.Seq <= sent {
+				continue
+			}
+			if !WriteEchoEvent(w, entry) {
+				return
+			}
+			sent = entry.Seq
+			flusher.Flush()
+		case <-heartbeat.C:
+			// KEEPS THE SESSION ALIVE WHILE THE PAGE IS OPEN AND ENDS THE STREAM ONCE IT IS GONE
+			if _, ok := Sessions.Get(session.Token); !ok {
+				return
+			}
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func WriteEchoEvent(w http.ResponseWriter, entry EchoEntry) bool {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		log.Errorf("Could not encode echo event: %v", err)
+		return false
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: echo\ndata: %s\n\n", entry.Seq, b)
+	return err == nil
+}
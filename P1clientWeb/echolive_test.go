@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEchoEventsReplay(t *testing.T) {
+	Sessions = NewSessionStore(time.Hour, "", 10, false)
+	session, _ := Sessions.Create("alice", "sess01", testToken(TokenClaims{User: "alice"}))
+	Sessions.AppendTranscript(session.Token, "one", "one")
+	Sessions.AppendTranscript(session.Token, "two", "two")
+
+	replay := func(lastEventID string) string {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel() // The stream ends right after the replay
+		r := httptest.NewRequest("GET", "/echo/events", nil).WithContext(ctx)
+		r.Header.Set("Authorization", "Bearer "+session.Token)
+		if lastEventID != "" {
+			r.Header.Set("Last-Event-ID", lastEventID)
+		}
+		w := httptest.NewRecorder()
+		echoevents(w, r)
+		if w.Header().Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("events sent as %q (status %d)", w.Header().Get("Content-Type"), w.Code)
+		}
+		return w.Body.String()
+	}
+
+	if body := replay("1"); strings.Contains(body, "id: 1\n") || !strings.Contains(body, "id: 2\nevent: echo\n") || strings.Contains(body, "reset") {
+		t.Errorf("replay after 1 = %q, want only entry 2", body)
+	}
+	if body := replay(""); !strings.Contains(body, "id: 0\nevent: reset\n") || !strings.Contains(body, "id: 1\n") {
+		t.Errorf("replay of a new page = %q, want a reset and every entry", body)
+	}
+
+	w := httptest.NewRecorder()
+	echoevents(w, httptest.NewRequest("GET", "/echo/events", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("events without a session = %d", w.Code)
+	}
+}
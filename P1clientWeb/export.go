@@ -0,0 +1,389 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	s3 "github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+	viper "github.com/theherk/viper"
+)
+
+// SESSION FILE OF A CONVERSATION IN S3 ([S3_CONVERSATIONS_PATH]/[USERNAME]_[SESSION_ID].txt)
+type ConversationObject struct {
+	Key          string
+	Session      string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// FORMAT A CONVERSATION CAN BE DOWNLOADED IN
+type ExportFormat struct {
+	Extension   string
+	ContentType string
+}
+
+var ExportFormats = map[string]ExportFormat{
+	"txt":   {Extension: "txt", ContentType: "text/plain; charset=utf-8"},
+	"jsonl": {Extension: "jsonl", ContentType: "application/x-ndjson"},
+	"csv":   {Extension: "csv", ContentType: "text/csv; charset=utf-8"},
+	"zip":   {Extension: "zip", ContentType: "application/zip"},
+}
+
+// LINE OF A CONVERSATION AS WRITTEN IN THE JSON LINES DOWNLOAD
+type ExportLine struct {
+	Session   string `json:"session"`
+	Timestamp string `json:"timestamp"`
+	Text      string `json:"text"`
+}
+
+// STREAMS THE CONVERSATION OF A USER FROM S3 AS txt (THE SESSION FILES ONE AFTER THE OTHER), jsonl, csv OR zip (ONE
+// FILE PER SESSION), GIVEN BY THE format PARAMETER. RANGE REQUESTS ARE SERVED SO BROKEN DOWNLOADS CAN BE RESUMED:
+// txt RANGES ARE READ STRAIGHT FROM THE SESSION FILES AND THE OTHER FORMATS ARE BUILT IN A TEMPORAL FILE FIRST
+func downloadfile(w http.ResponseWriter, r *http.Request) {
+	ClientData, _, ok := SessionClientData(w, r, 3)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/download/"))
+	if err != nil || user == "" || strings.Contains(user, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if !CanAccess(ClientData, user) {
+		http.Error(w, AccessDenied(ClientData, user), http.StatusForbidden)
+		return
+	}
+	formatName := r.FormValue("format")
+	if formatName == "" {
+		formatName = "txt"
+	}
+	format, ok := ExportFormats[formatName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown format %q", formatName), http.StatusBadRequest)
+		return
+	}
+
+	objects, err := ListConversation(r.Context(), user)
+	if err != nil {
+		log.Errorf("Could not list conversation of %s: %v", user, err)
+		http.Error(w, "Could not read the conversation", http.StatusBadGateway)
+		return
+	}
+	if len(objects) == 0 {
+		http.Error(w, fmt.Sprintf("%s has no conversation", user), http.StatusNotFound)
+		return
+	}
+
+	DisableTimeouts(w)
+	etag := ConversationETag(objects, formatName)
+	modified := objects[0].LastModified
+	for _, object := range objects {
+		if object.LastModified.After(modified) {
+			modified = object.LastModified
+		}
+	}
+	filename := user + "." + format.Extension
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rangeHeader := r.Header.Get("Range")
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		// THE CONVERSATION CHANGED SINCE THE FIRST PART WAS DOWNLOADED, SO IT IS SENT WHOLE AGAIN
+		rangeHeader = ""
+	}
+
+	if formatName == "txt" {
+		ServeConversationText(w, r, objects, rangeHeader)
+		return
+	}
+	if rangeHeader == "" {
+		if r.Method == http.MethodHead {
+			return
+		}
+		log.Infof("Streaming conversation of %s as %s", user, formatName)
+		if err := WriteExport(r.Context(), w, formatName, user, objects); err != nil {
+			log.Errorf("Could not stream conversation of %s: %v", user, err)
+		}
+		return
+	}
+
+	// RANGES OF A BUILT FORMAT NEED ITS SIZE, SO THE FILE IS BUILT WHOLE AND SERVED FROM DISK
+	f, err := ioutil.TempFile("", "p1export-*."+format.Extension)
+	if err != nil {
+		log.Errorf("Could not create temporal export file: %v", err)
+		http.Error(w, "Could not build the download", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := WriteExport(r.Context(), f, formatName, user, objects); err != nil {
+		log.Errorf("Could not build %s export of %s: %v", formatName, user, err)
+		http.Error(w, "Could not build the download", http.StatusBadGateway)
+		return
+	}
+	http.ServeContent(w, r, filename, modified, f)
+}
+
+// LISTS THE SESSION FILES OF A USER, OLDEST FIRST
+func ListConversation(ctx context.Context, user string) ([]ConversationObject, error) {
+	s3svc := s3.New(sess)
+	bucketname := viper.GetString("s3.bucketname")
+	prefix := viper.GetString("s3.conversationspath") + "/" + user + "_"
+	var objects []ConversationObject
+	err := s3svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucketname), Prefix: aws.String(prefix)}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, item := range page.Contents {
+			name := strings.TrimSuffix(strings.TrimPrefix(*item.Key, prefix), ".txt")
+			if strings.Contains(name, "_") {
+				// [S3_CONVERSATIONS_PATH]/[USERNAME]_[OTHER]_[SESSION_ID].txt BELONGS TO ANOTHER USER
+				continue
+			}
+			objects = append(objects, ConversationObject{
+				Key:          *item.Key,
+				Session:      name,
+				Size:         aws.Int64Value(item.Size),
+				ETag:         aws.StringValue(item.ETag),
+				LastModified: aws.TimeValue(item.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list items in bucket %q, %v", bucketname, err)
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return objects[i].LastModified.Before(objects[j].LastModified)
+	})
+	return objects, nil
+}
+
+// ETAG OF A DOWNLOAD. IT CHANGES WHENEVER A SESSION FILE CHANGES SO A RESUMED DOWNLOAD NEVER MIXES TWO VERSIONS
+func ConversationETag(objects []ConversationObject, format string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", format)
+	for _, object := range objects {
+		fmt.Fprintf(h, "%s|%s|%d\n", object.Key, object.ETag, object.Size)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil))[:32] + `"`
+}
+
+// SENDS THE SESSION FILES ONE AFTER THE OTHER, OR THE BYTES OF A SINGLE "bytes=" RANGE OF THEM. REQUESTS WITH
+// SEVERAL RANGES GET THE WHOLE FILE
+func ServeConversationText(w http.ResponseWriter, r *http.Request, objects []ConversationObject, rangeHeader string) {
+	var total int64
+	for _, object := range objects {
+		total += object.Size
+	}
+	start, end := int64(0), total-1
+	status := http.StatusOK
+	if rangeHeader != "" {
+		var ok bool
+		start, end, ok = ParseByteRange(rangeHeader, total)
+		if !ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+			http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if start != 0 || end != total-1 {
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, total))
+		}
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead || total == 0 {
+		return
+	}
+
+	s3svc := s3.New(sess)
+	bucketname := viper.GetString("s3.bucketname")
+	var offset int64
+	for _, object := range objects {
+		from, to := offset, offset+object.Size-1
+		offset += object.Size
+		if object.Size == 0 || to < start || from > end {
+			continue
+		}
+		first, last := int64(0), object.Size-1
+		if start > from {
+			first = start - from
+		}
+		if end < to {
+			last = end - from
+		}
+		input := &s3.GetObjectInput{
+			Bucket: aws.String(bucketname),
+			Key:    aws.String(object.Key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-%d", first, last)),
+		}
+		if object.ETag != "" {
+			// THE LENGTH WAS ALREADY SENT, SO A SESSION FILE THAT CHANGED MEANWHILE CUTS THE DOWNLOAD INSTEAD
+			input.IfMatch = aws.String(object.ETag)
+		}
+		obj, err := s3svc.GetObjectWithContext(r.Context(), input)
+		if err != nil {
+			log.Errorf("Could not read %s: %v", object.Key, err)
+			return
+		}
+		_, err = io.Copy(w, obj.Body)
+		obj.Body.Close()
+		if err != nil {
+			log.Warnf("Download of %s stopped: %v", object.Key, err)
+			return
+		}
+	}
+}
+
+// PARSES A Range HEADER WITH A SINGLE "bytes=" RANGE INTO ITS FIRST AND LAST BYTE. SEVERAL RANGES OR ANOTHER UNIT
+// SELECT THE WHOLE FILE AND A RANGE OUTSIDE OF IT RETURNS FALSE
+func ParseByteRange(header string, size int64) (int64, int64, bool) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, size - 1, true
+	}
+	parts := strings.SplitN(strings.TrimSpace(spec), "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	if parts[0] == "" {
+		// LAST n BYTES
+		n, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if parts[1] != "" {
+		end, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+// WRITES THE CONVERSATION IN THE GIVEN FORMAT, READING THE SESSION FILES ONE BY ONE
+func WriteExport(ctx context.Context, w io.Writer, format string, user string, objects []ConversationObject) error {
+	s3svc := s3.New(sess)
+	bucketname := viper.GetString("s3.bucketname")
+	open := func(object ConversationObject) (io.ReadCloser, error) {
+		obj, err := s3svc.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(bucketname), Key: aws.String(object.Key)})
+		if err != nil {
+			return nil, fmt.Errorf("Could not read %s: %v", object.Key, err)
+		}
+		return obj.Body, nil
+	}
+
+	switch format {
+	case "zip":
+		zw := zip.NewWriter(w)
+		for _, object := range objects {
+			body, err := open(object)
+			if err != nil {
+				return err
+			}
+			f, err := zw.CreateHeader(&zip.FileHeader{Name: user + "_" + object.Session + ".txt", Method: zip.Deflate, Modified: object.LastModified})
+			if err == nil {
+				_, err = io.Copy(f, body)
+			}
+			body.Close()
+			if err != nil {
+				return fmt.Errorf("Could not add %s to zip: %v", object.Key, err)
+			}
+		}
+		return zw.Close()
+	case "jsonl", "csv":
+		cw := csv.NewWriter(w)
+		enc := json.NewEncoder(w)
+		if format == "csv" {
+			cw.Write([]string{"session", "timestamp", "text"})
+		}
+		for _, object := range objects {
+			body, err := open(object)
+			if err != nil {
+				return err
+			}
+			scanner := bufio.NewScanner(body)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				columns := strings.SplitN(scanner.Text(), "|||", 2)
+				if len(columns) != 2 {
+					continue
+				}
+				if format == "csv" {
+					err = cw.Write([]string{object.Session, columns[0], columns[1]})
+				} else {
+					err = enc.Encode(ExportLine{Session: object.Session, Timestamp: columns[0], Text: columns[1]})
+				}
+				if err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = scanner.Err()
+			}
+			body.Close()
+			cw.Flush()
+			if err == nil {
+				err = cw.Error()
+			}
+			if err != nil {
+				return fmt.Errorf("Could not convert %s: %v", object.Key, err)
+			}
+		}
+		return nil
+	default:
+		for _, object := range objects {
+			body, err := open(object)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, body)
+			body.Close()
+			if err != nil {
+				return fmt.Errorf("Could not copy %s: %v", object.Key, err)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		size      int64
+		wantStart int64
+		wantEnd   int64
+		wantOK    bool
+	}{
+		{"whole range", "bytes=0-99", 100, 0, 99, true},
+		{"middle", "bytes=10-19", 100, 10, 19, true},
+		{"open end", "bytes=90-", 100, 90, 99, true},
+		{"end past the file", "bytes=90-500", 100, 90, 99, true},
+		{"suffix", "bytes=-10", 100, 90, 99, true},
+		{"suffix bigger than the file", "bytes=-500", 100, 0, 99, true},
+		{"spaces", "bytes= 5-9", 100, 5, 9, true},
+		{"other unit", "items=0-9", 100, 0, 99, true},
+		{"several ranges", "bytes=0-9,20-29", 100, 0, 99, true},
+		{"start past the file", "bytes=100-", 100, 0, 0, false},
+		{"end before start", "bytes=20-10", 100, 0, 0, false},
+		{"empty suffix", "bytes=-0", 100, 0, 0, false},
+		{"suffix of an empty file", "bytes=-10", 0, 0, 0, false},
+		{"no dash", "bytes=10", 100, 0, 0, false},
+		{"not a number", "bytes=a-b", 100, 0, 0, false},
+		{"negative start", "bytes=--5", 100, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, ok := ParseByteRange(tt.header, tt.size)
+			if ok != tt.wantOK || (ok && (start != tt.wantStart || end != tt.wantEnd)) {
+				t.Errorf("ParseByteRange(%q, %d) = %d, %d, %v, want %d, %d, %v", tt.header, tt.size, start, end, ok, tt.wantStart, tt.wantEnd, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestConversationETag(t *testing.T) {
+	objects := []ConversationObject{{Key: "conversations/alice_a.txt", ETag: `"1"`, Size: 10}, {Key: "conversations/alice_b.txt", ETag: `"2"`, Size: 20}}
+	etag := ConversationETag(objects, "txt")
+	if len(etag) != 34 || etag[0] != '"' || etag != ConversationETag(objects, "txt") {
+		t.Fatalf("ConversationETag() = %s, want a stable quoted tag", etag)
+	}
+	if ConversationETag(objects, "csv") == etag {
+		t.Errorf("same tag for another format")
+	}
+	objects[1].ETag = `"3"`
+	if ConversationETag(objects, "txt") == etag {
+		t.Errorf("same tag after a session file changed")
+	}
+}
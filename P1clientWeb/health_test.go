@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplyWaitMetric(t *testing.T) {
+	Metrics = NewAppMetrics()
+	r := NewReplyRouter(time.Minute, time.Second)
+	p := r.Expect("sess01", 2)
+	defer r.Cancel(p)
+	r.Dispatch(2, RXMsgStruct{SessID: "sess01"})
+	// A reply nobody waits for is held, its wait is measured by the handler that takes it
+	r.Dispatch(4, RXMsgStruct{SessID: "sess01"})
+
+	var b bytes.Buffer
+	Metrics.Write(&b)
+	if !strings.Contains(b.String(), `p1_reply_wait_seconds_count{cmd="2"} 1`) || strings.Contains(b.String(), `p1_reply_wait_seconds_count{cmd="4"}`) {
+		t.Errorf("reply wait series:\n%s", b.String())
+	}
+}
+
+func TestStartHealthServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	Metrics = NewAppMetrics()
+	Metrics.Polled()
+	StartHealthServer(addr)
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Get("http://" + addr + "/healthz"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("health server not listening: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /healthz = %d", resp.StatusCode)
+	}
+	// Only the health endpoints are served there, never the pages of the users
+	resp, err = http.Get("http://" + addr + "/menu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /menu on the health listener = %d, want 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+// HELP TEXTS AND QUEUE OF THE METRICS OF THIS APP, THE REST IS IN health_shared.go
+
+const (
+	polledQueue     = "outbox"
+	metricReplyWait = "p1_reply_wait_seconds"
+)
+
+var metricHelp = map[string]string{
+	metricReceived:  "Replies and notifications received from the outbox queue.",
+	metricProcessed: "Messages handed to their session and deleted from the outbox queue.",
+	metricFailed:    "Messages whose handling or deletion failed.",
+	metricSkipped:   "Messages released back to the outbox queue because they were for another client.",
+	metricLatency:   "Time taken to handle a message, deletion included.",
+	metricQueueLag:  "Time a handled message waited in the outbox queue before it was received.",
+	metricReplyWait: "Time from sending a request to a worker until its reply reached the handler waiting for it.",
+}
+
+var histogramMetrics = []string{metricLatency, metricQueueLag, metricReplyWait}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// BODY OF THE WORKER REPLIES TO MESSAGES REJECTED BY A QUOTA, THOSE REJECTED BY A RATE LIMIT GET RateLimitedBody
+const QuotaExceededBody = "QUOTA EXCEEDED"
+
+// COMMANDS WITH A RATE LIMIT, READ FROM ratelimit.[NAME].perminute AND ratelimit.[NAME].burst. THE WORKERS APPLY
+// THE SAME LIMITS, CHECKING THEM HERE TOO KEEPS THE REQUESTS THEY WOULD REJECT OUT OF THE INBOX
+var rateLimitNames = []string{"echo", "search", "pages", "stats", "savedsearches", "jobs", "login"}
+
+// TAKES A TOKEN OF THE LIMIT name FOR THE USER OF ClientData BEFORE SENDING ITS REQUEST. WHEN THERE IS NONE IT
+// RETURNS FALSE AND THE REPLY A WORKER WOULD HAVE SENT
+func LocalRateLimit(ClientData ClientStruct, name string) (RXMsgStruct, bool) {
+	allowed, wait := Limits.Allow(ClientData.Client, name)
+	if allowed {
+		return RXMsgStruct{}, true
+	}
+	log.Warnf("Rate limit of %s reached by %s", name, ClientData.Client)
+	return RXMsgStruct{
+		Body:       RateLimitedBody,
+		SessID:     ClientData.SessID,
+		Error:      RateLimitMessage(name, wait),
+		RetryAfter: RetrySeconds(wait),
+	}, false
+}
+
+// RETURNED BY Login WHEN THE USER TRIED TOO MANY TIMES
+type RateLimitError struct {
+	Message    string
+	RetryAfter int
+}
+
+func (e *RateLimitError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLocalRateLimit(t *testing.T) {
+	Limits = NewRateLimiter(map[string]RateLimit{"echo": {PerMinute: 30, Burst: 1}})
+	defer func() { Limits = NewRateLimiter(nil) }()
+	ClientData := ClientStruct{Client: "alice", SessID: "sess01"}
+
+	if _, ok := LocalRateLimit(ClientData, "echo"); !ok {
+		t.Fatalf("first echo rejected")
+	}
+	reply, ok := LocalRateLimit(ClientData, "echo")
+	if ok || reply.Body != RateLimitedBody || reply.SessID != "sess01" || reply.RetryAfter != 2 || reply.Error == "" {
+		t.Errorf("second echo = %+v %v, want a rate limited reply asking to wait 2 seconds", reply, ok)
+	}
+	if _, ok := LocalRateLimit(ClientData, "search"); !ok {
+		t.Errorf("command without a limit rejected")
+	}
+}
+
+func TestReadRateLimits(t *testing.T) {
+	Limits = NewRateLimiter(ReadRateLimits())
+	if ok, wait := Limits.Allow("alice", "echo"); !ok || wait != 0 {
+		t.Errorf("limits applied without a [ratelimit] section")
+	}
+}
+
+func TestRemoteHost(t *testing.T) {
+	for addr, want := range map[string]string{"192.0.2.1:51234": "192.0.2.1", "[2001:db8::1]:443": "2001:db8::1", "pipe": "pipe"} {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = addr
+		if got := RemoteHost(r); got != want {
+			t.Errorf("RemoteHost(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type replyKey struct {
+	SessID string
+	Cmd    int
+}
+
+// A HANDLER WAITING FOR THE REPLY OF ONE OF ITS REQUESTS. THE REPLY IS DELIVERED ONCE THROUGH C
+type PendingReply struct {
+	key   replyKey
+	since time.Time
+	C     chan RXMsgStruct
+}
+
+type heldReply struct {
+	msg      RXMsgStruct
+	received time.Time
+}
+
+// RETURNED BY Wait WHEN THE WORKER DID NOT ANSWER WITHIN THE REPLY TIMEOUT
+var ErrReplyTimeout = errors.New("The worker did not respond in time")
+
+// RETURNED INSTEAD OF WAITING WHEN A REQUEST COULD NOT BE SENT TO THE INBOX QUEUE, NO WORKER WILL EVER ANSWER IT.
+// Err IS THE CAUSE, IT IS LOGGED BUT NOT SHOWN TO THE USER
+type SendError struct {
+	Err error
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("Could not send the request to the inbox queue: %v", e.Err)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// STATUS ANSWERING A REQUEST WHOSE REPLY WAS NOT RECEIVED BECAUSE OF err: 504 IF THE WORKER OR THE REQUEST DEADLINE
+// TIMED OUT, 0 IF THE CLIENT CANCELLED THE REQUEST (IT LEFT, NOTHING IS WRITTEN) AND 502 OTHERWISE, THE REQUEST
+// NEVER REACHED A WORKER OR ITS REPLY WAS UNUSABLE
+func ReplyStatus(err error) int {
+	if errors.Is(err, context.Canceled) {
+		return 0
+	} else if errors.Is(err, ErrReplyTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// DELIVERS EVERY REPLY OF THE OUTBOX QUEUE TO THE HANDLER WAITING FOR IT. HANDLERS REGISTER WITH Expect (BEFORE
+// SENDING THEIR REQUEST) AND REPLIES ARE MATCHED BY SESSION AND COMMAND, OLDEST WAITER FIRST. A REPLY NOBODY IS
+// WAITING FOR YET IS HELD FOR holdFor IN CASE ITS HANDLER REGISTERS LATE AND DROPPED AFTERWARDS.
+// HANDLERS WAIT AT MOST timeout FOR A REPLY
+type ReplyRouter struct {
+	mu       sync.Mutex
+	holdFor  time.Duration
+	timeout  time.Duration
+	sessions map[string]bool
+	waiting  map[replyKey][]*PendingReply
+	held     map[replyKey][]heldReply
+}
+
+var Replies *ReplyRouter
+
+func NewReplyRouter(holdFor time.Duration, timeout time.Duration) *ReplyRouter {
+	return &ReplyRouter{
+		holdFor:  holdFor,
+		timeout:  timeout,
+		sessions: make(map[string]bool),
+		waiting:  make(map[replyKey][]*PendingReply),
+		held:     make(map[replyKey][]heldReply),
+	}
+}
+
+// REGISTERS A SESSION OPENED IN THIS WEB CLIENT. REPLIES TO ANY OTHER SESSION BELONG TO ANOTHER CLIENT
+func (r *ReplyRouter) AddSession(sessID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[sessID] = true
+}
+
+// FORGETS A SESSION CLOSED OR NEVER OPENED (FAILED LOGIN). LATE REPLIES TO IT ARE LEFT FOR OTHER CLIENTS
+func (r *ReplyRouter) RemoveSession(sessID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, sessID)
+}
+
+// TRUE IF sessID WAS OPENED IN THIS WEB CLIENT
+func (r *ReplyRouter) Local(sessID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sessions[sessID]
+}
+
+// REGISTERS A HANDLER WAITING FOR THE NEXT REPLY WITH COMMAND cmd TO THE SESSION sessID. A HELD REPLY IS
+// DELIVERED RIGHT AWAY. THE HANDLER MUST CALL Cancel WHEN IT STOPS WAITING
+func (r *ReplyRouter) Expect(sessID string, cmd int) *PendingReply {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireLocked()
+	p := &PendingReply{key: replyKey{SessID: sessID, Cmd: cmd}, since: time.Now(), C: make(chan RXMsgStruct, 1)}
+	if held := r.held[p.key]; len(held) > 0 {
+		p.C <- held[0].msg
+		r.setHeldLocked(p.key, held[1:])
+		return p
+	}
+	r.waiting[p.key] = append(r.waiting[p.key], p)
+	return p
+}
+
+// WAITS FOR THE REPLY OF p UNTIL THE ROUTER TIMEOUT EXPIRES (ErrReplyTimeout) OR ctx IS DONE (ctx.Err()),
+// WHICHEVER COMES FIRST. A TIMEOUT <= 0 WAITS ONLY FOR ctx
+func (r *ReplyRouter) Wait(ctx context.Context, p *PendingReply) (RXMsgStruct, error) {
+	var expired <-chan time.Time
+	if r.timeout > 0 {
+		timer := time.NewTimer(r.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case msg := <-p.C:
+		return msg, nil
+	case <-expired:
+		return RXMsgStruct{}, ErrReplyTimeout
+	case <-ctx.Done():
+		return RXMsgStruct{}, ctx.Err()
+	}
+}
+
+// UNREGISTERS A HANDLER THAT STOPPED WAITING. REPLIES ARRIVING LATER ARE HELD AND THEN DROPPED
+func (r *ReplyRouter) Cancel(p *PendingReply) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	waiting := r.waiting[p.key]
+	for i, w := range waiting {
+		if w == p {
+			waiting = append(waiting[:i], waiting[i+1:]...)
+			break
+		}
+	}
+	if len(waiting) == 0 {
+		delete(r.waiting, p.key)
+	} else {
+		r.waiting[p.key] = waiting
+	}
+}
+
+// DELIVERS A REPLY WITH COMMAND cmd TO THE OLDEST HANDLER WAITING FOR IT OR HOLDS IT IF THERE IS NONE
+func (r *ReplyRouter) Dispatch(cmd int, msg RXMsgStruct) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireLocked()
+	key := replyKey{SessID: msg.SessID, Cmd: cmd}
+	if waiting := r.waiting[key]; len(waiting) > 0 {
+		Metrics.Observe(metricReplyWait, MetricCmd(strconv.Itoa(cmd)), latencyBuckets, time.Since(waiting[0].since).Seconds())
+		waiting[0].C <- msg
+		if len(waiting) == 1 {
+			delete(r.waiting, key)
+		} else {
+			r.waiting[key] = waiting[1:]
+		}
+		return
+	}
+	r.held[key] = append(r.held[key], heldReply{msg: msg, received: time.Now()})
+}
+
+func (r *ReplyRouter) setHeldLocked(key replyKey, held []heldReply) {
+	if len(held) == 0 {
+		delete(r.held, key)
+	} else {
+		r.held[key] = held
+	}
+}
+
+// DROPS THE REPLIES HELD FOR LONGER THAN holdFor
+func (r *ReplyRouter) expireLocked() {
+	for key, held := range r.held {
+		kept := held[:0]
+		for _, h := range held {
+			if time.Since(h.received) <= r.holdFor {
+				kept = append(kept, h)
+			} else {
+				log.Warnf("Dropping reply (command %d) to session %s, nobody waited for it", key.Cmd, key.SessID)
+			}
+		}
+		r.setHeldLocked(key, kept)
+	}
+}
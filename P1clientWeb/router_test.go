@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// REPLY ALREADY IN p.C, WITHOUT WAITING
+func delivered(p *PendingReply) (RXMsgStruct, bool) {
+	select {
+	case msg := <-p.C:
+		return msg, true
+	default:
+		return RXMsgStruct{}, false
+	}
+}
+
+func TestReplyRouterDispatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		replyFirst bool          // The reply arrives before the handler calls Expect
+		age        time.Duration // How long ago a reply that arrived first was received
+		replySess  string
+		replyCmd   int
+		wantBody   string // "" if the handler gets no reply
+	}{
+		{"handler waiting", false, 0, "sess01", 2, "results"},
+		{"reply held for a late handler", true, 0, "sess01", 2, "results"},
+		{"held reply expired", true, time.Hour, "sess01", 2, ""},
+		{"reply of another command", false, 0, "sess01", 4, ""},
+		{"reply of another session", false, 0, "sess02", 2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReplyRouter(time.Minute, time.Second)
+			reply := RXMsgStruct{SessID: tt.replySess, Body: "results"}
+			var p *PendingReply
+			if tt.replyFirst {
+				r.Dispatch(tt.replyCmd, reply)
+				key := replyKey{SessID: tt.replySess, Cmd: tt.replyCmd}
+				r.held[key][0].received = time.Now().Add(-tt.age)
+				p = r.Expect("sess01", 2)
+			} else {
+				p = r.Expect("sess01", 2)
+				r.Dispatch(tt.replyCmd, reply)
+			}
+			defer r.Cancel(p)
+			msg, _ := delivered(p)
+			if msg.Body != tt.wantBody {
+				t.Errorf("handler got %q, want %q", msg.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestReplyRouterOrder(t *testing.T) {
+	r := NewReplyRouter(time.Minute, time.Second)
+	first := r.Expect("sess01", 2)
+	second := r.Expect("sess01", 2)
+	cancelled := r.Expect("sess01", 4)
+	r.Cancel(cancelled)
+
+	r.Dispatch(2, RXMsgStruct{SessID: "sess01", Body: "one"})
+	r.Dispatch(2, RXMsgStruct{SessID: "sess01", Body: "two"})
+	r.Dispatch(4, RXMsgStruct{SessID: "sess01", Body: "late"})
+
+	tests := []struct {
+		name string
+		p    *PendingReply
+		want string
+	}{
+		{"oldest handler gets the first reply", first, "one"},
+		{"next handler gets the next reply", second, "two"},
+		{"cancelled handler gets nothing", cancelled, ""},
+		{"reply of the cancelled handler is held", r.Expect("sess01", 4), "late"},
+	}
+	for _, tt := range tests {
+		msg, _ := delivered(tt.p)
+		if msg.Body != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, msg.Body, tt.want)
+		}
+	}
+}
+
+func TestReplyRouterWait(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		ctx      context.Context
+		dispatch bool
+		wantErr  error
+	}{
+		{"reply", time.Second, context.Background(), true, nil},
+		{"timeout", 10 * time.Millisecond, context.Background(), false, ErrReplyTimeout},
+		{"request cancelled", time.Second, cancelled, false, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReplyRouter(time.Minute, tt.timeout)
+			p := r.Expect("sess01", 1)
+			defer r.Cancel(p)
+			if tt.dispatch {
+				r.Dispatch(1, RXMsgStruct{SessID: "sess01", Body: "echo"})
+			}
+			if _, err := r.Wait(tt.ctx, p); err != tt.wantErr {
+				t.Errorf("Wait() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReplyRouterWaitNoTimeout(t *testing.T) {
+	// Without a router timeout only the request deadline ends the wait
+	r := NewReplyRouter(time.Minute, 0)
+	p := r.Expect("sess01", 1)
+	defer r.Cancel(p)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.Wait(ctx, p); err != context.DeadlineExceeded {
+		t.Errorf("Wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestReplyStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"worker timeout", ErrReplyTimeout, http.StatusGatewayTimeout},
+		{"login timeout", fmt.Errorf("%w, try again later", ErrReplyTimeout), http.StatusGatewayTimeout},
+		{"request deadline", context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{"client left", context.Canceled, 0},
+		{"not sent", &SendError{Err: errors.New("AccessDenied")}, http.StatusBadGateway},
+		{"other failure", errors.New("Malformed reply"), http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		if got := ReplyStatus(tt.err); got != tt.want {
+			t.Errorf("ReplyStatus(%s) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReplyError(t *testing.T) {
+	tpl = template.Must(LoadTemplates())
+	left, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		err      error
+		want     int
+		wantText string
+	}{
+		{"timeout", context.Background(), ErrReplyTimeout, http.StatusGatewayTimeout, "did not respond in time"},
+		{"not sent", context.Background(), &SendError{Err: errors.New("AccessDenied: queue arn:aws:sqs:inbox")}, http.StatusBadGateway, "could not be passed"},
+		{"browser left", left, context.Canceled, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/echo", nil).WithContext(tt.ctx)
+			w := httptest.NewRecorder()
+			ReplyError(w, r, ClientStruct{Client: "alice", SessID: "sess01"}, tt.err)
+			if tt.want == 0 {
+				if w.Body.Len() != 0 {
+					t.Errorf("answered a browser that left: %d %q", w.Code, w.Body.String())
+				}
+				return
+			}
+			if w.Code != tt.want || !strings.Contains(w.Body.String(), tt.wantText) {
+				t.Errorf("ReplyError() = %d %q, want %d with %q", w.Code, w.Body.String(), tt.want, tt.wantText)
+			}
+			if strings.Contains(w.Body.String(), "AccessDenied") {
+				t.Errorf("cause of the failure shown to the user")
+			}
+			r = httptest.NewRequest(http.MethodGet, "/api/v1/search/abc", nil).WithContext(tt.ctx)
+			w = httptest.NewRecorder()
+			APIReplyError(w, r, tt.err)
+			if w.Code != tt.want || strings.Contains(w.Body.String(), "AccessDenied") {
+				t.Errorf("APIReplyError() = %d %q, want %d", w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestReplyRouterSessions(t *testing.T) {
+	r := NewReplyRouter(time.Minute, time.Second)
+	r.AddSession("sess01")
+	if !r.Local("sess01") || r.Local("sess02") {
+		t.Errorf("Local() = %v %v, want only sess01 local", r.Local("sess01"), r.Local("sess02"))
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/sirupsen/logrus"
+	viper "github.com/theherk/viper"
+)
+
+// STANDING QUERY OF THE USER OVER THE CONVERSATION OF Target, AS LISTED BY THE ECHO APP
+type SavedSearchStruct struct {
+	ID      string
+	Target  string
+	Query   string
+	Created string
+}
+
+// NEW LINE OF Target MATCHING ONE OF THE SAVED SEARCHES OF THE USER
+type NotificationStruct struct {
+	Target    string
+	Query     string
+	Timestamp string
+	Text      string
+}
+
+// NOTIFICATIONS WAITING TO BE SEEN BY EVERY USER THAT LOGGED IN THIS WEB CLIENT, THE LAST notifications.peruser OF
+// EACH. THEY ARE ONLY KEPT IN MEMORY. NOTIFICATIONS OF OTHER USERS ARE LEFT IN THE OUTBOX FOR THEIR OWN CLIENTS
+type NotificationStore struct {
+	mu    sync.Mutex
+	users map[string][]NotificationStruct
+}
+
+var Notifications = &NotificationStore{users: make(map[string][]NotificationStruct)}
+
+// REGISTERS A USER SO ITS NOTIFICATIONS ARE KEPT BY THIS WEB CLIENT
+func (n *NotificationStore) AddUser(user string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.users[user]; !ok {
+		n.users[user] = []NotificationStruct{}
+	}
+}
+
+// STORES A NOTIFICATION, DROPPING THE OLDEST ONES OVER notifications.peruser. RETURNS FALSE IF THE USER NEVER LOGGED
+// IN THIS WEB CLIENT
+func (n *NotificationStore) Add(user string, notification NotificationStruct) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	list, ok := n.users[user]
+	if !ok {
+		return false
+	}
+	list = append(list, notification)
+	if max := viper.GetInt("notifications.peruser"); max > 0 && len(list) > max {
+		list = append([]NotificationStruct(nil), list[len(list)-max:]...)
+	}
+	n.users[user] = list
+	return true
+}
+
+// LEAVES A NOTIFICATION OF A USER OF ANOTHER CLIENT IN THE OUTBOX, HIDDEN FOR notifications.retryseconds SO THIS
+// CLIENT DOES NOT RECEIVE IT AGAIN RIGHT AWAY. ONCE IT WAS RECEIVED notifications.maxreceives TIMES NO CLIENT OF THE
+// USER IS RUNNING AND IT IS DELETED INSTEAD, RETURNING TRUE
+func ReleaseNotification(msg *sqs.Message) (bool, error) {
+	receives, _ := strconv.Atoi(aws.StringValue(msg.Attributes["ApproximateReceiveCount"]))
+	if max := viper.GetInt("notifications.maxreceives"); max > 0 && receives >= max {
+		log.Warnf("Dropping notification of %s, no client picked it up in %d receives", GetAttribute(msg, "clientName"), receives)
+		_, err := sqssvc.DeleteMessage(&sqs.DeleteMessageInput{
+			QueueUrl:      &outboxURL,
+			ReceiptHandle: msg.ReceiptHandle,
+		})
+		return true, err
+	}
+	_, err := sqssvc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          &outboxURL,
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: aws.Int64(viper.GetInt64("notifications.retryseconds")),
+	})
+	return false, err
+}
+
+func (n *NotificationStore) List(user string) []NotificationStruct {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]NotificationStruct(nil), n.users[user]...)
+}
+
+func (n *NotificationStore) Clear(user string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.users[user]; ok {
+		n.users[user] = []NotificationStruct{}
+	}
+}
+
+func savedsearches(w http.ResponseWriter, r *http.Request) {
+	ClientData, _, ok := SessionClientData(w, r, 5)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+
+	action := r.FormValue("action")
+	if action == "" {
+		action = "list"
+	}
+	body := strings.ToUpper(action)
+	if action == "add" {
+		body = r.FormValue("query")
+		if target := r.FormValue("target"); target != "" && !CanAccess(ClientData, target) {
+			ClientData.Notifications = Notifications.List(ClientData.Client)
+			RenderForbidden(w, ClientData, target, "savedsearches.gohtml")
+			return
+		}
+	}
+	timestamp := time.Now().Format("02-Jan-2006 15:04:05")
+
+	msg := &sqs.SendMessageInput{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"clientName": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.Client),
+			},
+			"sessionID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.SessID),
+			},
+			"timestamp": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(timestamp),
+			},
+			"cmd": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String("5"),
+			},
+			"token": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.AuthToken),
+			},
+			"action": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(action),
+			},
+		},
+		MessageBody: aws.String(body),
+		QueueUrl:    &inboxURL,
+	}
+	if target := r.FormValue("target"); target != "" {
+		msg.MessageAttributes["target"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(target),
+		}
+	}
+	if searchID := r.FormValue("searchid"); searchID != "" {
+		msg.MessageAttributes["searchID"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(searchID),
+		}
+	}
+
+	reply := Replies.Expect(ClientData.SessID, 5)
+	defer Replies.Cancel(reply)
+	log.Infof("Sending saved search command to AWS echo app. ACTION: %s", action)
+	var result *sqs.SendMessageOutput
+	var err error
+	if rejected, ok := LocalRateLimit(ClientData, "savedsearches"); !ok {
+		ClientData.Error = rejected.Error
+	} else if result, err = sqssvc.SendMessage(msg); err != nil {
+		log.Errorf("Could not send message to SQS queue: %v", err)
+		ReplyError(w, r, ClientData, &SendError{Err: err})
+		return
+	} else {
+		log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+		msgrx, err := Replies.Wait(r.Context(), reply)
+		if err != nil {
+			ReplyError(w, r, ClientData, err)
+			return
+		}
+		ClientData.Error = msgrx.Error
+		ClientData.SavedSearches = ParseSavedSearches(msgrx.Body)
+	}
+
+	ClientData.Notifications = Notifications.List(ClientData.Client)
+	err = tpl.ExecuteTemplate(w, "savedsearches.gohtml", ClientData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// DISMISSES ALL THE NOTIFICATIONS OF THE USER AND GOES BACK TO THE MENU
+func notifications(w http.ResponseWriter, r *http.Request) {
+	ClientData, _, ok := SessionClientData(w, r, 0)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if r.Method == http.MethodPost {
+		Notifications.Clear(ClientData.Client)
+	}
+	ClientData.Notifications = Notifications.List(ClientData.Client)
+	err := tpl.ExecuteTemplate(w, "menu.gohtml", ClientData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// PARSES THE SAVED SEARCHES SENT BY THE ECHO APP ([ID]|||[TARGET]|||[QUERY]|||[CREATED] SEPARATED BY ///)
+func ParseSavedSearches(list string) []SavedSearchStruct {
+	var searches []SavedSearchStruct
+	for _, line := range strings.Split(list, "///") {
+		columns := strings.Split(line, "|||")
+		if len(columns) == 4 {
+			searches = append(searches, SavedSearchStruct{ID: columns[0], Target: columns[1], Query: columns[2], Created: columns[3]})
+		}
+	}
+	return searches
+}
+
+// PARSES A SAVED SEARCH MATCH ([TIMESTAMP]|||[TEXT])
+func ParseNotification(msg *sqs.Message) (NotificationStruct, error) {
+	columns := strings.SplitN(*msg.Body, "|||", 2)
+	if len(columns) != 2 {
+		return NotificationStruct{}, fmt.Errorf("Malformed notification %q", *msg.Body)
+	}
+	return NotificationStruct{
+		Target:    GetAttribute(msg, "target"),
+		Query:     GetAttribute(msg, "query"),
+		Timestamp: columns[0],
+		Text:      columns[1],
+	}, nil
+}
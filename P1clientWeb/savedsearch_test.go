@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	viper "github.com/theherk/viper"
+)
+
+func TestNotificationStore(t *testing.T) {
+	store := &NotificationStore{users: make(map[string][]NotificationStruct)}
+	note := NotificationStruct{Target: "bob", Query: "deploy", Timestamp: "10-Mar-2024 09:00:00", Text: "deploy done"}
+
+	if store.Add("alice", note) {
+		t.Fatalf("notification kept for a user that never logged in")
+	}
+	store.AddUser("alice")
+	if !store.Add("alice", note) || !store.Add("alice", note) {
+		t.Fatalf("notification of a logged in user refused")
+	}
+	// Registering again must not drop what is pending
+	store.AddUser("alice")
+	if got := store.List("alice"); len(got) != 2 || got[0] != note {
+		t.Errorf("List() = %v, want two notifications", got)
+	}
+	store.Clear("alice")
+	if got := store.List("alice"); len(got) != 0 {
+		t.Errorf("List() after Clear = %v", got)
+	}
+	if !store.Add("alice", note) {
+		t.Errorf("user forgotten after Clear")
+	}
+}
+
+func TestNotificationCap(t *testing.T) {
+	viper.Set("notifications.peruser", 3)
+	defer viper.Reset()
+	store := &NotificationStore{users: make(map[string][]NotificationStruct)}
+	store.AddUser("alice")
+	for i := 1; i <= 5; i++ {
+		store.Add("alice", NotificationStruct{Target: "bob", Text: fmt.Sprintf("line %d", i)})
+	}
+	got := store.List("alice")
+	if len(got) != 3 || got[0].Text != "line 3" || got[2].Text != "line 5" {
+		t.Errorf("List() = %v, want the last 3 notifications", got)
+	}
+}
+
+func TestParseSavedSearches(t *testing.T) {
+	got := ParseSavedSearches("1|||bob|||deploy|||10-Mar-2024 09:00:00///broken///2|||carol|||a|||b|||11-Mar-2024 10:00:00")
+	if len(got) != 1 || got[0] != (SavedSearchStruct{ID: "1", Target: "bob", Query: "deploy", Created: "10-Mar-2024 09:00:00"}) {
+		t.Errorf("ParseSavedSearches() = %v", got)
+	}
+	if got := ParseSavedSearches(""); len(got) != 0 {
+		t.Errorf("ParseSavedSearches(\"\") = %v", got)
+	}
+}
+
+func TestParseNotification(t *testing.T) {
+	msg := &sqs.Message{
+		Body: aws.String("10-Mar-2024 09:00:00|||text with ||| inside"),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"target": {DataType: aws.String("String"), StringValue: aws.String("bob")},
+			"query":  {DataType: aws.String("String"), StringValue: aws.String("inside")},
+		},
+	}
+	note, err := ParseNotification(msg)
+	if err != nil {
+		t.Fatalf("ParseNotification() error = %v", err)
+	}
+	want := NotificationStruct{Target: "bob", Query: "inside", Timestamp: "10-Mar-2024 09:00:00", Text: "text with ||| inside"}
+	if note != want {
+		t.Errorf("ParseNotification() = %+v, want %+v", note, want)
+	}
+
+	msg.Body = aws.String("no separator")
+	if _, err := ParseNotification(msg); err == nil {
+		t.Errorf("ParseNotification accepted a malformed body")
+	}
+}
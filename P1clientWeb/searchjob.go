@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/sirupsen/logrus"
+)
+
+// STATUS OF A SEARCH JOB AS SENT BY THE SEARCH APP
+type JobStatusStruct struct {
+	JobID           string `json:"jobID"`
+	Client          string `json:"client"`
+	Sentence        string `json:"sentence"`
+	Parent          string `json:"parent"`
+	Status          string `json:"status"`
+	SessionsScanned int    `json:"sessionsScanned"`
+	TotalSessions   int    `json:"totalSessions"`
+	Matches         int    `json:"matches"`
+	Error           string `json:"error"`
+	Created         string `json:"created"`
+	RetryAfter      int    `json:"-"` // Seconds to wait when the request was rate limited
+}
+
+// PERCENTAGE OF THE SESSIONS ALREADY SCANNED, FOR THE PROGRESS BAR
+func (s JobStatusStruct) Percent() int {
+	if s.TotalSessions == 0 {
+		return 0
+	}
+	return s.SessionsScanned * 100 / s.TotalSessions
+}
+
+// TRUE WHILE THE JOB CAN STILL CHANGE, SO THE JOB PAGE KEEPS POLLING
+func (s JobStatusStruct) Pending() bool {
+	return s.Status == "queued" || s.Status == "running" || s.Status == "unknown"
+}
+
+// SHOWS THE STATUS OF A SEARCH JOB (CANCELLING IT FIRST IF action IS "cancel"). ONCE THE JOB IS DONE ITS FIRST PAGE
+// IS SHOWN INSTEAD
+func searchjob(w http.ResponseWriter, r *http.Request) {
+	ClientData, _, ok := SessionClientData(w, r, 2)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	ReadSearchForm(r, &ClientData.SearchData)
+	action := r.FormValue("action")
+	if action != "cancel" {
+		action = "status"
+	}
+
+	status, err := RequestJobStatus(r.Context(), ClientData, action)
+	if err != nil {
+		ReplyError(w, r, ClientData, err)
+		return
+	}
+	if status.Status == "done" {
+		ClientData.SearchData.Cursor = ""
+		if err := RequestSearchPage(r.Context(), &ClientData); err != nil {
+			ReplyError(w, r, ClientData, err)
+			return
+		}
+		err := tpl.ExecuteTemplate(w, "search.gohtml", ClientData)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ClientData.SearchJob = &status
+	err = tpl.ExecuteTemplate(w, "searchjob.gohtml", ClientData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// SENDS A STATUS OR CANCEL REQUEST FOR THE JOB OF THE SEARCH FORM AND WAITS FOR ITS STATUS. RETURNS
+// ErrReplyTimeout IF THE SEARCH APP DID NOT ANSWER IN TIME, ctx.Err() IF THE BROWSER LEFT BEFORE OR A *SendError IF
+// THE REQUEST COULD NOT BE SENT
+func RequestJobStatus(ctx context.Context, ClientData ClientStruct, action string) (JobStatusStruct, error) {
+	status := JobStatusStruct{
+		JobID:    ClientData.SearchData.JobID,
+		Client:   ClientData.SearchData.ClientSearch,
+		Sentence: ClientData.SearchData.Keysentence,
+		Status:   "unknown",
+	}
+	timestamp := time.Now().Format("02-Jan-2006 15:04:05")
+
+	msg := &sqs.SendMessageInput{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"clientName": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.Client),
+			},
+			"sessionID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.SessID),
+			},
+			"timestamp": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(timestamp),
+			},
+			"cmd": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String("7"),
+			},
+			"token": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.AuthToken),
+			},
+			"jobID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.SearchData.JobID),
+			},
+			"action": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(action),
+			},
+		},
+		MessageBody: aws.String(action),
+		QueueUrl:    &inboxURL,
+	}
+
+	if rejected, ok := LocalRateLimit(ClientData, "jobs"); !ok {
+		status.Error, status.RetryAfter = rejected.Error, rejected.RetryAfter
+		return status, nil
+	}
+	reply := Replies.Expect(ClientData.SessID, 7)
+	defer Replies.Cancel(reply)
+	log.Infof("Sending search job command to AWS search app. JOB: %s\tACTION: %s", ClientData.SearchData.JobID, action)
+	result, err := sqssvc.SendMessage(msg)
+	if err != nil {
+		log.Errorf("Could not send message to SQS queue: %v", err)
+		return status, &SendError{Err: err}
+	}
+	log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+	msgrx, err := Replies.Wait(ctx, reply)
+	if err != nil {
+		return status, err
+	}
+	if msgrx.Error != "" {
+		status.Error, status.RetryAfter = msgrx.Error, msgrx.RetryAfter
+		return status, nil
+	}
+	err = json.Unmarshal([]byte(msgrx.Body), &status)
+	if err != nil {
+		log.Errorf("Could not decode search job status: %v", err)
+	}
+	return status, nil
+}
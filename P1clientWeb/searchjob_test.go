@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestJobStatusProgress(t *testing.T) {
+	for status, pending := range map[string]bool{"queued": true, "running": true, "unknown": true, "done": false, "cancelled": false, "failed": false} {
+		if got := (JobStatusStruct{Status: status}).Pending(); got != pending {
+			t.Errorf("Pending() of a %s job = %v, want %v", status, got, pending)
+		}
+	}
+	if got := (JobStatusStruct{SessionsScanned: 1, TotalSessions: 3}).Percent(); got != 33 {
+		t.Errorf("Percent() = %d, want 33", got)
+	}
+	if got := (JobStatusStruct{}).Percent(); got != 0 {
+		t.Errorf("Percent() before the sessions are listed = %d, want 0", got)
+	}
+}
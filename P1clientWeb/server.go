@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	viper "github.com/theherk/viper"
+)
+
+const defaultCSP = "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; " +
+	"connect-src 'self'; form-action 'self'; frame-ancestors 'none'; base-uri 'self'"
+
+// SETTINGS OF THE HTTP SERVER, READ FROM THE [web] SECTION OF THE CONFIG FILE
+type WebConfig struct {
+	Listen            string
+	TLSCert           string
+	TLSKey            string
+	SelfSigned        bool
+	SelfSignedHosts   []string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	MaxBodyBytes      int64
+	CSP               string
+	HSTSMaxAge        int
+	FrameOptions      string
+}
+
+var webConfig WebConfig
+
+// READS THE [web] SECTION, FILLING THE KEYS LEFT OUT WITH THEIR DEFAULTS
+func ReadWebConfig() WebConfig {
+	seconds := func(key string, def int) time.Duration {
+		if n := viper.GetInt(key); n > 0 {
+			return time.Duration(n) * time.Second
+		}
+		return time.Duration(def) * time.Second
+	}
+	cfg := WebConfig{
+		Listen:            viper.GetString("web.listen"),
+		TLSCert:           viper.GetString("web.tlscert"),
+		TLSKey:            viper.GetString("web.tlskey"),
+		SelfSigned:        viper.GetBool("web.selfsigned"),
+		SelfSignedHosts:   viper.GetStringSlice("web.selfsignedhosts"),
+		ReadTimeout:       seconds("web.readtimeoutseconds", 15),
+		ReadHeaderTimeout: seconds("web.readheadertimeoutseconds", 5),
+		WriteTimeout:      seconds("web.writetimeoutseconds", 60),
+		IdleTimeout:       seconds("web.idletimeoutseconds", 120),
+		MaxHeaderBytes:    viper.GetInt("web.maxheaderbytes"),
+		MaxBodyBytes:      viper.GetInt64("web.maxbodybytes"),
+		CSP:               viper.GetString("web.contentsecuritypolicy"),
+		HSTSMaxAge:        viper.GetInt("web.hstsmaxageseconds"),
+		FrameOptions:      viper.GetString("web.frameoptions"),
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = ":8080"
+	}
+	if len(cfg.SelfSignedHosts) == 0 {
+		cfg.SelfSignedHosts = []string{"localhost", "127.0.0.1"}
+	}
+	if cfg.MaxHeaderBytes <= 0 {
+		cfg.MaxHeaderBytes = 64 * 1024
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	if cfg.CSP == "" {
+		cfg.CSP = defaultCSP
+	}
+	if cfg.FrameOptions == "" {
+		cfg.FrameOptions = "DENY"
+	}
+	return cfg
+}
+
+// TRUE IF THE SERVER LISTENS WITH TLS
+func (cfg WebConfig) TLS() bool {
+	return (cfg.TLSCert != "" && cfg.TLSKey != "") || cfg.SelfSigned
+}
+
+// BUILDS THE HTTP SERVER OF THE WEB CLIENT. WITH web.tlscert AND web.tlskey IT SERVES HTTPS WITH THAT CERTIFICATE,
+// WITH web.selfsigned (ONLY MEANT FOR LOCAL USE) WITH A CERTIFICATE GENERATED ON START
+func NewServer(cfg WebConfig, handler http.Handler) (*http.Server, error) {
+	srv := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           SecurityHeaders(cfg, handler),
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+	if !cfg.TLS() {
+		return srv, nil
+	}
+	var cert tls.Certificate
+	var err error
+	if cfg.TLSCert != "" && cfg.TLSKey != "" {
+		cert, err = tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load TLS certificate %s: %v", cfg.TLSCert, err)
+		}
+	} else {
+		log.Warnf("[INIT] Using a self-signed certificate for %v, browsers will not trust it", cfg.SelfSignedHosts)
+		cert, err = GenerateSelfSignedCert(cfg.SelfSignedHosts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	srv.TLSConfig = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	return srv, nil
+}
+
+// STARTS SERVING, WITH TLS IF THE SERVER HAS A CERTIFICATE. ONLY RETURNS ON ERROR
+func ListenAndServe(srv *http.Server) error {
+	if srv.TLSConfig != nil {
+		log.Infof("Web client listening on https://%s", srv.Addr)
+		return srv.ListenAndServeTLS("", "")
+	}
+	log.Infof("Web client listening on http://%s", srv.Addr)
+	return srv.ListenAndServe()
+}
+
+// ADDS THE SECURITY HEADERS TO EVERY RESPONSE (HSTS ONLY OVER TLS) AND LIMITS REQUEST BODIES TO web.maxbodybytes
+func SecurityHeaders(cfg WebConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", cfg.CSP)
+		h.Set("X-Frame-Options", cfg.FrameOptions)
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "same-origin")
+		if r.TLS != nil && cfg.HSTSMaxAge > 0 {
+			h.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(cfg.HSTSMaxAge)+"; includeSubDomains")
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxBodyBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LONG RESPONSES (LIVE ECHO EVENTS AND DOWNLOADS) ARE NOT CUT BY web.readtimeoutseconds OR web.writetimeoutseconds.
+// THE READ DEADLINE MATTERS TOO BECAUSE THE SERVER CANCELS THE REQUEST WHEN ITS BACKGROUND READ TIMES OUT
+func DisableTimeouts(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		log.Debugf("Could not clear read deadline: %v", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Debugf("Could not clear write deadline: %v", err)
+	}
+}
+
+// GENERATES A SELF-SIGNED ECDSA CERTIFICATE VALID FOR ONE YEAR FOR THE GIVEN HOST NAMES AND IP ADDRESSES
+func GenerateSelfSignedCert(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Could not generate TLS key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Could not generate certificate serial: %v", err)
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"P1 web client"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Could not create self-signed certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	viper "github.com/theherk/viper"
+)
+
+func TestReadWebConfigDefaults(t *testing.T) {
+	viper.Reset()
+	cfg := ReadWebConfig()
+	if cfg.Listen != ":8080" || cfg.ReadHeaderTimeout != 5*time.Second || cfg.MaxBodyBytes != 1<<20 || cfg.CSP != defaultCSP || cfg.FrameOptions != "DENY" {
+		t.Errorf("ReadWebConfig() without a [web] section = %+v", cfg)
+	}
+	if cfg.TLS() {
+		t.Errorf("TLS on without a certificate")
+	}
+
+	viper.Set("web.writetimeoutseconds", 7)
+	viper.Set("web.tlscert", "cert.pem")
+	defer viper.Reset()
+	cfg = ReadWebConfig()
+	if cfg.WriteTimeout != 7*time.Second || cfg.TLS() {
+		t.Errorf("ReadWebConfig() = %+v, want a 7s write timeout and no TLS without a key", cfg)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	cfg := WebConfig{CSP: defaultCSP, FrameOptions: "DENY", HSTSMaxAge: 60, MaxBodyBytes: 10}
+	var readErr error
+	handler := SecurityHeaders(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = ioutil.ReadAll(r.Body)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/echo", strings.NewReader("short")))
+	h := w.Header()
+	if h.Get("Content-Security-Policy") != defaultCSP || h.Get("X-Frame-Options") != "DENY" || h.Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("security headers = %v", h)
+	}
+	if h.Get("Strict-Transport-Security") != "" || readErr != nil {
+		t.Errorf("HSTS sent over plain HTTP or small body rejected: %v", readErr)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "https://example.com/echo", strings.NewReader("a body over ten bytes")))
+	if w.Header().Get("Strict-Transport-Security") != "max-age=60; includeSubDomains" {
+		t.Errorf("HSTS over TLS = %q", w.Header().Get("Strict-Transport-Security"))
+	}
+	if readErr == nil {
+		t.Errorf("body over web.maxbodybytes read whole")
+	}
+}
+
+func TestNewServerTLS(t *testing.T) {
+	if _, err := NewServer(WebConfig{TLSCert: "missing.pem", TLSKey: "missing.key"}, http.NotFoundHandler()); err == nil {
+		t.Errorf("server built with a missing certificate")
+	}
+
+	cfg := WebConfig{SelfSigned: true, SelfSignedHosts: []string{"localhost", "127.0.0.1"}, MaxBodyBytes: 1 << 20, ReadHeaderTimeout: time.Second}
+	srv, err := NewServer(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.TLSConfig == nil || srv.TLSConfig.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("self-signed server without TLS 1.2+ config")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	go srv.Serve(tls.NewListener(ln, srv.TLSConfig))
+	defer srv.Close()
+
+	leaf, _ := x509.ParseCertificate(srv.TLSConfig.Certificates[0].Certificate[0])
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}, Timeout: 5 * time.Second}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("GET over the self-signed certificate: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("X-Content-Type-Options") != "nosniff" || resp.Header.Get("Strict-Transport-Security") != "" {
+		t.Errorf("headers over TLS = %v (HSTS is off with max age 0)", resp.Header)
+	}
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	srv, _ := NewServer(WebConfig{MaxHeaderBytes: 1024, MaxBodyBytes: 1 << 20}, http.NotFoundHandler())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", "http://"+ln.Addr().String()+"/", nil)
+	req.Header.Set("X-Big", strings.Repeat("a", 16*1024))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("request with 16KB of headers = %d, want 431", resp.StatusCode)
+	}
+}
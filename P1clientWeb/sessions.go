@@ -0,0 +1,412 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const sessionCookie = "p1session"
+
+// STATE OF A LOGGED USER, KEPT IN THE SERVER AND FOUND THROUGH THE SESSION COOKIE. SessID IS THE SESSION ID
+// SENT TO THE WORKERS, AuthToken THE TOKEN THE ECHO APP ISSUED ON LOGIN AND Transcript THE ECHO CONVERSATION
+// OF THE SESSION. LastSeq IS THE LAST SEQUENCE NUMBER GIVEN TO AN ENTRY OF THE TRANSCRIPT OR TO ITS CLEARING AND
+// CSRFToken THE TOKEN EVERY FORM OF THE SESSION MUST SEND BACK
+type WebSession struct {
+	Token      string      `json:"token"`
+	CSRFToken  string      `json:"csrfToken"`
+	Client     string      `json:"client"`
+	SessID     string      `json:"sessID"`
+	AuthToken  string      `json:"authToken"`
+	Transcript []EchoEntry `json:"transcript"`
+	LastSeq    int64       `json:"lastSeq"`
+	Created    time.Time   `json:"created"`
+	LastSeen   time.Time   `json:"lastSeen"`
+}
+
+// MESSAGE SENT TO THE ECHO APP AND ITS ECHO. Seq GROWS BY ONE FOR EVERY ENTRY OF THE SESSION SO A BROWSER THAT
+// LOST ITS LIVE CONNECTION CAN ASK FOR THE ENTRIES AFTER THE LAST ONE IT SAW
+type EchoEntry struct {
+	Seq       int64  `json:"seq"`
+	Client    string `json:"client"`
+	Message   string `json:"message"`
+	Echo      string `json:"echo"`
+	Timestamp string `json:"timestamp"`
+}
+
+// TEXT OF THE ENTRY AS SHOWN IN THE ECHO PAGE
+func (e EchoEntry) Text() string {
+	return e.Client + ":\t" + e.Message + "\nEcho:\t" + e.Echo + "\n\n"
+}
+
+func TranscriptText(entries []EchoEntry) string {
+	var b strings.Builder
+	for _, entry := range entries {
+		b.WriteString(entry.Text())
+	}
+	return b.String()
+}
+
+// SESSIONS OF EVERY USER OF THIS WEB CLIENT. SESSIONS EXPIRE AFTER ttl WITHOUT REQUESTS. WITH A path THE
+// SESSIONS ARE SAVED TO THAT FILE ON EVERY CHANGE AND LOADED FROM IT ON START
+type SessionStore struct {
+	mu            sync.Mutex
+	ttl           time.Duration
+	path          string
+	maxTranscript int
+	secure        bool
+	sessions      map[string]*WebSession
+	subscribers   map[string]map[chan EchoEntry]bool
+}
+
+var Sessions *SessionStore
+
+func NewSessionStore(ttl time.Duration, path string, maxTranscript int, secure bool) *SessionStore {
+	return &SessionStore{ttl: ttl, path: path, maxTranscript: maxTranscript, secure: secure, sessions: make(map[string]*WebSession), subscribers: make(map[string]map[chan EchoEntry]bool)}
+}
+
+// LOADS THE SESSIONS SAVED IN THE STORE FILE. A MISSING FILE IS NOT AN ERROR
+func (s *SessionStore) Load() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Could not read sessions file %s: %v", s.path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sessions []*WebSession
+	err = json.Unmarshal(b, &sessions)
+	if err != nil {
+		return fmt.Errorf("Could not decode sessions file %s: %v", s.path, err)
+	}
+	for _, session := range sessions {
+		if s.expired(session) {
+			continue
+		}
+		if session.CSRFToken == "" {
+			session.CSRFToken, err = RandomToken()
+			if err != nil {
+				return err
+			}
+		}
+		s.sessions[session.Token] = session
+	}
+	return nil
+}
+
+// CALLS f FOR EVERY LIVE SESSION
+func (s *SessionStore) Each(f func(session WebSession)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, session := range s.sessions {
+		if !s.expired(session) {
+			f(*session)
+		}
+	}
+}
+
+// OPENS A NEW SESSION WITH A RANDOM COOKIE TOKEN FOR client, WHO LOGGED IN AS THE WORKER SESSION sessID
+func (s *SessionStore) Create(client string, sessID string, authToken string) (*WebSession, error) {
+	token, err := RandomToken()
+	if err != nil {
+		return nil, err
+	}
+	csrfToken, err := RandomToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &WebSession{
+		Token:     token,
+		CSRFToken: csrfToken,
+		Client:    client,
+		SessID:    sessID,
+		AuthToken: authToken,
+		Created:   now,
+		LastSeen:  now,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session.Token] = session
+	s.saveLocked()
+	return session, nil
+}
+
+// RETURNS 32 RANDOM BYTES ENCODED AS BASE64 FOR URLS, FOR SESSION AND CSRF TOKENS
+func RandomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("Could not generate random token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// RETURNS A COPY OF THE SESSION OF token IF IT EXISTS AND DID NOT EXPIRE, MARKING IT AS SEEN
+func (s *SessionStore) Get(token string) (WebSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[token]
+	if !ok {
+		return WebSession{}, false
+	}
+	if s.expired(session) {
+		log.Infof("Session of %s expired, logging it out", session.Client)
+		delete(s.sessions, token)
+		s.closeSubscribersLocked(token)
+		s.saveLocked()
+		return WebSession{}, false
+	}
+	session.LastSeen = time.Now()
+	return *session, true
+}
+
+func (s *SessionStore) Delete(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+	s.closeSubscribersLocked(token)
+	s.saveLocked()
+}
+
+// APPENDS AN ECHO TO THE TRANSCRIPT OF A SESSION, KEEPING ONLY ITS LAST maxTranscript ENTRIES, AND PUSHES IT TO
+// THE LIVE CONNECTIONS OF THE SESSION. RETURNS FALSE IF THE SESSION IS GONE
+func (s *SessionStore) AppendTranscript(token string, message string, echo string) (EchoEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[token]
+	if !ok {
+		return EchoEntry{}, false
+	}
+	session.LastSeq++
+	entry := EchoEntry{
+		Seq:       session.LastSeq,
+		Client:    session.Client,
+		Message:   message,
+		Echo:      echo,
+		Timestamp: time.Now().Format("02-Jan-2006 15:04:05"),
+	}
+	session.Transcript = append(session.Transcript, entry)
+	if s.maxTranscript > 0 && len(session.Transcript) > s.maxTranscript {
+		session.Transcript = session.Transcript[len(session.Transcript)-s.maxTranscript:]
+	}
+	s.saveLocked()
+	for ch := range s.subscribers[token] {
+		select {
+		case ch <- entry:
+		default:
+			// A CONNECTION THAT DOES NOT KEEP UP IS CLOSED. THE BROWSER RECONNECTS AND GETS WHAT IT MISSED
+			delete(s.subscribers[token], ch)
+			close(ch)
+		}
+	}
+	return entry, true
+}
+
+// REPLACES THE WORKER TOKEN OF A SESSION WITH A RENEWED ONE
+func (s *SessionStore) SetAuthToken(token string, authToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[token]; ok {
+		session.AuthToken = authToken
+		s.saveLocked()
+	}
+}
+
+// EMPTIES THE TRANSCRIPT OF A SESSION. THE CLEARING TAKES A SEQUENCE NUMBER SO BROWSERS THAT SAW THE OLD ENTRIES
+// NOTICE IT, AND THE LIVE CONNECTIONS ARE CLOSED SO THEY RECONNECT AND RESET THEIR VIEW
+func (s *SessionStore) ClearTranscript(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[token]; ok {
+		session.Transcript = nil
+		session.LastSeq++
+		s.closeSubscribersLocked(token)
+		s.saveLocked()
+	}
+}
+
+// RETURNS THE TRANSCRIPT ENTRIES OF A SESSION AFTER since. reset IS TRUE WHEN SOME ENTRIES AFTER since ARE NO
+// LONGER KEPT (THE TRANSCRIPT WAS CLEARED OR TRIMMED) OR since IS UNKNOWN, SO THE BROWSER MUST DROP WHAT IT SHOWS
+// AND TAKE THE RETURNED ENTRIES, WHICH ARE THEN THE WHOLE TRANSCRIPT
+func (s *SessionStore) TranscriptSince(token string, since int64) (entries []EchoEntry, lastSeq int64, reset bool, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[token]
+	if !ok {
+		return nil, 0, false, false
+	}
+	first := session.LastSeq + 1
+	if len(session.Transcript) > 0 {
+		first = session.Transcript[0].Seq
+	}
+	if since > session.LastSeq || since < first-1 {
+		return append([]EchoEntry(nil), session.Transcript...), session.LastSeq, true, true
+	}
+	for _, entry := range session.Transcript {
+		if entry.Seq > since {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, session.LastSeq, false, true
+}
+
+// REGISTERS A LIVE CONNECTION OF A SESSION. NEW ENTRIES OF THE TRANSCRIPT ARE SENT TO THE RETURNED CHANNEL, WHICH
+// IS CLOSED WHEN THE CONNECTION MUST START AGAIN (TRANSCRIPT CLEARED, CONNECTION TOO SLOW OR SESSION CLOSED)
+func (s *SessionStore) Subscribe(token string) (chan EchoEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[token]; !ok {
+		return nil, false
+	}
+	ch := make(chan EchoEntry, 16)
+	if s.subscribers[token] == nil {
+		s.subscribers[token] = make(map[chan EchoEntry]bool)
+	}
+	s.subscribers[token][ch] = true
+	return ch, true
+}
+
+func (s *SessionStore) Unsubscribe(token string, ch chan EchoEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers[token][ch] {
+		delete(s.subscribers[token], ch)
+		close(ch)
+	}
+	if len(s.subscribers[token]) == 0 {
+		delete(s.subscribers, token)
+	}
+}
+
+func (s *SessionStore) closeSubscribersLocked(token string) {
+	for ch := range s.subscribers[token] {
+		close(ch)
+	}
+	delete(s.subscribers, token)
+}
+
+// A SESSION EXPIRES AFTER ttl WITHOUT REQUESTS OR WHEN THE TOKEN OF ITS LOGIN DOES, WHATEVER COMES FIRST. RENEWING
+// IT ON EVERY REQUEST MUST NOT KEEP A TOKEN THE WORKERS WOULD REJECT, NOR ONE THAT WAS NEVER SIGNED WITH auth.secret
+func (s *SessionStore) expired(session *WebSession) bool {
+	if s.ttl > 0 && time.Since(session.LastSeen) > s.ttl {
+		return true
+	}
+	_, err := VerifyToken(session.AuthToken)
+	return err != nil
+}
+
+// WRITES EVERY LIVE SESSION TO THE STORE FILE (THROUGH A TEMPORAL FILE SO A CRASH NEVER LEAVES IT HALF WRITTEN)
+func (s *SessionStore) saveLocked() {
+	if s.path == "" {
+		return
+	}
+	sessions := make([]*WebSession, 0, len(s.sessions))
+	for token, session := range s.sessions {
+		if s.expired(session) {
+			delete(s.sessions, token)
+			s.closeSubscribersLocked(token)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	b, err := json.Marshal(sessions)
+	if err != nil {
+		log.Errorf("Could not encode sessions: %v", err)
+		return
+	}
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0600)
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		log.Errorf("Could not save sessions to %s: %v", s.path, err)
+	}
+}
+
+// SETS THE SESSION COOKIE. IT IS NOT READABLE FROM JAVASCRIPT AND ONLY SENT OVER HTTPS IF sessions.securecookie IS SET
+func (s *SessionStore) SetCookie(w http.ResponseWriter, session *WebSession) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session.Token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *SessionStore) ClearCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// RETURNS THE SESSION OF A REQUEST, FOUND THROUGH ITS COOKIE OR, FOR API CALLS, ITS "Authorization: Bearer" HEADER
+func (s *SessionStore) FromRequest(r *http.Request) (WebSession, bool) {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return s.Get(strings.TrimPrefix(header, "Bearer "))
+	}
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return WebSession{}, false
+	}
+	return s.Get(cookie.Value)
+}
+
+// BUILDS THE TEMPLATE DATA OF A HANDLER FROM THE SESSION OF THE REQUEST. WITHOUT A VALID SESSION THE BROWSER IS
+// SENT BACK TO THE LOGIN PAGE AND FALSE IS RETURNED
+func SessionClientData(w http.ResponseWriter, r *http.Request, cmd int) (ClientStruct, WebSession, bool) {
+	session, ok := Sessions.FromRequest(r)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return ClientStruct{}, session, false
+	}
+	ClientData := ClientStruct{
+		Client:           session.Client,
+		Cmd:              cmd,
+		SessID:           session.SessID,
+		AuthToken:        session.AuthToken,
+		CSRFToken:        session.CSRFToken,
+		EchoConversation: TranscriptText(session.Transcript),
+		EchoSeq:          session.LastSeq,
+	}
+	ClientData.IsAdmin = IsAdmin(ClientData)
+	return ClientData, session, true
+}
+
+// CLOSES THE SESSION OF THE REQUEST AND GOES BACK TO THE LOGIN PAGE. ONLY POST IS ACCEPTED, SO THE CSRF TOKEN IS
+// CHECKED: A LINK OR AN IMAGE ON ANOTHER SITE MUST NOT LOG THE USER OUT
+func logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if session, ok := Sessions.FromRequest(r); ok {
+		Sessions.Delete(session.Token)
+		Replies.RemoveSession(session.SessID)
+	}
+	Sessions.ClearCookie(w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionStoreTranscript(t *testing.T) {
+	store := NewSessionStore(time.Hour, "", 2, false)
+	authToken := testToken(TokenClaims{User: "alice"})
+	session, err := store.Create("alice", "sess01", authToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.SessID != "sess01" || session.AuthToken != authToken || session.Token == "" {
+		t.Fatalf("Create() = %+v", session)
+	}
+	store.AppendTranscript(session.Token, "one", "one")
+	store.AppendTranscript(session.Token, "two", "two")
+	entry, _ := store.AppendTranscript(session.Token, "three", "three")
+	got, _ := store.Get(session.Token)
+	if entry.Seq != 3 || entry.Client != "alice" || TranscriptText(got.Transcript) != "alice:\ttwo\nEcho:\ttwo\n\nalice:\tthree\nEcho:\tthree\n\n" {
+		t.Errorf("transcript = %q, want only the last 2 entries", TranscriptText(got.Transcript))
+	}
+	if _, ok := store.AppendTranscript("nosuchtoken", "four", "four"); ok {
+		t.Errorf("entry appended to an unknown session")
+	}
+	store.ClearTranscript(session.Token)
+	if got, _ := store.Get(session.Token); len(got.Transcript) != 0 {
+		t.Errorf("transcript after clearing = %v", got.Transcript)
+	}
+}
+
+func TestSessionStoreExpiryAndPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	store := NewSessionStore(time.Hour, path, 10, false)
+	alive, _ := store.Create("alice", "sess01", testToken(TokenClaims{User: "alice"}))
+	idle, _ := store.Create("bob", "sess02", testToken(TokenClaims{User: "bob"}))
+	store.AppendTranscript(alive.Token, "hello", "hello")
+
+	store.sessions[idle.Token].LastSeen = time.Now().Add(-2 * time.Hour)
+	if _, ok := store.Get(idle.Token); ok {
+		t.Errorf("idle session returned after its TTL")
+	}
+
+	// A restarted web client gets the live sessions back
+	restarted := NewSessionStore(time.Hour, path, 10, false)
+	if err := restarted.Load(); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := restarted.Get(alive.Token)
+	if !ok || got.Client != "alice" || got.SessID != alive.SessID || len(got.Transcript) != 1 {
+		t.Errorf("loaded session = %+v %v", got, ok)
+	}
+	if _, ok := restarted.Get(idle.Token); ok {
+		t.Errorf("expired session loaded")
+	}
+
+	if err := NewSessionStore(time.Hour, filepath.Join(t.TempDir(), "missing.json"), 10, false).Load(); err != nil {
+		t.Errorf("Load() of a missing file = %v", err)
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	Sessions = NewSessionStore(time.Hour, "", 10, true)
+	Replies = NewReplyRouter(time.Minute, time.Second)
+	Replies.AddSession("sess01")
+	session, _ := Sessions.Create("alice", "sess01", testToken(TokenClaims{User: "alice"}))
+	w := httptest.NewRecorder()
+	Sessions.SetCookie(w, session)
+	cookie := w.Result().Cookies()[0]
+	if cookie.Name != sessionCookie || !cookie.HttpOnly || !cookie.Secure {
+		t.Errorf("session cookie = %+v", cookie)
+	}
+
+	r := httptest.NewRequest("GET", "/echo", nil)
+	r.AddCookie(cookie)
+	if got, ok := Sessions.FromRequest(r); !ok || got.Client != "alice" {
+		t.Errorf("FromRequest() = %+v %v", got, ok)
+	}
+
+	// Another site can make the browser follow a link or post a form to /logout, but without the CSRF token
+	tpl = template.Must(LoadTemplates())
+	for method, want := range map[string]int{http.MethodGet: http.StatusMethodNotAllowed, http.MethodPost: http.StatusForbidden} {
+		forged := httptest.NewRequest(method, "/logout", nil)
+		forged.AddCookie(cookie)
+		w = httptest.NewRecorder()
+		CSRFProtect(http.HandlerFunc(logout)).ServeHTTP(w, forged)
+		if w.Code != want {
+			t.Errorf("%s /logout without the CSRF token status = %d, want %d", method, w.Code, want)
+		}
+		if _, ok := Sessions.FromRequest(r); !ok {
+			t.Fatalf("%s /logout without the CSRF token closed the session", method)
+		}
+	}
+
+	form := url.Values{csrfField: {session.CSRFToken}}
+	logoutRequest := httptest.NewRequest(http.MethodPost, "/logout", strings.NewReader(form.Encode()))
+	logoutRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	logoutRequest.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	CSRFProtect(http.HandlerFunc(logout)).ServeHTTP(w, logoutRequest)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("logout status = %d", w.Code)
+	}
+	if _, ok := Sessions.FromRequest(r); ok || Replies.Local("sess01") {
+		t.Errorf("session still open after logout")
+	}
+	w = httptest.NewRecorder()
+	if _, _, ok := SessionClientData(w, r, 1); ok || w.Header().Get("Location") != "/" {
+		t.Errorf("request without a session not sent to the login page")
+	}
+}
+
+func TestTranscriptSince(t *testing.T) {
+	store := NewSessionStore(time.Hour, "", 3, false)
+	session, err := store.Create("alice", "sess01", testToken(TokenClaims{User: "alice"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range []string{"one", "two", "three", "four", "five"} {
+		store.AppendTranscript(session.Token, message, message)
+	}
+	// Only the last 3 entries (3 to 5) are kept, LastSeq is 5
+
+	tests := []struct {
+		name      string
+		since     int64
+		wantSeqs  []int64
+		wantReset bool
+	}{
+		{"up to date", 5, nil, false},
+		{"missed the last two", 3, []int64{4, 5}, false},
+		{"missed everything kept", 2, []int64{3, 4, 5}, false},
+		{"missed trimmed entries", 1, []int64{3, 4, 5}, true},
+		{"new browser", 0, []int64{3, 4, 5}, true},
+		{"unknown sequence", 9, []int64{3, 4, 5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, lastSeq, reset, ok := store.TranscriptSince(session.Token, tt.since)
+			var seqs []int64
+			for _, entry := range entries {
+				seqs = append(seqs, entry.Seq)
+			}
+			if !ok || lastSeq != 5 || reset != tt.wantReset || !reflect.DeepEqual(seqs, tt.wantSeqs) {
+				t.Errorf("TranscriptSince(%d) = %v, %d, %v, %v, want %v, 5, %v, true", tt.since, seqs, lastSeq, reset, ok, tt.wantSeqs, tt.wantReset)
+			}
+		})
+	}
+
+	t.Run("after clearing", func(t *testing.T) {
+		store.ClearTranscript(session.Token)
+		if entries, lastSeq, reset, _ := store.TranscriptSince(session.Token, 5); len(entries) != 0 || lastSeq != 6 || !reset {
+			t.Errorf("TranscriptSince(5) = %v, %d, %v, want no entries, 6, reset", entries, lastSeq, reset)
+		}
+		if entries, _, reset, _ := store.TranscriptSince(session.Token, 6); len(entries) != 0 || reset {
+			t.Errorf("TranscriptSince(6) = %v, %v, want no entries and no reset", entries, reset)
+		}
+	})
+
+	t.Run("unknown session", func(t *testing.T) {
+		if _, _, _, ok := store.TranscriptSince("nosuchtoken", 0); ok {
+			t.Errorf("TranscriptSince of an unknown session returned ok")
+		}
+	})
+}
+
+func TestTranscriptSubscribers(t *testing.T) {
+	store := NewSessionStore(time.Hour, "", 10, false)
+	session, _ := store.Create("alice", "sess01", testToken(TokenClaims{User: "alice"}))
+	if _, ok := store.Subscribe("nosuchtoken"); ok {
+		t.Errorf("subscribed to an unknown session")
+	}
+	live, _ := store.Subscribe(session.Token)
+	left, _ := store.Subscribe(session.Token)
+	store.Unsubscribe(session.Token, left)
+
+	store.AppendTranscript(session.Token, "hi", "hi")
+	if entry := <-live; entry.Seq != 1 || entry.Message != "hi" {
+		t.Errorf("pushed entry = %+v", entry)
+	}
+	if _, open := <-left; open {
+		t.Errorf("entry pushed to an unsubscribed connection")
+	}
+
+	// Clearing the transcript makes the connections start again
+	store.ClearTranscript(session.Token)
+	if _, open := <-live; open {
+		t.Errorf("connection kept open after clearing the transcript")
+	}
+}
+
+func TestSessionTokenExpiry(t *testing.T) {
+	tests := []struct {
+		name      string
+		authToken string
+		lastSeen  time.Duration
+		wantLive  bool
+	}{
+		{"live", testToken(TokenClaims{User: "alice"}), 0, true},
+		{"idle for too long", testToken(TokenClaims{User: "alice"}), 2 * time.Hour, false},
+		{"token expired", expiredToken("alice"), 0, false},
+		{"malformed token", "garbage", 0, false},
+		{"forged token", forgedToken("alice"), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewSessionStore(time.Hour, "", 0, false)
+			session, err := store.Create("alice", "sess01", tt.authToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			session.LastSeen = time.Now().Add(-tt.lastSeen)
+			if _, ok := store.Get(session.Token); ok != tt.wantLive {
+				t.Errorf("Get() live = %v, want %v", ok, tt.wantLive)
+			}
+		})
+	}
+}
+
+func TestSessionSetAuthToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	store := NewSessionStore(time.Hour, path, 0, false)
+	session, _ := store.Create("alice", "sess01", testToken(TokenClaims{User: "alice"}))
+	renewed := testToken(TokenClaims{User: "alice", Roles: []string{"admin"}})
+	store.SetAuthToken(session.Token, renewed)
+	store.SetAuthToken("missing", renewed)
+
+	restarted := NewSessionStore(time.Hour, path, 0, false)
+	if err := restarted.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := restarted.Get(session.Token); got.AuthToken != renewed {
+		t.Errorf("renewed token not saved, got %q", got.AuthToken)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// THE _shared.go FILES MUST BE EXACTLY WHAT go generate MAKES OF ../shared. EDITING A COPY, OR A SHARED FILE WITHOUT
+// RUNNING go generate, FAILS HERE
+func TestSharedCopies(t *testing.T) {
+	b, err := ioutil.ReadFile("generate.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	directive := regexp.MustCompile(`(?m)^//go:generate sh \.\./shared/copy\.sh (.+)$`).FindStringSubmatch(string(b))
+	if directive == nil {
+		t.Fatal("generate.go does not run shared/copy.sh")
+	}
+	sources := strings.Fields(directive[1])
+	copies, _ := filepath.Glob("*_shared.go")
+	if len(copies) != len(sources) {
+		t.Errorf("%d _shared.go files but generate.go copies %v", len(copies), sources)
+	}
+	for _, source := range sources {
+		shared, err := ioutil.ReadFile(filepath.Join("..", "shared", source))
+		if err != nil {
+			t.Errorf("Could not read the shared file: %v", err)
+			continue
+		}
+		// copy.sh leaves out the //go:build ignore line and the blank line after it
+		want := "// Code generated by shared/copy.sh from shared/" + source + ". DO NOT EDIT.\n\n" + strings.SplitAfterN(string(shared), "\n", 3)[2]
+		name := strings.TrimSuffix(source, ".go") + "_shared.go"
+		got, err := ioutil.ReadFile(name)
+		if err != nil || string(got) != want {
+			t.Errorf("%s is not the copy of ../shared/%s, run go generate", name, source)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// LIVE VIEW: ECHOES ARRIVE THROUGH /echo/events AND MESSAGES ARE SENT WITH /echo/send. THE BROWSER
+// RECONNECTS BY ITSELF SENDING THE LAST EVENT ID, SO MISSED ECHOES ARE REPLAYED. WITHOUT EventSource
+// THE FORM IS POSTED AS BEFORE
+(function() {
+    if (window.EventSource && window.fetch) {
+        var conversation = document.getElementById("echoconversation");
+        var scroll = document.getElementById("echoscroll");
+        var status = document.getElementById("echostatus");
+        var errorBox = document.getElementById("echoerror");
+        var form = document.getElementById("echoform");
+        var lastSeq = parseInt(conversation.getAttribute("data-seq"), 10) || 0;
+        var csrfToken = document.querySelector('meta[name="csrf-token"]').getAttribute("content");
+
+        var showError = function(text) {
+            errorBox.textContent = text;
+            errorBox.style.display = text ? "" : "none";
+        };
+
+        var events = new EventSource("/echo/events?since=" + lastSeq);
+        events.addEventListener("open", function() {
+            status.textContent = "Live";
+        });
+        events.addEventListener("error", function() {
+            status.textContent = "Reconnecting...";
+        });
+        events.addEventListener("reset", function(e) {
+            conversation.textContent = "";
+            lastSeq = parseInt(e.lastEventId, 10) || 0;
+        });
+        events.addEventListener("echo", function(e) {
+            var entry = JSON.parse(e.data);
+            if (entry.seq <= lastSeq) {
+                return;
+            }
+            lastSeq = entry.seq;
+            conversation.textContent += entry.client + ":\t" + entry.message + "\nEcho:\t" + entry.echo + "\n\n";
+            scroll.scrollTop = scroll.scrollHeight;
+        });
+
+        form.addEventListener("submit", function(e) {
+            e.preventDefault();
+            var input = form.elements["msgsent"];
+            var text = input.value;
+            input.value = "";
+            showError("");
+            fetch("/echo/send", {
+                method: "POST",
+                credentials: "same-origin",
+                headers: {"Content-Type": "application/x-www-form-urlencoded", "X-CSRF-Token": csrfToken},
+                body: "msgsent=" + encodeURIComponent(text)
+            }).then(function(resp) {
+                return resp.json().then(function(body) {
+                    if (!resp.ok) {
+                        showError(body.error ? body.error.message : resp.statusText);
+                    } else if (text === "END") {
+                        window.location = "/menu";
+                    }
+                });
+            }).catch(function() {
+                showError("Could not reach the web client, try again");
+            });
+        });
+    }
+})();
@@ -0,0 +1,2 @@
+// RESUBMITS THE FORM WITH id "poll" EVERY 2 SECONDS WHILE A SEARCH JOB IS RUNNING
+setTimeout(function() { document.getElementById("poll").submit(); }, 2000);
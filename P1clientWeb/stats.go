@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/sirupsen/logrus"
+)
+
+// STATISTICS OF THE CONVERSATION OF ONE USER AS SENT BY THE SEARCH APP. Percent FIELDS ARE FILLED HERE TO DRAW THE BARS
+type StatsStruct struct {
+	User           string           `json:"user"`
+	Messages       int              `json:"messages"`
+	Sessions       int              `json:"sessions"`
+	FirstActivity  string           `json:"firstActivity"`
+	LastActivity   string           `json:"lastActivity"`
+	MessagesPerDay []StatsBarStruct `json:"messagesPerDay"`
+	TopWords       []StatsBarStruct `json:"topWords"`
+}
+
+type StatsBarStruct struct {
+	Day      string `json:"day,omitempty"`
+	Word     string `json:"word,omitempty"`
+	Messages int    `json:"messages,omitempty"`
+	Count    int    `json:"count,omitempty"`
+	Percent  int    `json:"-"`
+}
+
+func stats(w http.ResponseWriter, r *http.Request) {
+	ClientData, _, ok := SessionClientData(w, r, 4)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if r.Method == http.MethodPost {
+		ClientData.StatsUser = r.FormValue("statsuser")
+		if !CanAccess(ClientData, ClientData.StatsUser) {
+			RenderForbidden(w, ClientData, ClientData.StatsUser, "stats.gohtml")
+			return
+		}
+
+		timestamp := time.Now().Format("02-Jan-2006 15:04:05")
+
+		msg := &sqs.SendMessageInput{
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				"clientName": &sqs.MessageAttributeValue{
+					DataType:    aws.String("String"),
+					StringValue: aws.String(ClientData.StatsUser),
+				},
+				"sessionID": &sqs.MessageAttributeValue{
+					DataType:    aws.String("String"),
+					StringValue: aws.String(ClientData.SessID),
+				},
+				"timestamp": &sqs.MessageAttributeValue{
+					DataType:    aws.String("String"),
+					StringValue: aws.String(timestamp),
+				},
+				"cmd": &sqs.MessageAttributeValue{
+					DataType:    aws.String("String"),
+					StringValue: aws.String(fmt.Sprintf("%d", ClientData.Cmd)),
+				},
+				"token": &sqs.MessageAttributeValue{
+					DataType:    aws.String("String"),
+					StringValue: aws.String(ClientData.AuthToken),
+				},
+			},
+			MessageBody: aws.String("STATS"),
+			QueueUrl:    &inboxURL,
+		}
+
+		if rejected, ok := LocalRateLimit(ClientData, "stats"); !ok {
+			ClientData.Error = rejected.Error
+		} else {
+			reply := Replies.Expect(ClientData.SessID, 4)
+			defer Replies.Cancel(reply)
+			log.Infof("Sending stats command to AWS search app. USER: %s", ClientData.StatsUser)
+			result, err := sqssvc.SendMessage(msg)
+			if err != nil {
+				log.Errorf("Could not send message to SQS queue: %v", err)
+				ReplyError(w, r, ClientData, &SendError{Err: err})
+				return
+			} else {
+				log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+				msgrx, err := Replies.Wait(r.Context(), reply)
+				if err != nil {
+					ReplyError(w, r, ClientData, err)
+					return
+				}
+				ClientData.Error = msgrx.Error
+				if ClientData.Error == "" {
+					ClientData.Stats, err = ParseStats(msgrx.Body)
+				}
+				if err != nil {
+					log.Errorf("Could not read statistics: %v", err)
+				}
+			}
+		}
+	}
+
+	err := tpl.ExecuteTemplate(w, "stats.gohtml", ClientData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// DECODES THE STATISTICS SENT BY THE SEARCH APP AND SCALES EVERY BAR TO THE BIGGEST ONE OF ITS CHART
+func ParseStats(body string) (*StatsStruct, error) {
+	var stats StatsStruct
+	err := json.Unmarshal([]byte(body), &stats)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed statistics: %v", err)
+	}
+	maxDay := 0
+	for _, day := range stats.MessagesPerDay {
+		maxDay = max(maxDay, day.Messages)
+	}
+	for i := range stats.MessagesPerDay {
+		stats.MessagesPerDay[i].Percent = stats.MessagesPerDay[i].Messages * 100 / maxDay
+	}
+	maxWord := 0
+	for _, word := range stats.TopWords {
+		maxWord = max(maxWord, word.Count)
+	}
+	for i := range stats.TopWords {
+		stats.TopWords[i].Percent = stats.TopWords[i].Count * 100 / maxWord
+	}
+	return &stats, nil
+}
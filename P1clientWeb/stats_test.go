@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestParseStats(t *testing.T) {
+	body := `{"user":"alice","messages":6,"sessions":2,"messagesPerDay":[{"day":"2024-03-09","messages":2},{"day":"2024-03-10","messages":4}],"topWords":[{"word":"deploy","count":3},{"word":"build","count":1}]}`
+	stats, err := ParseStats(body)
+	if err != nil {
+		t.Fatalf("ParseStats() error = %v", err)
+	}
+	if stats.User != "alice" || stats.Messages != 6 || stats.Sessions != 2 {
+		t.Errorf("ParseStats() = %+v", stats)
+	}
+	// Bars are relative to the longest one
+	if stats.MessagesPerDay[0].Percent != 50 || stats.MessagesPerDay[1].Percent != 100 {
+		t.Errorf("day bars = %d%% %d%%, want 50%% 100%%", stats.MessagesPerDay[0].Percent, stats.MessagesPerDay[1].Percent)
+	}
+	if stats.TopWords[0].Percent != 100 || stats.TopWords[1].Percent != 33 {
+		t.Errorf("word bars = %d%% %d%%, want 100%% 33%%", stats.TopWords[0].Percent, stats.TopWords[1].Percent)
+	}
+
+	if _, err := ParseStats("not json"); err == nil {
+		t.Errorf("ParseStats accepted a malformed body")
+	}
+}
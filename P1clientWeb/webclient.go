@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -14,33 +18,88 @@ import (
 
 	aws "github.com/aws/aws-sdk-go/aws"
 	session "github.com/aws/aws-sdk-go/aws/session"
-	s3 "github.com/aws/aws-sdk-go/service/s3"
-	s3manager "github.com/aws/aws-sdk-go/service/s3/s3manager"
 	sqs "github.com/aws/aws-sdk-go/service/sqs"
 	log "github.com/sirupsen/logrus"
 	viper "github.com/theherk/viper"
 )
 
 type SearchStruct struct {
-	ClientSearch string
-	Keysentence  string
-	SearchResult string
+	ClientSearch  string
+	Keysentence   string
+	Before        int
+	After         int
+	Fuzzy         bool
+	Similar       bool
+	MaxDistance   int
+	Cursor        string
+	JobID         string
+	Refine        string
+	Error         string
+	NextCursor    string
+	PrevCursor    string
+	SearchResult  string
+	SearchResults []SearchResultStruct
+	TotalMatches  string
+	RetryAfter    int // Seconds to wait when the search was rate limited
+}
+
+// ONE RANKED SEARCH HIT (OR CONTEXT LINE), SPLIT IN SEGMENTS SO THE TEMPLATE CAN WRAP THE MATCHING ONES IN <mark>.
+// NewGroup MARKS THE FIRST LINE OF A CONTEXT GROUP THAT FOLLOWS ANOTHER ONE
+type SearchResultStruct struct {
+	Timestamp string
+	Score     string
+	Segments  []TextSegment
+	Variants  string
+	Context   bool
+	NewGroup  bool
+	Session   string
+	Line      int
+}
+
+type TextSegment struct {
+	Text  string
+	Match bool
 }
 
 type RXMsgStruct struct {
-	RXMSG  *sqs.ReceiveMessageOutput
-	Body   string
-	SessID string
+	Body         string
+	SessID       string
+	Results      []SearchResultStruct
+	TotalMatches string
+	NextCursor   string
+	PrevCursor   string
+	JobID        string
+	Error        string
+	Token        string
+	RetryAfter   int
+}
+
+// DATA OF A "NEXT/PREVIOUS PAGE" BUTTON OF THE SEARCH RESULTS
+type PageLinkStruct struct {
+	Data   ClientStruct
+	Cursor string
+	Label  string
 }
 
 type ClientStruct struct {
 	Client           string
 	Cmd              int
 	EchoConversation string
+	EchoSeq          int64
 	SearchData       SearchStruct
 	DownloadUser     string
-	DownloadFile     string
+	StatsUser        string
+	Stats            *StatsStruct
+	SavedSearches    []SavedSearchStruct
+	SearchJob        *JobStatusStruct
+	Conversation     *ConversationViewStruct
+	Admin            *AdminViewStruct
+	IsAdmin          bool
+	Error            string
+	Notifications    []NotificationStruct
 	SessID           string
+	AuthToken        string
+	CSRFToken        string
 }
 
 var clientSearch, keysentence string
@@ -48,9 +107,6 @@ var tpl *template.Template
 
 var SearchData SearchStruct
 
-var ReceivedEcho = make(chan RXMsgStruct, 1)
-var SearchDone = make(chan RXMsgStruct, 1)
-
 var clientName, logFile, verboseLevel, inboxURL, outboxURL string
 var stdoutEnabled, fileoutEnabled bool
 var cfgFile string = "config/config.toml"
@@ -65,30 +121,85 @@ func main() {
 	initConfig()
 
 	go ReceiveMSGS()
+	StartHealthServer(viper.GetString("health.listen")) // Serve /healthz, /readyz and /metrics, away from the users
 
-	tpl = template.Must(template.ParseGlob("templates/*.gohtml"))
+	tpl = template.Must(LoadTemplates())
 
 	http.HandleFunc("/", root)
 	http.HandleFunc("/menu", menu)
 	http.HandleFunc("/echo", echo)
+	http.HandleFunc("/echo/send", echosend)
+	http.HandleFunc("/echo/events", echoevents)
 	http.HandleFunc("/search", search)
+	http.HandleFunc("/searchjob", searchjob)
 	http.HandleFunc("/download", download)
+	http.HandleFunc("/download/", downloadfile)
+	http.HandleFunc("/conversation", conversation)
+	http.HandleFunc("/conversation/", conversation)
+	http.HandleFunc("/stats", stats)
+	http.HandleFunc("/savedsearches", savedsearches)
+	http.HandleFunc("/notifications", notifications)
+	http.HandleFunc("/logout", logout)
+	http.HandleFunc("/admin", admin)
+	http.HandleFunc("/static/", staticfile)
+	http.HandleFunc("/api/openapi.json", apiOpenAPI)
+	http.HandleFunc("/api/v1/sessions", apiSessions)
+	http.HandleFunc("/api/v1/echo", apiEcho)
+	http.HandleFunc("/api/v1/search", apiSearch)
+	http.HandleFunc("/api/v1/search/", apiSearchJob)
+	http.HandleFunc("/api/v1/conversations/", apiConversation)
 
-	http.ListenAndServe(":8080", nil)
+	srv, err := NewServer(webConfig, CSRFProtect(http.DefaultServeMux))
+	if err != nil {
+		log.Fatalf("Could not start web server: %v", err)
+	}
+	if err := ListenAndServe(srv); err != nil {
+		log.Fatalf("Web server stopped: %v", err)
+	}
 }
 
 func root(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 	ClientData := *new(ClientStruct)
-	ClientData.SessID = StringWithCharset(6)
 
 	if r.Method == http.MethodPost {
 		ClientData.Client = r.FormValue("client")
-		err := tpl.ExecuteTemplate(w, "menu.gohtml", ClientData)
+		session, err := Login(r.Context(), ClientData.Client, r.FormValue("password"), RemoteHost(r))
+		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			ClientData.Error = err.Error()
+			ClientData.CSRFToken = RequestCSRFToken(w, r)
+			status := http.StatusUnauthorized
+			var limited *RateLimitError
+			var unsent *SendError
+			if errors.As(err, &limited) {
+				w.Header().Set("Retry-After", strconv.Itoa(limited.RetryAfter))
+				status = http.StatusTooManyRequests
+			} else if errors.As(err, &unsent) {
+				status = http.StatusBadGateway
+				ClientData.Error = "The login could not be passed to the echo app, try again later."
+			} else if errors.Is(err, ErrReplyTimeout) {
+				status = http.StatusGatewayTimeout
+			}
+			w.WriteHeader(status)
+			err = tpl.ExecuteTemplate(w, "root.gohtml", ClientData)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		ClientData.SessID = session.SessID
+		ClientData.CSRFToken = session.CSRFToken
+		Sessions.SetCookie(w, session)
+		ClientData.Notifications = Notifications.List(ClientData.Client)
+		err = tpl.ExecuteTemplate(w, "menu.gohtml", ClientData)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	} else {
+		ClientData.CSRFToken = RequestCSRFToken(w, r)
 		err := tpl.ExecuteTemplate(w, "root.gohtml", ClientData)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -97,12 +208,152 @@ func root(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// LOGS client IN WITH THE ECHO APP AND OPENS ITS WEB SESSION, REGISTERED IN THE REPLY ROUTER AND THE NOTIFICATIONS.
+// source IS THE ADDRESS THE LOGIN CAME FROM, ITS ATTEMPTS ARE LIMITED APART FROM THOSE OF OTHER ADDRESSES
+func Login(ctx context.Context, client string, password string, source string) (*WebSession, error) {
+	ClientData := ClientStruct{Client: client, SessID: StringWithCharset(6)}
+	Replies.AddSession(ClientData.SessID)
+	token, err := RequestToken(ctx, ClientData, password, source)
+	if err != nil {
+		Replies.RemoveSession(ClientData.SessID)
+		return nil, err
+	}
+	session, err := Sessions.Create(ClientData.Client, ClientData.SessID, token)
+	if err != nil {
+		Replies.RemoveSession(ClientData.SessID)
+		log.Errorf("Could not open web session: %v", err)
+		return nil, fmt.Errorf("Could not open session")
+	}
+	Notifications.AddUser(session.Client)
+	return session, nil
+}
+
+// ADDRESS OF THE CLIENT OF A REQUEST WITHOUT ITS PORT
+func RemoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SENDS THE USER NAME AND PASSWORD OF THE LOGIN FORM TO THE ECHO APP (COMMAND 8) AND RETURNS THE TOKEN IT ISSUED
+func RequestToken(ctx context.Context, ClientData ClientStruct, password string, source string) (string, error) {
+	timestamp := time.Now().Format("02-Jan-2006 15:04:05")
+	msg := &sqs.SendMessageInput{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"clientName": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.Client),
+			},
+			"sessionID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.SessID),
+			},
+			"timestamp": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(timestamp),
+			},
+			"cmd": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String("8"),
+			},
+		},
+		MessageBody: aws.String(password),
+		QueueUrl:    &inboxURL,
+	}
+
+	// Every login opens a new session, the attempts are counted by user and address
+	if allowed, wait := Limits.Allow(LoginKey(ClientData.Client, source), "login"); !allowed {
+		log.Warnf("Rate limit of login reached by %s from %s", ClientData.Client, source)
+		return "", &RateLimitError{Message: RateLimitMessage("login", wait), RetryAfter: RetrySeconds(wait)}
+	}
+	reply := Replies.Expect(ClientData.SessID, 8)
+	defer Replies.Cancel(reply)
+	log.Infof("Sending login of %s to AWS echo app", ClientData.Client)
+	result, err := sqssvc.SendMessage(msg)
+	if err != nil {
+		log.Errorf("Could not send message to SQS queue: %v", err)
+		return "", &SendError{Err: err}
+	}
+	log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+	msgrx, err := Replies.Wait(ctx, reply)
+	if err == ErrReplyTimeout {
+		return "", fmt.Errorf("%w, try again later", ErrReplyTimeout)
+	} else if err != nil {
+		return "", err
+	}
+	if msgrx.Body == RateLimitedBody {
+		return "", &RateLimitError{Message: msgrx.Error, RetryAfter: msgrx.RetryAfter}
+	}
+	if msgrx.Error != "" || msgrx.Token == "" {
+		return "", fmt.Errorf("Wrong user name or password")
+	}
+	return msgrx.Token, nil
+}
+
+// THE ECHO APP NO LONGER ACCEPTS THE TOKEN OF A SESSION: IT EXPIRED, WAS FORGED OR ITS ACCOUNT WAS REMOVED
+var ErrTokenRejected = errors.New("The echo app rejected the token")
+
+// ASKS THE ECHO APP FOR A NEW TOKEN WITH THE CURRENT ROLES AND SHARES OF THE USER OF ClientData (COMMAND 8 WITH
+// ITS TOKEN INSTEAD OF A PASSWORD). FAILS IF THE ECHO APP NO LONGER ACCEPTS THE TOKEN
+func RenewToken(ctx context.Context, ClientData ClientStruct) (string, error) {
+	timestamp := time.Now().Format("02-Jan-2006 15:04:05")
+	msg := &sqs.SendMessageInput{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"clientName": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.Client),
+			},
+			"sessionID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.SessID),
+			},
+			"timestamp": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(timestamp),
+			},
+			"cmd": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String("8"),
+			},
+			"token": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.AuthToken),
+			},
+		},
+		MessageBody: aws.String("RENEW"),
+		QueueUrl:    &inboxURL,
+	}
+
+	reply := Replies.Expect(ClientData.SessID, 8)
+	defer Replies.Cancel(reply)
+	log.Infof("Sending token renewal of %s to AWS echo app", ClientData.Client)
+	result, err := sqssvc.SendMessage(msg)
+	if err != nil {
+		log.Errorf("Could not send message to SQS queue: %v", err)
+		return "", &SendError{Err: err}
+	}
+	log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+	msgrx, err := Replies.Wait(ctx, reply)
+	if err != nil {
+		return "", err
+	}
+	if msgrx.Body == "UNAUTHORIZED" {
+		return "", fmt.Errorf("%w: %s", ErrTokenRejected, msgrx.Error)
+	} else if msgrx.Error != "" || msgrx.Token == "" {
+		return "", fmt.Errorf("Could not renew the token: %s", msgrx.Error)
+	}
+	return msgrx.Token, nil
+}
+
 func menu(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
-	ClientData := ClientStruct{
-		Client: r.FormValue("client"),
-		SessID: r.FormValue("sessid"),
+	ClientData, _, ok := SessionClientData(w, r, 0)
+	if !ok {
+		return
 	}
+	ClientData.Notifications = Notifications.List(ClientData.Client)
 	if r.Method == http.MethodPost {
 		ClientData.Cmd, _ = strconv.Atoi(r.FormValue("cmd"))
 		if ClientData.Cmd == 1 { //ECHO
@@ -120,6 +371,17 @@ func menu(w http.ResponseWriter, r *http.Request) {
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
+		} else if ClientData.Cmd == 4 { // STATS
+			err := tpl.ExecuteTemplate(w, "stats.gohtml", ClientData)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		} else if ClientData.Cmd == 5 { // SAVED SEARCHES
+			savedsearches(w, r)
+		} else if ClientData.Cmd == 9 { // CONVERSATION VIEWER (READS S3 DIRECTLY, NO WORKER COMMAND)
+			http.Redirect(w, r, "/conversation", http.StatusSeeOther)
+		} else if ClientData.Cmd == 10 && ClientData.IsAdmin { // ADMIN DASHBOARD
+			http.Redirect(w, r, "/admin", http.StatusSeeOther)
 		} else {
 			err := tpl.ExecuteTemplate(w, "menu.gohtml", ClientData)
 			if err != nil {
@@ -136,76 +398,34 @@ func menu(w http.ResponseWriter, r *http.Request) {
 }
 
 func echo(w http.ResponseWriter, r *http.Request) {
-	var echomsg RXMsgStruct
-	cmdint, _ := strconv.Atoi(r.FormValue("cmd"))
-	ClientData := ClientStruct{
-		Client:           r.FormValue("client"),
-		Cmd:              cmdint,
-		SessID:           r.FormValue("sessid"),
-		EchoConversation: r.FormValue("echoconversation"),
+	ClientData, session, ok := SessionClientData(w, r, 1)
+	if !ok {
+		return
 	}
 	w.Header().Set("Content-Type", "text/html")
 	if r.Method == http.MethodPost {
-		timestamp := time.Now().Format("02-Jan-2006 15:04:05")
-
 		text := r.FormValue("msgsent")
-		msg := &sqs.SendMessageInput{
-			MessageAttributes: map[string]*sqs.MessageAttributeValue{
-				"clientName": &sqs.MessageAttributeValue{
-					DataType:    aws.String("String"),
-					StringValue: aws.String(ClientData.Client),
-				},
-				"sessionID": &sqs.MessageAttributeValue{
-					DataType:    aws.String("String"),
-					StringValue: aws.String(ClientData.SessID),
-				},
-				"timestamp": &sqs.MessageAttributeValue{
-					DataType:    aws.String("String"),
-					StringValue: aws.String(timestamp),
-				},
-				"cmd": &sqs.MessageAttributeValue{
-					DataType:    aws.String("String"),
-					StringValue: aws.String(fmt.Sprintf("%d", ClientData.Cmd)),
-				},
-			},
-			MessageBody: aws.String(text),
-			QueueUrl:    &inboxURL,
-		}
-
-		log.Infof("Sending message to AWS echo app. MESSAGE: %s", text)
-		result, err := sqssvc.SendMessage(msg)
+		echomsg, err := SendEcho(r.Context(), ClientData, text)
 		if err != nil {
-			log.Errorf("Could not send message to SQS queue: %v", err)
-		} else {
-			log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
-			if text == "END" {
-				err := tpl.ExecuteTemplate(w, "menu.gohtml", nil)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-				}
-				return
-			} else {
-				for {
-					echomsg = <-ReceivedEcho
-					if ClientData.SessID != echomsg.SessID {
-						sqssvc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
-							QueueUrl:          &outboxURL,
-							ReceiptHandle:     echomsg.RXMSG.Messages[0].ReceiptHandle,
-							VisibilityTimeout: aws.Int64(0),
-						})
-						continue
-					} else {
-						ClientData.EchoConversation = ClientData.EchoConversation + ClientData.Client + ":\t" + text + "\nEcho:\t" + echomsg.Body + "\n\n"
-						err = DeleteMSGSQS(echomsg.RXMSG)
-						if err != nil {
-							log.Errorf("Could not delete msg after processing: %v", err)
-						}
-						break
-					}
-				}
+			ReplyError(w, r, ClientData, err)
+			return
+		}
+		if text == "END" {
+			Sessions.ClearTranscript(session.Token)
+			ClientData.EchoConversation = ""
+			ClientData.Notifications = Notifications.List(ClientData.Client)
+			err := tpl.ExecuteTemplate(w, "menu.gohtml", ClientData)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
+			return
+		}
+		if echomsg.Error != "" {
+			ClientData.Error = echomsg.Error
+		} else {
+			Sessions.AppendTranscript(session.Token, text, echomsg.Body)
+			ClientData.EchoConversation, ClientData.EchoSeq = EchoTranscript(session.Token)
 		}
-
 	}
 
 	err := tpl.ExecuteTemplate(w, "echo.gohtml", ClientData)
@@ -214,69 +434,83 @@ func echo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// SENDS text TO THE ECHO APP AND WAITS FOR ITS ECHO, EXCEPT FOR END WHICH IS NOT ANSWERED. RETURNS ErrReplyTimeout IF
+// THE ECHO APP DID NOT ANSWER IN TIME, ctx.Err() IF THE CALLER LEFT BEFORE OR A *SendError IF THE MESSAGE COULD NOT
+// BE SENT
+func SendEcho(ctx context.Context, ClientData ClientStruct, text string) (RXMsgStruct, error) {
+	timestamp := time.Now().Format("02-Jan-2006 15:04:05")
+	msg := &sqs.SendMessageInput{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"clientName": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.Client),
+			},
+			"sessionID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.SessID),
+			},
+			"timestamp": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(timestamp),
+			},
+			"cmd": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String("1"),
+			},
+			"token": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.AuthToken),
+			},
+		},
+		MessageBody: aws.String(text),
+		QueueUrl:    &inboxURL,
+	}
+
+	if text != "END" {
+		if rejected, ok := LocalRateLimit(ClientData, "echo"); !ok {
+			return rejected, nil
+		}
+	}
+	reply := Replies.Expect(ClientData.SessID, 1)
+	defer Replies.Cancel(reply)
+	log.Infof("Sending message to AWS echo app. MESSAGE: %s", text)
+	result, err := sqssvc.SendMessage(msg)
+	if err != nil {
+		log.Errorf("Could not send message to SQS queue: %v", err)
+		return RXMsgStruct{}, &SendError{Err: err}
+	}
+	log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+	if text == "END" {
+		return RXMsgStruct{}, nil
+	}
+	return Replies.Wait(ctx, reply)
+}
+
 func search(w http.ResponseWriter, r *http.Request) {
-	cmdint, _ := strconv.Atoi(r.FormValue("cmd"))
-	ClientData := ClientStruct{
-		Client: r.FormValue("client"),
-		Cmd:    cmdint,
-		SessID: r.FormValue("sessid"),
+	ClientData, _, ok := SessionClientData(w, r, 2)
+	if !ok {
+		return
 	}
 	w.Header().Set("Content-Type", "text/html")
 	if r.Method == http.MethodPost {
-		ClientData.SearchData.ClientSearch = r.FormValue("clientsearch")
-		ClientData.SearchData.Keysentence = r.FormValue("keysentence")
-
-		timestamp := time.Now().Format("02-Jan-2006 15:04:05")
-
-		msg := &sqs.SendMessageInput{
-			MessageAttributes: map[string]*sqs.MessageAttributeValue{
-				"clientName": &sqs.MessageAttributeValue{
-					DataType:    aws.String("String"),
-					StringValue: aws.String(ClientData.SearchData.ClientSearch),
-				},
-				"sessionID": &sqs.MessageAttributeValue{
-					DataType:    aws.String("String"),
-					StringValue: aws.String(ClientData.SessID),
-				},
-				"timestamp": &sqs.MessageAttributeValue{
-					DataType:    aws.String("String"),
-					StringValue: aws.String(timestamp),
-				},
-				"cmd": &sqs.MessageAttributeValue{
-					DataType:    aws.String("String"),
-					StringValue: aws.String(fmt.Sprintf("%d", ClientData.Cmd)),
-				},
-			},
-			MessageBody: aws.String(ClientData.SearchData.Keysentence),
-			QueueUrl:    &inboxURL,
+		ReadSearchForm(r, &ClientData.SearchData)
+		if ClientData.SearchData.JobID == "" && !CanAccess(ClientData, ClientData.SearchData.ClientSearch) {
+			RenderForbidden(w, ClientData, ClientData.SearchData.ClientSearch, "search.gohtml")
+			return
 		}
-
-		log.Infof("Sending search command to AWS search app. KEYWORD: %s", ClientData.SearchData.Keysentence)
-		result, err := sqssvc.SendMessage(msg)
-		if err != nil {
-			log.Errorf("Could not send message to SQS queue: %v", err)
-		} else {
-			log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
-			for {
-				msgrx := <-SearchDone
-				if ClientData.SessID != msgrx.SessID {
-					sqssvc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
-						QueueUrl:          &outboxURL,
-						ReceiptHandle:     msgrx.RXMSG.Messages[0].ReceiptHandle,
-						VisibilityTimeout: aws.Int64(0),
-					})
-					continue
-				} else {
-					ClientData.SearchData.SearchResult = msgrx.Body
-					err = DeleteMSGSQS(msgrx.RXMSG)
-					if err != nil {
-						log.Errorf("Could not delete msg after processing: %v", err)
-					}
-					break
+		if ClientData.SearchData.JobID == "" {
+			// New searches run in background, the job page polls their status until they are done
+			if SubmitSearch(&ClientData) {
+				err := tpl.ExecuteTemplate(w, "searchjob.gohtml", ClientData)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
 				}
+				return
 			}
+		} else if err := RequestSearchPage(r.Context(), &ClientData); err != nil {
+			ReplyError(w, r, ClientData, err)
+			return
 		}
-
 	}
 
 	err := tpl.ExecuteTemplate(w, "search.gohtml", ClientData)
@@ -285,27 +519,198 @@ func search(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// SENDS THE SEARCH OF ClientData TO THE SEARCH APP AS A NEW JOB WITH A NEW JOB ID, WITHOUT WAITING FOR IT. ON SUCCESS
+// SearchJob IS THE QUEUED JOB, OTHERWISE SearchData.Error TELLS WHY IT WAS NOT SENT
+func SubmitSearch(ClientData *ClientStruct) bool {
+	if rejected, ok := LocalRateLimit(*ClientData, "search"); !ok {
+		ClientData.SearchData.Error = rejected.Error
+		ClientData.SearchData.RetryAfter = rejected.RetryAfter
+		return false
+	}
+	ClientData.SearchData.JobID = StringWithCharset(12)
+	msg := NewSearchMessage(*ClientData)
+	msg.MessageAttributes["newJob"] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(ClientData.SearchData.JobID),
+	}
+	log.Infof("Sending search command to AWS search app. KEYWORD: %s\tJOB: %s", ClientData.SearchData.Keysentence, ClientData.SearchData.JobID)
+	result, err := sqssvc.SendMessage(msg)
+	if err != nil {
+		log.Errorf("Could not send message to SQS queue: %v", err)
+		ClientData.SearchData.Error = "Could not send the search to the search app"
+		return false
+	}
+	log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+	ClientData.SearchJob = &JobStatusStruct{
+		JobID:    ClientData.SearchData.JobID,
+		Client:   ClientData.SearchData.ClientSearch,
+		Sentence: ClientData.SearchData.Keysentence,
+		Parent:   ClientData.SearchData.Refine,
+		Status:   "queued",
+	}
+	return true
+}
+
+// READS THE SEARCH FIELDS OF A FORM (THE SEARCH ITSELF, THE PAGE AND THE JOB IT BELONGS TO)
+func ReadSearchForm(r *http.Request, data *SearchStruct) {
+	data.ClientSearch = r.FormValue("clientsearch")
+	data.Keysentence = r.FormValue("keysentence")
+	data.Before, _ = strconv.Atoi(r.FormValue("before"))
+	data.After, _ = strconv.Atoi(r.FormValue("after"))
+	data.Fuzzy = r.FormValue("fuzzy") == "on"
+	data.Similar = r.FormValue("similar") == "on"
+	data.MaxDistance, _ = strconv.Atoi(r.FormValue("maxdistance"))
+	data.Cursor = r.FormValue("cursor")
+	data.JobID = r.FormValue("jobid")
+	data.Refine = r.FormValue("refine")
+}
+
+// BUILDS THE SEARCH REQUEST FOR THE SEARCH APP. WITH A JOB ID IT ASKS FOR A PAGE OF THAT JOB INSTEAD OF SEARCHING AGAIN
+func NewSearchMessage(ClientData ClientStruct) *sqs.SendMessageInput {
+	timestamp := time.Now().Format("02-Jan-2006 15:04:05")
+	msg := &sqs.SendMessageInput{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"clientName": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.SearchData.ClientSearch),
+			},
+			"sessionID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.SessID),
+			},
+			"timestamp": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(timestamp),
+			},
+			"cmd": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(fmt.Sprintf("%d", ClientData.Cmd)),
+			},
+			"token": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(ClientData.AuthToken),
+			},
+			"before": &sqs.MessageAttributeValue{
+				DataType:    aws.String("Number"),
+				StringValue: aws.String(strconv.Itoa(ClientData.SearchData.Before)),
+			},
+			"after": &sqs.MessageAttributeValue{
+				DataType:    aws.String("Number"),
+				StringValue: aws.String(strconv.Itoa(ClientData.SearchData.After)),
+			},
+			"fuzzy": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(strconv.FormatBool(ClientData.SearchData.Fuzzy)),
+			},
+			"maxDistance": &sqs.MessageAttributeValue{
+				DataType:    aws.String("Number"),
+				StringValue: aws.String(strconv.Itoa(ClientData.SearchData.MaxDistance)),
+			},
+			"similar": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(strconv.FormatBool(ClientData.SearchData.Similar)),
+			},
+		},
+		MessageBody: aws.String(ClientData.SearchData.Keysentence),
+		QueueUrl:    &inboxURL,
+	}
+	if ClientData.SearchData.Cursor != "" {
+		msg.MessageAttributes["cursor"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(ClientData.SearchData.Cursor),
+		}
+	}
+	if ClientData.SearchData.JobID != "" {
+		msg.MessageAttributes["jobID"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(ClientData.SearchData.JobID),
+		}
+	}
+	if ClientData.SearchData.Refine != "" {
+		msg.MessageAttributes["refine"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(ClientData.SearchData.Refine),
+		}
+	}
+	return msg
+}
+
+// ASKS THE SEARCH APP FOR THE REQUESTED PAGE OF A FINISHED JOB AND WAITS FOR IT. RETURNS ErrReplyTimeout IF THE
+// SEARCH APP DID NOT ANSWER IN TIME, ctx.Err() IF THE BROWSER LEFT BEFORE OR A *SendError IF THE REQUEST COULD NOT BE
+// SENT
+func RequestSearchPage(ctx context.Context, ClientData *ClientStruct) error {
+	if rejected, ok := LocalRateLimit(*ClientData, "pages"); !ok {
+		ClientData.SearchData.Error = rejected.Error
+		ClientData.SearchData.RetryAfter = rejected.RetryAfter
+		return nil
+	}
+	msg := NewSearchMessage(*ClientData)
+	reply := Replies.Expect(ClientData.SessID, 2)
+	defer Replies.Cancel(reply)
+	log.Infof("Sending search page command to AWS search app. JOB: %s", ClientData.SearchData.JobID)
+	result, err := sqssvc.SendMessage(msg)
+	if err != nil {
+		log.Errorf("Could not send message to SQS queue: %v", err)
+		return &SendError{Err: err}
+	}
+	log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+	msgrx, err := Replies.Wait(ctx, reply)
+	if err != nil {
+		return err
+	}
+	ClientData.SearchData.SearchResult = msgrx.Body
+	ClientData.SearchData.SearchResults = msgrx.Results
+	ClientData.SearchData.TotalMatches = msgrx.TotalMatches
+	ClientData.SearchData.NextCursor = msgrx.NextCursor
+	ClientData.SearchData.PrevCursor = msgrx.PrevCursor
+	ClientData.SearchData.JobID = msgrx.JobID
+	ClientData.SearchData.Error = msgrx.Error
+	ClientData.SearchData.RetryAfter = msgrx.RetryAfter
+	return nil
+}
+
+// TELLS THE USER WHY A REQUEST GOT NO REPLY, WITH THE STATUS OF ReplyStatus: 504 IF THE WORKER DID NOT ANSWER IN
+// TIME, 502 IF THE REQUEST COULD NOT BE SENT. NOTHING IS WRITTEN IF THE BROWSER ALREADY LEFT, THE PENDING REPLY IS
+// RELEASED BY THE HANDLER EITHER WAY
+func ReplyError(w http.ResponseWriter, r *http.Request, ClientData ClientStruct, err error) {
+	status := ReplyStatus(err)
+	if status == 0 || errors.Is(r.Context().Err(), context.Canceled) {
+		log.Infof("Client of session %s disconnected while waiting for a reply", ClientData.SessID)
+		return
+	}
+	if status == http.StatusGatewayTimeout {
+		log.Warnf("No reply for session %s: %v", ClientData.SessID, err)
+		ClientData.Error = "The worker did not respond in time, try again later."
+	} else {
+		log.Errorf("Request of session %s failed: %v", ClientData.SessID, err)
+		ClientData.Error = "The request could not be passed to the worker, try again later."
+	}
+	w.WriteHeader(status)
+	err = tpl.ExecuteTemplate(w, "timeout.gohtml", ClientData)
+	if err != nil {
+		log.Errorf("Could not render timeout page: %v", err)
+	}
+}
+
+// CHECKS THE USER OF THE DOWNLOAD FORM AND SENDS THE BROWSER TO /download/[USER], WHICH STREAMS THE FILE
 func download(w http.ResponseWriter, r *http.Request) {
-	cmdint, _ := strconv.Atoi(r.FormValue("cmd"))
-	ClientData := ClientStruct{
-		Client: r.FormValue("client"),
-		Cmd:    cmdint,
-		SessID: r.FormValue("sessid"),
+	ClientData, _, ok := SessionClientData(w, r, 3)
+	if !ok {
+		return
 	}
 	w.Header().Set("Content-Type", "text/html")
 	if r.Method == http.MethodPost {
 		ClientData.DownloadUser = r.FormValue("downloaduser")
-		err := DownloadConversation(ClientData.DownloadUser)
-		if err != nil {
-			log.Errorf("Could not download conversation %v", err)
+		if !CanAccess(ClientData, ClientData.DownloadUser) {
+			RenderForbidden(w, ClientData, ClientData.DownloadUser, "download.gohtml")
+			return
 		}
-
-		b, err := ioutil.ReadFile("conversations/" + ClientData.DownloadUser + ".txt")
-		if err != nil {
-			log.Errorf("Could not read whole conversation file to string variable: %v", err)
+		format := r.FormValue("format")
+		if _, ok := ExportFormats[format]; !ok {
+			format = "txt"
 		}
-		ClientData.DownloadFile = string(b)
-		os.Remove("conversations/" + ClientData.DownloadUser + ".txt")
+		http.Redirect(w, r, "/download/"+url.PathEscape(ClientData.DownloadUser)+"?format="+format, http.StatusSeeOther)
+		return
 	}
 
 	err := tpl.ExecuteTemplate(w, "download.gohtml", ClientData)
@@ -317,8 +722,10 @@ func download(w http.ResponseWriter, r *http.Request) {
 // RECEIVING MESSAGES FROM SQS OUTBOX QUEUE THREAD
 func ReceiveMSGS() {
 	for {
+		Metrics.Polled()
 		RXmsginput := &sqs.ReceiveMessageInput{
-			MessageAttributeNames: aws.StringSlice([]string{"clientName", "sessionID", "cmd", "timestamp"}),
+			MessageAttributeNames: aws.StringSlice([]string{"clientName", "sessionID", "cmd", "timestamp", "totalMatches", "nextCursor", "prevCursor", "jobID", "error", "target", "query", "token", "retryAfter"}),
+			AttributeNames:        aws.StringSlice([]string{"SentTimestamp", "ApproximateReceiveCount"}),
 			QueueUrl:              &outboxURL,
 			MaxNumberOfMessages:   aws.Int64(1),
 			WaitTimeSeconds:       aws.Int64(1),
@@ -339,34 +746,81 @@ func ReceiveMSGS() {
 		sessIDRX := *msgRX.MessageAttributes["sessionID"].StringValue
 		cmdRX := *msgRX.MessageAttributes["cmd"].StringValue
 		cRX, _ := strconv.Atoi(cmdRX)
+		start := time.Now()
+		cmdMetric := Metrics.Received(&msgRX)
 
-		fmt.Println(msgRX)
-		if cRX == 1 { // ECHO
-			rxmsgchan := RXMsgStruct{
-				Body:   textRX,
-				SessID: sessIDRX,
-				RXMSG:  resultRX,
-			}
-			ReceivedEcho <- rxmsgchan
-		} else if cRX == 2 { // SEARCH
-			if textRX == "EMPTY CONVERSATION" {
-				rxmsgchan := RXMsgStruct{
-					Body:   textRX,
-					SessID: sessIDRX,
-					RXMSG:  resultRX,
+		// The attributes are left out, replies to logins carry the token of the session
+		log.Debugf("Message received from the outbox. MessageID: %s\tCommand: %s\tSession: %s", aws.StringValue(msgRX.MessageId), cmdRX, sessIDRX)
+		if cRX == 6 { // SAVED SEARCH NOTIFICATION
+			notification, parseErr := ParseNotification(&msgRX)
+			if parseErr != nil {
+				log.Warnf("Discarding notification: %v", parseErr)
+			} else if !Notifications.Add(GetAttribute(&msgRX, "clientName"), notification) {
+				// Not a user of this web client, leave it for its own client
+				dropped, err := ReleaseNotification(&msgRX)
+				if err != nil {
+					log.Errorf("Could not release notification: %v", err)
 				}
-				log.Warnf("Could not find any lines containing that sentence for that client.")
-				SearchDone <- rxmsgchan
-			} else {
-				rxmsgchan := RXMsgStruct{
-					Body:   PrintFilteredFile(textRX),
-					SessID: sessIDRX,
-					RXMSG:  resultRX,
+				if dropped {
+					Metrics.Finished(&msgRX, cmdMetric, start, err)
+				} else {
+					Metrics.Inc(metricSkipped, cmdMetric)
 				}
-				SearchDone <- rxmsgchan
+				continue
+			}
+			err = DeleteMSGSQS(resultRX)
+			if err != nil {
+				log.Errorf("Could not delete msg after processing: %v", err)
+			} else if parseErr != nil {
+				err = parseErr
 			}
+			Metrics.Finished(&msgRX, cmdMetric, start, err)
+			continue
 		}
 
+		// The admin dashboard lists the errors of the workers, also those replied to the sessions of other clients
+		if reason := GetAttribute(&msgRX, "error"); reason != "" {
+			WorkerErrors.Add(NewWorkerError(&msgRX, "outbox", reason))
+		}
+		if !Replies.Local(sessIDRX) {
+			// Reply to a session of another client, leave it for that client
+			sqssvc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          &outboxURL,
+				ReceiptHandle:     msgRX.ReceiptHandle,
+				VisibilityTimeout: aws.Int64(0),
+			})
+			Metrics.Inc(metricSkipped, cmdMetric)
+			continue
+		}
+		// The reply is ours, the router keeps it until its handler takes it
+		err = DeleteMSGSQS(resultRX)
+		if err != nil {
+			log.Errorf("Could not delete msg after processing: %v", err)
+		}
+
+		rxmsg := RXMsgStruct{
+			Body:   textRX,
+			SessID: sessIDRX,
+			Error:  GetAttribute(&msgRX, "error"),
+			Token:  GetAttribute(&msgRX, "token"),
+		}
+		rxmsg.RetryAfter, _ = strconv.Atoi(GetAttribute(&msgRX, "retryAfter"))
+		if cRX == 2 { // SEARCH
+			if rxmsg.Error != "" {
+				log.Warnf("%s", rxmsg.Error)
+			} else if textRX == "EMPTY CONVERSATION" {
+				log.Warnf("Could not find any lines containing that sentence for that client.")
+			} else {
+				rxmsg.Body = ""
+				rxmsg.Results = ParseSearchResults(textRX)
+				rxmsg.TotalMatches = GetAttribute(&msgRX, "totalMatches")
+				rxmsg.NextCursor = GetAttribute(&msgRX, "nextCursor")
+				rxmsg.PrevCursor = GetAttribute(&msgRX, "prevCursor")
+				rxmsg.JobID = GetAttribute(&msgRX, "jobID")
+			}
+		}
+		Replies.Dispatch(cRX, rxmsg)
+		Metrics.Finished(&msgRX, cmdMetric, start, err)
 	}
 }
 
@@ -445,6 +899,57 @@ func initConfig() {
 	inboxURL = viper.GetString("sqs.inboxURL")
 	outboxURL = viper.GetString("sqs.outboxURL")
 
+	// REPLIES NOBODY WAITS FOR ARE HELD router.holdseconds BEFORE BEING DROPPED AND HANDLERS
+	// WAIT router.replytimeoutseconds FOR A REPLY
+	Replies = NewReplyRouter(time.Duration(viper.GetInt("router.holdseconds"))*time.Second, time.Duration(viper.GetInt("router.replytimeoutseconds"))*time.Second)
+
+	// RATE LIMITS PER USER AND COMMAND, CHECKED BEFORE SENDING ANYTHING TO THE WORKERS
+	Limits = NewRateLimiter(ReadRateLimits())
+
+	// SECRET SHARED WITH THE WORKERS TO VERIFY THE TOKENS BEFORE SERVING ANYTHING WITHOUT THEM
+	var err error
+	tokenSecret, err = ReadTokenSecret()
+	if err != nil {
+		log.Errorf("[INIT] %v", err)
+		os.Exit(1)
+	}
+
+	// THE ADMIN DASHBOARD SHOWS THE LAST admin.recenterrors WORKER ERRORS AND LISTS THE BUCKET AT MOST ONCE EVERY
+	// admin.storagecacheseconds
+	WorkerErrors = NewWorkerErrorLog(viper.GetInt("admin.recenterrors"))
+	if seconds := viper.GetInt("admin.storagecacheseconds"); seconds > 0 {
+		StorageUsage.ttl = time.Duration(seconds) * time.Second
+	}
+
+	// WEB SESSIONS EXPIRE AFTER sessions.ttlminutes WITHOUT REQUESTS. WITH sessions.file THEY SURVIVE RESTARTS
+	Sessions = NewSessionStore(time.Duration(viper.GetInt("sessions.ttlminutes"))*time.Minute, viper.GetString("sessions.file"), viper.GetInt("sessions.maxtranscriptlines"), viper.GetBool("sessions.securecookie"))
+	if err := Sessions.Load(); err != nil {
+		log.Errorf("[INIT] Could not load web sessions: %v", err)
+	}
+	Sessions.Each(func(session WebSession) {
+		Replies.AddSession(session.SessID)
+		Notifications.AddUser(session.Client)
+	})
+
+	// LISTENER, TLS, TIMEOUTS, SIZE LIMITS AND SECURITY HEADERS OF THE [web] SECTION
+	webConfig = ReadWebConfig()
+	if webConfig.TLS() && !viper.GetBool("sessions.securecookie") {
+		log.Warn("[INIT] Serving HTTPS but sessions.securecookie is off, session cookies would also be sent over HTTP")
+	}
+
+	// TEMPLATES AND STATIC FILES ARE EMBEDDED. FILES IN assets.overridedir REPLACE THEM AND /static/ FILES ARE
+	// CACHED BY BROWSERS FOR assets.maxageseconds
+	Assets = AssetFS{override: viper.GetString("assets.overridedir")}
+	if maxAge := viper.GetInt("assets.maxageseconds"); maxAge > 0 {
+		assetMaxAge = time.Duration(maxAge) * time.Second
+	}
+
+	// LIVE ECHO CONNECTIONS GET A HEARTBEAT EVERY echo.heartbeatseconds SO PROXIES DO NOT CLOSE THEM
+	echoHeartbeat = time.Duration(viper.GetInt("echo.heartbeatseconds")) * time.Second
+	if echoHeartbeat <= 0 {
+		echoHeartbeat = 15 * time.Second
+	}
+
 	return
 }
 
@@ -458,91 +963,93 @@ func StringWithCharset(length int) string {
 	return string(b)
 }
 
-// DOWNLOAD CONVERSATION DIRECTLY FROM S3 GIVEN THE USERNAME
-func DownloadConversation(client string) error {
-	s3svc := s3.New(sess)
-	bucketname := viper.GetString("s3.bucketname")
-
-	// List all conversations ([S3_CONVERSATIONS_PATH]/[USERNAME]_[SESSION_ID].txt) that begin with [S3_CONVERSATIONS_PATH]/[USERNAME]
-	resp, err := s3svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(bucketname), Prefix: aws.String(viper.GetString("s3.conversationspath") + "/" + client)})
-	if err != nil {
-		return fmt.Errorf("Unable to list items in bucket %q, %v", bucketname, err)
-	}
+// BUILDS THE DATA OF A PAGE BUTTON FROM THE SEARCH TEMPLATE
+func NewPageLink(data ClientStruct, cursor string, label string) PageLinkStruct {
+	return PageLinkStruct{Data: data, Cursor: cursor, Label: label}
+}
 
-	// Download every session from our user and when finished, combine all those files in a local one called [S3_CONVERSATIONS_PATH]/[USERNAME].txt
-	downloader := s3manager.NewDownloader(sess)
-	// Loop for downloading every session from our user and store them in local folder [S3_CONVERSATIONS_PATH]
-	for _, item := range resp.Contents {
-		downloadPath := *item.Key
-		// Local session file
-		f, err := os.OpenFile(downloadPath, os.O_RDWR|os.O_CREATE, 0644)
-		if err != nil {
-			return fmt.Errorf("Failed to create file %q, %v", downloadPath, err)
+// PARSES THE RANKED LINES SENT BY THE SEARCH APP ([TIMESTAMP]|||[TEXT]|||[SCORE]|||[OFFSETS]|||[VARIANTS]|||[SESSION]|||[LINE]
+// SEPARATED BY ///). CONTEXT LINES HAVE NO SCORE AND -- SEPARATES CONTEXT GROUPS
+func ParseSearchResults(file string) []SearchResultStruct {
+	var results []SearchResultStruct
+	newGroup := false
+	lines := strings.Split(file, "///")
+	for _, line := range lines {
+		if line == "--" {
+			newGroup = true
+			continue
 		}
-
-		// Write the contents of S3 session file to the local session file
-		_, err = downloader.Download(f, &s3.GetObjectInput{
-			Bucket: aws.String(bucketname),
-			Key:    aws.String(downloadPath),
-		})
-		f.Close()
-		if err != nil {
-			return fmt.Errorf("failed to download file, %v", err)
+		if line == "" {
+			continue
+		}
+		columns := strings.Split(line, "|||")
+		if len(columns) < 2 {
+			continue
+		}
+		result := SearchResultStruct{Timestamp: columns[0], NewGroup: newGroup}
+		newGroup = false
+		var offsets [][2]int
+		if len(columns) >= 4 {
+			result.Score = columns[2]
+			result.Context = columns[2] == ""
+			offsets = ParseMatchOffsets(columns[3])
 		}
+		if len(columns) >= 5 {
+			result.Variants = strings.Replace(columns[4], ",", ", ", -1)
+		}
+		if len(columns) >= 7 {
+			result.Session = columns[5]
+			result.Line, _ = strconv.Atoi(columns[6])
+		}
+		result.Segments = SplitMatches(columns[1], offsets)
+		results = append(results, result)
 	}
-
-	// Combine all sessions in [S3_CONVERSATIONS_PATH]/[USERNAME].txt
-	CombineSessionsToFile(client, resp)
-	log.Infof("Whole conversation of client %s has been downloaded.", client)
-	return nil
+	return results
 }
 
-// COMBINE MULTIPLE SESSION FILES ([S3_CONVERSATIONS_PATH]/[USERNAME]_[SESSION_ID].txt) IN ONE ([S3_CONVERSATIONS_PATH]/[USERNAME].txt)
-func CombineSessionsToFile(client string, items *s3.ListObjectsV2Output) error {
-	newFileName := "conversations/" + client + ".txt"
-	os.Remove(newFileName)
-	newFile, err := os.OpenFile(newFileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
-	defer newFile.Close()
-	if err != nil {
-		return fmt.Errorf("Failed to create file %q: %v", newFileName, err)
-	}
-	// Iterate over session files and append them to the combined one
-	for _, item := range items.Contents {
-		// Open session file
-		pieceFile, err := os.Open(*item.Key)
-		if err != nil {
-			log.Warnf("Failed to open piece file for reading: %v", err)
+// PARSES THE OFFSETS COLUMN OF A SEARCH RESULT ([START]-[END],[START]-[END]...). MALFORMED PAIRS ARE SKIPPED
+func ParseMatchOffsets(column string) [][2]int {
+	var offsets [][2]int
+	for _, pair := range strings.Split(column, ",") {
+		bounds := strings.Split(pair, "-")
+		if len(bounds) != 2 {
+			continue
 		}
-		defer pieceFile.Close()
-
-		// Append session file
-		n, err := io.Copy(newFile, pieceFile)
-		if err != nil {
-			log.Warnf("Failed to append piece file to big file: %v", err)
+		start, err1 := strconv.Atoi(bounds[0])
+		end, err2 := strconv.Atoi(bounds[1])
+		if err1 != nil || err2 != nil {
+			continue
 		}
-		log.Infof("Wrote %d bytes of %s to the end of %s\n", n, *item.Key, newFileName)
+		offsets = append(offsets, [2]int{start, end})
+	}
+	return offsets
+}
 
-		// Delete session file
-		pieceFile.Close()
-		if err := os.Remove(*item.Key); err != nil {
-			log.Errorf("Failed to remove piece file %s: %v", *item.Key, err)
+// SPLITS text IN ALTERNATING PLAIN AND MATCHING SEGMENTS
+func SplitMatches(text string, offsets [][2]int) []TextSegment {
+	var segments []TextSegment
+	last := 0
+	for _, o := range offsets {
+		if o[0] < last || o[1] > len(text) || o[0] >= o[1] {
+			continue
 		}
+		if o[0] > last {
+			segments = append(segments, TextSegment{Text: text[last:o[0]]})
+		}
+		segments = append(segments, TextSegment{Text: text[o[0]:o[1]], Match: true})
+		last = o[1]
 	}
-	newFile.Close()
-	return nil
+	if last < len(text) {
+		segments = append(segments, TextSegment{Text: text[last:]})
+	}
+	return segments
 }
 
-// PRINT FILTERED FILE ON CONSOLE
-func PrintFilteredFile(file string) string {
-	var fileString = ""
-	// fmt.Println("\nFiltered conversation:")
-	lines := strings.Split(file, "///")
-	for _, line := range lines {
-		if line != "" {
-			columns := strings.Split(line, "|||")
-			fileString = fmt.Sprintf("%s%s    %s\n", fileString, columns[0], columns[1])
-		}
+// RETURNS THE STRING VALUE OF AN OPTIONAL MESSAGE ATTRIBUTE OR "" IF THE MESSAGE DOES NOT CARRY IT
+func GetAttribute(msg *sqs.Message, name string) string {
+	attr, ok := msg.MessageAttributes[name]
+	if !ok || attr.StringValue == nil {
+		return ""
 	}
-	fileString = fmt.Sprintf("%s\n", fileString)
-	return fileString
+	return *attr.StringValue
 }
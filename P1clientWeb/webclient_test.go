@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMatchOffsets(t *testing.T) {
+	tests := []struct {
+		column string
+		want   [][2]int
+	}{
+		{"", nil},
+		{"0-5", [][2]int{{0, 5}}},
+		{"0-5,6-11", [][2]int{{0, 5}, {6, 11}}},
+		{"0-5,x-7,9", [][2]int{{0, 5}}},
+	}
+	for _, tt := range tests {
+		if got := ParseMatchOffsets(tt.column); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMatchOffsets(%q) = %v, want %v", tt.column, got, tt.want)
+		}
+	}
+}
+
+func TestSplitMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		offsets [][2]int
+		want    []TextSegment
+	}{
+		{"no matches", "hello", nil, []TextSegment{{Text: "hello"}}},
+		{"match in the middle", "say hello now", [][2]int{{4, 9}}, []TextSegment{{Text: "say "}, {Text: "hello", Match: true}, {Text: " now"}}},
+		{"whole text", "hello", [][2]int{{0, 5}}, []TextSegment{{Text: "hello", Match: true}}},
+		{"overlapping offsets are skipped", "hello", [][2]int{{0, 3}, {2, 5}}, []TextSegment{{Text: "hel", Match: true}, {Text: "lo"}}},
+		{"offsets past the text are skipped", "hi", [][2]int{{0, 9}}, []TextSegment{{Text: "hi"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SplitMatches(tt.text, tt.offsets); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitMatches(%q, %v) = %v, want %v", tt.text, tt.offsets, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSearchResults(t *testing.T) {
+	file := "10-Mar-2024 12:00:00|||hello there|||2.500|||0-5///malformed///09-Mar-2024 08:00:00|||plain line///"
+	got := ParseSearchResults(file)
+	want := []SearchResultStruct{
+		{Timestamp: "10-Mar-2024 12:00:00", Score: "2.500", Segments: []TextSegment{{Text: "hello", Match: true}, {Text: " there"}}},
+		{Timestamp: "09-Mar-2024 08:00:00", Segments: []TextSegment{{Text: "plain line"}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseSearchResults() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseSearchResultsContext(t *testing.T) {
+	got := ParseSearchResults("t0|||zero||||||///t1|||one|||1.000|||0-3///--///t4|||four|||2.000|||///")
+	want := []SearchResultStruct{
+		{Timestamp: "t0", Segments: []TextSegment{{Text: "zero"}}, Context: true},
+		{Timestamp: "t1", Score: "1.000", Segments: []TextSegment{{Text: "one", Match: true}}},
+		{Timestamp: "t4", Score: "2.000", Segments: []TextSegment{{Text: "four"}}, NewGroup: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseSearchResults() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseSearchResultsVariants(t *testing.T) {
+	got := ParseSearchResults("t0|||helo world|||1.000|||0-4|||helo,hallo///")
+	if len(got) != 1 || got[0].Variants != "helo, hallo" {
+		t.Errorf("ParseSearchResults() = %+v, want one result with variants \"helo, hallo\"", got)
+	}
+}
+
+func TestParseSearchResultsPosition(t *testing.T) {
+	got := ParseSearchResults("t0|||zero||||||||||||Ab12Cd|||4///t1|||one|||1.000|||0-3|||///")
+	if len(got) != 2 || got[0].Session != "Ab12Cd" || got[0].Line != 4 || got[1].Session != "" || got[1].Line != 0 {
+		t.Errorf("ParseSearchResults() = %+v, want the session and line of the first result only", got)
+	}
+}
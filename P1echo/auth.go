@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/sirupsen/logrus"
+	viper "github.com/theherk/viper"
+	bcrypt "golang.org/x/crypto/bcrypt"
+	term "golang.org/x/term"
+)
+
+// LOCAL ACCOUNT. ONLY THE BCRYPT HASH OF THE PASSWORD IS STORED. SharedWith ARE THE USERS ALLOWED TO READ THE
+// CONVERSATION OF THIS ONE. DailyMessages AND DailyStorage OVERRIDE THE QUOTAS OF THE CONFIG FILE WHEN SET
+type UserAccount struct {
+	User          string   `json:"user"`
+	Hash          string   `json:"hash"`
+	Roles         []string `json:"roles,omitempty"`
+	SharedWith    []string `json:"sharedWith,omitempty"`
+	DailyMessages *int64   `json:"dailyMessages,omitempty"`
+	DailyStorage  *int64   `json:"dailyStorageBytes,omitempty"`
+}
+
+// CONTENT OF A SESSION TOKEN. THE TOKEN IS [BASE64(CLAIMS)].[BASE64(HMAC-SHA256(CLAIMS))] SIGNED WITH auth.secret,
+// SO EVERY APP SHARING THE SECRET CAN VERIFY WHO SENT A MESSAGE AND WHAT IT MAY READ WITHOUT ASKING THE ECHO APP.
+// Shares ARE THE USERS THAT SHARED THEIR CONVERSATION WITH User. ROLES AND SHARES CHANGE ON THE NEXT LOGIN
+type TokenClaims struct {
+	User    string   `json:"user"`
+	Roles   []string `json:"roles,omitempty"`
+	Shares  []string `json:"shares,omitempty"`
+	Expires int64    `json:"exp"`
+}
+
+// ADMINS AND AUDITORS CAN READ EVERY CONVERSATION
+const (
+	RoleAdmin   = "admin"
+	RoleAuditor = "auditor"
+)
+
+func (claims TokenClaims) HasRole(role string) bool {
+	for _, r := range claims.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// TRUE IF THE USER MAY READ THE CONVERSATION OF target: ITS OWN, ONE SHARED WITH IT OR ANY IF ADMIN OR AUDITOR
+func (claims TokenClaims) CanAccess(target string) bool {
+	if target == claims.User || claims.HasRole(RoleAdmin) || claims.HasRole(RoleAuditor) {
+		return true
+	}
+	for _, owner := range claims.Shares {
+		if owner == target {
+			return true
+		}
+	}
+	return false
+}
+
+var tokenSecret []byte
+
+// READS THE ACCOUNTS FILE (auth.usersfile). A MISSING FILE MEANS THERE ARE NO ACCOUNTS YET
+func LoadUsers() ([]UserAccount, error) {
+	path := viper.GetString("auth.usersfile")
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Could not read users file %s: %v", path, err)
+	}
+	var users []UserAccount
+	err = json.Unmarshal(b, &users)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed users file %s: %v", path, err)
+	}
+	return users, nil
+}
+
+// CREATES THE ACCOUNT user OR CHANGES ITS PASSWORD IF IT ALREADY EXISTS
+func StoreUser(user string, password string, roles []string) error {
+	if user == "" || password == "" {
+		return fmt.Errorf("User name and password cannot be empty")
+	}
+	if !ValidName(user) {
+		return fmt.Errorf("User name %q may only hold letters, digits and -", user)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("Could not hash password: %v", err)
+	}
+	return UpdateUser(user, true, func(account *UserAccount) {
+		account.Hash = string(hash)
+		if roles != nil {
+			account.Roles = roles
+		}
+	})
+}
+
+// LETS reader READ THE CONVERSATION OF owner, OR STOPS LETTING IT IF share IS FALSE
+func ShareConversation(owner string, reader string, share bool) error {
+	if reader == "" || reader == owner {
+		return fmt.Errorf("Write the user to share the conversation of %s with", owner)
+	}
+	return UpdateUser(owner, false, func(account *UserAccount) {
+		kept := []string{}
+		for _, user := range account.SharedWith {
+			if user != reader {
+				kept = append(kept, user)
+			}
+		}
+		if share {
+			kept = append(kept, reader)
+		}
+		account.SharedWith = kept
+	})
+}
+
+// APPLIES update TO THE ACCOUNT user AND SAVES THE ACCOUNTS FILE. THE ACCOUNT IS CREATED ONLY IF create IS SET
+func UpdateUser(user string, create bool, update func(account *UserAccount)) error {
+	users, err := LoadUsers()
+	if err != nil {
+		return err
+	}
+	found := false
+	for i := range users {
+		if users[i].User == user {
+			update(&users[i])
+			found = true
+		}
+	}
+	if !found && !create {
+		return fmt.Errorf("User %s does not exist", user)
+	} else if !found {
+		account := UserAccount{User: user}
+		update(&account)
+		users = append(users, account)
+	}
+	b, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Could not encode users: %v", err)
+	}
+	path := viper.GetString("auth.usersfile")
+	err = ioutil.WriteFile(path+".tmp", b, 0600)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not write users file %s: %v", path, err)
+	}
+	return nil
+}
+
+// PARSES THE -roles FLAG. AN EMPTY FLAG RETURNS NIL (KEEP THE CURRENT ROLES) AND "none" NO ROLES
+func ParseRoles(flag string) []string {
+	if flag == "" {
+		return nil
+	}
+	roles := []string{}
+	for _, role := range strings.Split(flag, ",") {
+		role = strings.TrimSpace(role)
+		if role == RoleAdmin || role == RoleAuditor {
+			roles = append(roles, role)
+		} else if role != "none" {
+			log.Warnf("Ignoring unknown role %q", role)
+		}
+	}
+	return roles
+}
+
+func FirstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// ASKS FOR THE PASSWORD OF user ON THE CONSOLE AND STORES THE ACCOUNT (USED BY THE -adduser FLAG). WITH roles
+// NIL THE ROLES OF AN EXISTING ACCOUNT ARE KEPT
+func AddUserFromConsole(user string, roles []string) error {
+	password, err := ReadPassword(fmt.Sprintf("Password for %s: ", user))
+	if err != nil {
+		return err
+	}
+	repeated, err := ReadPassword("Repeat the password: ")
+	if err != nil {
+		return err
+	}
+	if password != repeated {
+		return fmt.Errorf("Passwords do not match")
+	}
+	return StoreUser(user, password, roles)
+}
+
+// READS A PASSWORD FROM THE CONSOLE WITHOUT ECHOING IT (OR A PLAIN LINE IF STDIN IS NOT A TERMINAL)
+func ReadPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println("")
+		if err != nil {
+			return "", fmt.Errorf("Could not read password: %v", err)
+		}
+		return string(b), nil
+	}
+	text, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("Could not read password: %v", err)
+	}
+	return strings.TrimSuffix(text, "\n"), nil
+}
+
+// CHECKS THE PASSWORD OF user AGAINST THE ACCOUNTS AND RETURNS THE CLAIMS OF ITS TOKEN
+func CheckPassword(users []UserAccount, user string, password string) (TokenClaims, error) {
+	for _, account := range users {
+		if account.User == user {
+			if bcrypt.CompareHashAndPassword([]byte(account.Hash), []byte(password)) != nil {
+				return TokenClaims{}, fmt.Errorf("Wrong user name or password")
+			}
+			return NewTokenClaims(users, user), nil
+		}
+	}
+	return TokenClaims{}, fmt.Errorf("Wrong user name or password")
+}
+
+// RETURNS THE CURRENT ROLES AND SHARES OF user, VALID FOR auth.tokenttlminutes
+func NewTokenClaims(users []UserAccount, user string) TokenClaims {
+	claims := TokenClaims{
+		User:    user,
+		Expires: time.Now().Add(time.Duration(viper.GetInt("auth.tokenttlminutes")) * time.Minute).Unix(),
+	}
+	for _, account := range users {
+		if account.User == user {
+			claims.Roles = account.Roles
+		}
+		for _, reader := range account.SharedWith {
+			if reader == user {
+				claims.Shares = append(claims.Shares, account.User)
+			}
+		}
+	}
+	return claims
+}
+
+// RETURNS THE SIGNED TOKEN OF claims
+func SignToken(claims TokenClaims) (string, error) {
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("Could not encode token: %v", err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + tokenSignature(payload), nil
+}
+
+// RETURNS THE CLAIMS OF A TOKEN. FAILS IF THE TOKEN IS MALFORMED, FORGED OR EXPIRED
+func VerifyToken(token string) (TokenClaims, error) {
+	var claims TokenClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return claims, fmt.Errorf("Malformed token")
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(tokenSignature(parts[0]))) {
+		return claims, fmt.Errorf("Invalid token signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, fmt.Errorf("Malformed token: %v", err)
+	}
+	err = json.Unmarshal(b, &claims)
+	if err != nil || claims.User == "" {
+		return claims, fmt.Errorf("Malformed token")
+	}
+	if time.Now().Unix() > claims.Expires {
+		return claims, fmt.Errorf("Token expired, log in again")
+	}
+	return claims, nil
+}
+
+func tokenSignature(payload string) string {
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// RETURNS WHO SENT A MESSAGE ACCORDING TO ITS token ATTRIBUTE
+func Authenticate(msg *sqs.Message) (TokenClaims, error) {
+	token := GetAttribute(msg, "token")
+	if token == "" {
+		return TokenClaims{}, fmt.Errorf("Not logged in")
+	}
+	return VerifyToken(token)
+}
+
+// ACCORDING TO THE ACCOUNTS FILE, TRUE IF reader MAY STILL READ THE CONVERSATION OF owner
+func AccountCanAccess(users []UserAccount, reader string, owner string) bool {
+	return NewTokenClaims(users, reader).CanAccess(owner)
+}
+
+// CHECKS THE PASSWORD SENT IN THE BODY OF A LOGIN MESSAGE (COMMAND 8) FOR THE USER IN clientName AND ANSWERS
+// WITH A SIGNED TOKEN IN THE token ATTRIBUTE, OR WITH THE REASON IN THE error ATTRIBUTE. A LOGIN MESSAGE CARRYING A
+// token INSTEAD RENEWS IT (SEE ProcessRenewMessage)
+func ProcessLoginMessage(msg *sqs.Message) error {
+	if GetAttribute(msg, "token") != "" {
+		return ProcessRenewMessage(msg)
+	}
+	user := *msg.MessageAttributes["clientName"].StringValue
+	token := ""
+	users, err := LoadUsers()
+	if err == nil {
+		var claims TokenClaims
+		claims, err = CheckPassword(users, user, *msg.Body)
+		if err == nil {
+			token, err = SignToken(claims)
+		}
+	}
+	if err != nil {
+		log.Warnf("Login of %s failed: %v", user, err)
+		return SendUnauthorized(msg, err)
+	}
+	log.Infof("User %s logged in", user)
+	reply := NewAuthReply(msg, "LOGGED IN")
+	reply.MessageAttributes["token"] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(token),
+	}
+	return SendReply(reply)
+}
+
+// ANSWERS A VALID TOKEN OF AN EXISTING ACCOUNT WITH A NEW ONE CARRYING ITS CURRENT ROLES AND SHARES. THE NEW TOKEN
+// EXPIRES WHEN THE OLD ONE DID, SO RENEWING NEVER EXTENDS A LOGIN. CLIENTS RENEW BEFORE ACTING ON THE ROLES OF A
+// TOKEN WITHOUT A WORKER IN BETWEEN, SO REVOKED ROLES TAKE EFFECT AT ONCE
+func ProcessRenewMessage(msg *sqs.Message) error {
+	user := *msg.MessageAttributes["clientName"].StringValue
+	claims, err := Authenticate(msg)
+	if err == nil && claims.User != user {
+		err = fmt.Errorf("Token of %s cannot be used as %s", claims.User, user)
+	}
+	token := ""
+	if err == nil {
+		var users []UserAccount
+		users, err = LoadUsers()
+		if err == nil && !AccountExists(users, user) {
+			err = fmt.Errorf("The account of %s was removed", user)
+		}
+		if err == nil {
+			renewed := NewTokenClaims(users, user)
+			renewed.Expires = claims.Expires
+			token, err = SignToken(renewed)
+		}
+	}
+	if err != nil {
+		log.Warnf("Token renewal of %s failed: %v", user, err)
+		return SendUnauthorized(msg, err)
+	}
+	log.Infof("Token of %s renewed", user)
+	reply := NewAuthReply(msg, "RENEWED")
+	reply.MessageAttributes["token"] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(token),
+	}
+	return SendReply(reply)
+}
+
+func AccountExists(users []UserAccount, user string) bool {
+	for _, account := range users {
+		if account.User == user {
+			return true
+		}
+	}
+	return false
+}
+
+// ANSWERS A MESSAGE THAT COULD NOT BE AUTHENTICATED OR AUTHORIZED WITH THE REASON IN THE error ATTRIBUTE
+func SendUnauthorized(msg *sqs.Message, reason error) error {
+	reply := NewAuthReply(msg, "UNAUTHORIZED")
+	reply.MessageAttributes["error"] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(reason.Error()),
+	}
+	return SendReply(reply)
+}
+
+// BUILDS THE REPLY TO msg, ADDRESSED TO THE SAME CLIENT, SESSION AND COMMAND
+func NewAuthReply(msg *sqs.Message, body string) *sqs.SendMessageInput {
+	return &sqs.SendMessageInput{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"clientName": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(*msg.MessageAttributes["clientName"].StringValue),
+			},
+			"sessionID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(*msg.MessageAttributes["sessionID"].StringValue),
+			},
+			"timestamp": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(*msg.MessageAttributes["timestamp"].StringValue),
+			},
+			"cmd": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(*msg.MessageAttributes["cmd"].StringValue),
+			},
+		},
+		MessageBody: aws.String(body),
+		QueueUrl:    &outboxURL,
+	}
+}
+
+func SendReply(msgTX *sqs.SendMessageInput) error {
+	result, err := sqssvc.SendMessage(msgTX)
+	if err != nil {
+		log.Errorf("Could not send message to SQS queue: %v", err)
+	} else {
+		log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+	}
+	return nil
+}
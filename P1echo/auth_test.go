@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	viper "github.com/theherk/viper"
+)
+
+func TestStoreUserAndCheckPassword(t *testing.T) {
+	viper.Set("auth.usersfile", filepath.Join(t.TempDir(), "users.json"))
+
+	if _, err := CheckPassword(nil, "alice", "secret"); err == nil {
+		t.Errorf("login accepted without any account")
+	}
+	if err := StoreUser("alice", "", nil); err == nil {
+		t.Errorf("account stored with an empty password")
+	}
+	for _, name := range []string{"bob_ann", "../alice", "alice.txt"} {
+		if err := StoreUser(name, "secret", nil); err == nil {
+			t.Errorf("account %q stored, its conversations would use the keys of another user", name)
+		}
+	}
+	if err := StoreUser("alice", "secret", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := StoreUser("bob", "hunter2", nil); err != nil {
+		t.Fatal(err)
+	}
+	users, _ := LoadUsers()
+	if claims, err := CheckPassword(users, "alice", "secret"); err != nil || claims.User != "alice" {
+		t.Errorf("CheckPassword(alice) = %+v, %v", claims, err)
+	}
+	if _, err := CheckPassword(users, "alice", "hunter2"); err == nil {
+		t.Errorf("password of bob accepted for alice")
+	}
+
+	// Storing an existing user changes its password instead of adding it twice
+	if err := StoreUser("alice", "changed", nil); err != nil {
+		t.Fatal(err)
+	}
+	users, _ = LoadUsers()
+	_, oldErr := CheckPassword(users, "alice", "secret")
+	_, newErr := CheckPassword(users, "alice", "changed")
+	if len(users) != 2 || oldErr == nil || newErr != nil {
+		t.Errorf("password change left %d accounts", len(users))
+	}
+	for _, account := range users {
+		if account.Hash == "changed" || account.Hash == "secret" {
+			t.Errorf("password stored in clear")
+		}
+	}
+}
+
+func TestSignAndVerifyToken(t *testing.T) {
+	tokenSecret = []byte("0123456789abcdef0123456789abcdef")
+	viper.Set("auth.tokenttlminutes", 5)
+	token, err := SignToken(NewTokenClaims(nil, "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := VerifyToken(token); err != nil || claims.User != "alice" {
+		t.Fatalf("VerifyToken() = %+v, %v", claims, err)
+	}
+
+	claims, _ := json.Marshal(TokenClaims{User: "admin", Expires: time.Now().Add(time.Hour).Unix()})
+	forged := base64.RawURLEncoding.EncodeToString(claims) + token[strings.Index(token, "."):]
+	expiredClaims, _ := json.Marshal(TokenClaims{User: "alice", Expires: time.Now().Add(-time.Minute).Unix()})
+	expiredPayload := base64.RawURLEncoding.EncodeToString(expiredClaims)
+	rejected := map[string]string{
+		"malformed":       "not-a-token",
+		"claims changed":  forged,
+		"expired":         expiredPayload + "." + tokenSignature(expiredPayload),
+		"not base64 json": "abc." + tokenSignature("abc"),
+	}
+	for name, bad := range rejected {
+		if claims, err := VerifyToken(bad); err == nil {
+			t.Errorf("%s token accepted for %q", name, claims.User)
+		}
+	}
+
+	// Another secret (another deployment) does not accept the token
+	tokenSecret = []byte("another secret of at least 32 bytes")
+	if _, err := VerifyToken(token); err == nil {
+		t.Errorf("token accepted with another secret")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	tokenSecret = []byte("0123456789abcdef0123456789abcdef")
+	viper.Set("auth.tokenttlminutes", 5)
+	token, _ := SignToken(NewTokenClaims(nil, "alice"))
+	msg := &sqs.Message{MessageAttributes: map[string]*sqs.MessageAttributeValue{}}
+	if _, err := Authenticate(msg); err == nil {
+		t.Errorf("message without a token authenticated")
+	}
+	msg.MessageAttributes["token"] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(token)}
+	if claims, err := Authenticate(msg); err != nil || claims.User != "alice" {
+		t.Errorf("Authenticate() = %+v, %v", claims, err)
+	}
+}
+
+func TestSharesAndRoles(t *testing.T) {
+	viper.Set("auth.usersfile", filepath.Join(t.TempDir(), "users.json"))
+	StoreUser("alice", "a", nil)
+	StoreUser("bob", "b", nil)
+	StoreUser("carol", "c", ParseRoles("auditor, wizard"))
+	if err := ShareConversation("alice", "bob", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ShareConversation("nobody", "bob", true); err == nil {
+		t.Errorf("conversation of a missing user shared")
+	}
+	if err := ShareConversation("alice", "alice", true); err == nil {
+		t.Errorf("conversation shared with its owner")
+	}
+	users, _ := LoadUsers()
+
+	bob := NewTokenClaims(users, "bob")
+	if !reflect.DeepEqual(bob.Shares, []string{"alice"}) || !bob.CanAccess("alice") || bob.CanAccess("carol") {
+		t.Errorf("claims of bob = %+v", bob)
+	}
+	carol := NewTokenClaims(users, "carol")
+	if !reflect.DeepEqual(carol.Roles, []string{RoleAuditor}) || !carol.CanAccess("alice") {
+		t.Errorf("claims of the auditor = %+v", carol)
+	}
+	if AccountCanAccess(users, "alice", "bob") {
+		t.Errorf("alice reads bob without a share")
+	}
+
+	// Stopping the share takes effect on the next login
+	ShareConversation("alice", "bob", false)
+	users, _ = LoadUsers()
+	if AccountCanAccess(users, "bob", "alice") {
+		t.Errorf("bob still reads alice after the share was removed")
+	}
+	if roles := ParseRoles("none"); roles == nil || len(roles) != 0 {
+		t.Errorf("ParseRoles(none) = %v, want an empty list", roles)
+	}
+	if ParseRoles("") != nil {
+		t.Errorf("ParseRoles(\"\") does not keep the current roles")
+	}
+}
+
+func TestAccountExists(t *testing.T) {
+	users := []UserAccount{{User: "alice"}, {User: "bob"}}
+	if !AccountExists(users, "bob") || AccountExists(users, "carol") {
+		t.Errorf("AccountExists() does not match the accounts %+v", users)
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	aws "github.com/aws/aws-sdk-go/aws"
 	session "github.com/aws/aws-sdk-go/aws/session"
@@ -24,14 +28,60 @@ var sess *session.Session = session.Must(session.NewSessionWithOptions(session.O
 	SharedConfigState: session.SharedConfigEnable,
 }))
 var sqssvc *sqs.SQS = sqs.New(sess)
+var s3svc *s3.S3 = s3.New(sess)
+
+// RETURNED BY ProcessRXMessage FOR MESSAGES LEFT IN THE INBOX FOR THE SEARCH APP
+var errOtherApp = errors.New("This message was not for the echo app.")
 
 func main() {
+	var addUser, roles, share, unshare, with, quota, quotaMessages, quotaStorage string
+	flag.StringVar(&addUser, "adduser", "", "Create the local account of this user (or change its password) and exit")
+	flag.StringVar(&roles, "roles", "", "Comma separated roles (admin, auditor) of the account created with -adduser, \"none\" removes them")
+	flag.StringVar(&share, "share", "", "Share the conversation of this user with the one in -with and exit")
+	flag.StringVar(&unshare, "unshare", "", "Stop sharing the conversation of this user with the one in -with and exit")
+	flag.StringVar(&with, "with", "", "User to share a conversation with (see -share and -unshare)")
+	flag.StringVar(&quota, "quota", "", "Set the daily quotas of this user (see -messages and -storage) and exit")
+	flag.StringVar(&quotaMessages, "messages", "", "Echo messages per day of the user in -quota, 0 for unlimited or \"default\"")
+	flag.StringVar(&quotaStorage, "storage", "", "Bytes stored per day by the user in -quota, 0 for unlimited or \"default\"")
+	flag.Parse()
+
 	initConfig() // Set config file, logs and queues URLs
 
+	// ACCOUNT MANAGEMENT
+	if addUser != "" {
+		err := AddUserFromConsole(addUser, ParseRoles(roles))
+		if err != nil {
+			log.Errorf("Could not store account %s: %v", addUser, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Account %s stored.\n", addUser)
+		return
+	} else if share != "" || unshare != "" {
+		err := ShareConversation(FirstNonEmpty(share, unshare), with, share != "")
+		if err != nil {
+			log.Errorf("Could not change the shares of %s: %v", FirstNonEmpty(share, unshare), err)
+			os.Exit(1)
+		}
+		fmt.Printf("Shares of %s updated. They apply from the next login of %s.\n", FirstNonEmpty(share, unshare), with)
+		return
+	} else if quota != "" {
+		err := SetUserQuota(quota, quotaMessages, quotaStorage)
+		if err != nil {
+			log.Errorf("Could not change the quotas of %s: %v", quota, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Quotas of %s updated.\n", quota)
+		return
+	}
+
+	StartHealthServer(viper.GetString("health.listen")) // Serve /healthz, /readyz and /metrics
+
 	// MAIN LOOP (RECEIVE, PROCESS AND DELETE IF PROCESSED, IF NOT GO BACK TO RECEIVE)
 	for {
+		Metrics.Polled()
 		RXmsg := &sqs.ReceiveMessageInput{
-			MessageAttributeNames: aws.StringSlice([]string{"clientName", "timestamp", "sessionID", "cmd"}),
+			MessageAttributeNames: aws.StringSlice([]string{"clientName", "timestamp", "sessionID", "cmd", "action", "target", "searchID", "token"}),
+			AttributeNames:        aws.StringSlice([]string{"SentTimestamp"}),
 			QueueUrl:              &inboxURL,
 			MaxNumberOfMessages:   aws.Int64(1),
 			WaitTimeSeconds:       aws.Int64(1),
@@ -47,8 +97,14 @@ func main() {
 			continue
 		}
 
+		start := time.Now()
+		cmd := Metrics.Received(resultRX.Messages[0])
 		err = ProcessRXMessage(resultRX.Messages[0])
-		if err != nil {
+		if err == errOtherApp {
+			Metrics.Inc(metricSkipped, cmd)
+			continue
+		} else if err != nil {
+			Metrics.Finished(resultRX.Messages[0], cmd, start, err)
 			continue
 		}
 
@@ -61,6 +117,7 @@ func main() {
 		if err != nil {
 			log.Errorf("Error when trying to delete message after processing: %v", err)
 		}
+		Metrics.Finished(resultRX.Messages[0], cmd, start, err)
 	}
 
 }
@@ -126,11 +183,29 @@ func initConfig() {
 	inboxURL = viper.GetString("sqs.inboxURL")
 	outboxURL = viper.GetString("sqs.outboxURL")
 
+	// SECRET SHARED WITH THE OTHER APPS TO SIGN AND VERIFY SESSION TOKENS
+	var err error
+	tokenSecret, err = ReadTokenSecret()
+	if err != nil {
+		log.Errorf("[INIT] %v", err)
+		os.Exit(1)
+	}
+
+	// RATE LIMITS PER USER AND COMMAND AND DAILY QUOTAS OF THE ECHO MESSAGES
+	Limits = NewRateLimiter(ReadRateLimits())
+	Quotas, err = LoadQuotaStore(viper.GetString("quota.usagefile"))
+	if err != nil {
+		log.Errorf("[INIT] %v", err)
+		os.Exit(1)
+	}
+
 	return
 }
 
-// CHECK IF MSG IS FOR ECHO APP, CHECK THAT IT'S NOT END, STORE IT IN S3 AND SEND IT BACK THROUGH OUTBOX QUEUE
-// RETURNS ERROR IF THE MESSAGE WAS NOT FOR THE ECHO APP
+// CHECK IF MSG IS FOR ECHO APP, CHECK THAT IT'S NOT END, STORE IT IN S3, NOTIFY THE SAVED SEARCHES IT MATCHES AND SEND IT BACK
+// THROUGH OUTBOX QUEUE. SAVED SEARCH MANAGEMENT (COMMAND 5) IS ALSO HANDLED HERE SINCE THE ECHO APP EVALUATES THEM, AND SO
+// IS LOGIN (COMMAND 8) SINCE THE ECHO APP KEEPS THE ACCOUNTS. EVERY OTHER COMMAND MUST CARRY A TOKEN OF THE USER IN
+// clientName. RETURNS ERROR IF THE MESSAGE WAS NOT FOR THE ECHO APP
 func ProcessRXMessage(msg *sqs.Message) error {
 	cmd := *msg.MessageAttributes["cmd"].StringValue
 	sessID := *msg.MessageAttributes["sessionID"].StringValue
@@ -138,12 +213,46 @@ func ProcessRXMessage(msg *sqs.Message) error {
 	timestamp := *msg.MessageAttributes["timestamp"].StringValue
 	log.Infof("New message received. Client: %s\tCommand: %s", clientName, cmd)
 	cRX, _ := strconv.Atoi(cmd)
+	var claims TokenClaims
+	if cRX == 1 || cRX == 5 {
+		var err error
+		claims, err = Authenticate(msg)
+		if err == nil && claims.User != clientName {
+			err = fmt.Errorf("Token of %s cannot be used as %s", claims.User, clientName)
+		}
+		if err != nil {
+			log.Warnf("Rejecting command %s of %s: %v", cmd, clientName, err)
+			return SendUnauthorized(msg, err)
+		}
+	}
+	// LOGINS AND RENEWALS ARE LIMITED BY THE USER THEY TRY, THEN BY THE USER AND THE SESSION THAT SENT THEM
+	if name := RateLimitName(cRX, msg); name != "" {
+		var allowed bool
+		var wait time.Duration
+		if name == "login" {
+			allowed, wait = Limits.AllowLogin(clientName, sessID)
+		} else {
+			allowed, wait = Limits.Allow(claims.User, name)
+		}
+		if !allowed {
+			log.Warnf("Rejecting command %s of %s: rate limit of %s reached", cmd, clientName, name)
+			return SendRejected(msg, RateLimitedBody, RateLimitMessage(name, wait), wait)
+		}
+	}
 	if cRX == 1 {
 		text := *msg.Body
 		if text == "END" {
 			log.Infof("End of conversation with %s", clientName)
 			return nil
+		} else if !ValidName(sessID) {
+			log.Warnf("Rejecting message of %s: invalid session ID %q", clientName, sessID)
+			return SendUnauthorized(msg, fmt.Errorf("Invalid session ID %q, it may only hold letters, digits and -", sessID))
 		} else {
+			size := int64(len(fmt.Sprintf("%s|||%s\n", timestamp, text)))
+			if err := Quotas.Check(clientName, size); err != nil {
+				log.Warnf("Rejecting message of %s: %v", clientName, err)
+				return SendRejected(msg, QuotaExceededBody, err.Error(), UntilTomorrow())
+			}
 			msgTX := &sqs.SendMessageInput{
 				MessageAttributes: map[string]*sqs.MessageAttributeValue{
 					"clientName": &sqs.MessageAttributeValue{
@@ -169,6 +278,9 @@ func ProcessRXMessage(msg *sqs.Message) error {
 			err := StoreNewLine(clientName, sessID, text, timestamp)
 			if err != nil {
 				log.Errorf("Could not upload conversation to S3: %v", err)
+			} else {
+				Quotas.Add(clientName, size)
+				NotifySavedSearches(clientName, sessID, text, timestamp)
 			}
 			DeleteTemporalConversation(clientName + "_" + sessID)
 			log.Infof("Echoing message. Client: %s\tContent: %s", clientName, text)
@@ -179,13 +291,17 @@ func ProcessRXMessage(msg *sqs.Message) error {
 				log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
 			}
 		}
+	} else if cRX == 5 { // SAVED SEARCHES
+		return ProcessSavedSearchMessage(msg, claims)
+	} else if cRX == 8 { // LOGIN
+		return ProcessLoginMessage(msg)
 	} else {
 		sqssvc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
 			QueueUrl:          &inboxURL,
 			ReceiptHandle:     msg.ReceiptHandle,
 			VisibilityTimeout: aws.Int64(0),
 		})
-		return fmt.Errorf("This message was not for the echo app.")
+		return errOtherApp
 	}
 	return nil
 }
@@ -214,8 +330,19 @@ func DownloadConversation(client string) error {
 	return nil
 }
 
+// USER NAMES AND SESSION IDS BECOME THE S3 KEY [S3_CONVERSATIONS_PATH]/[CLIENT]_[SESSION_ID].txt, SO THEY CANNOT
+// HOLD THE _ SEPARATING THEM NOR ANY / OR . THAT WOULD WRITE THE FILE OF ANOTHER USER OR OUTSIDE THE PATH
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
 // DOWNLOAD [CLIENT]_[SESSION_ID].txt FILE FROM S3, APPEND NEW LINE AND UPLOAD IT AGAIN TO S3
 func StoreNewLine(client string, sessID string, body string, timestamp string) error {
+	if !ValidName(client) || !ValidName(sessID) {
+		return fmt.Errorf("Invalid user name %q or session ID %q", client, sessID)
+	}
 	err := DownloadConversation(client + "_" + sessID)
 	if err != nil {
 		log.Warnf("Could not download document before adding new line: %v", err)
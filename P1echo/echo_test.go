@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestValidName(t *testing.T) {
+	names := map[string]bool{
+		"alice":        true,
+		"Ab12Cd":       true,
+		"team-lead-02": true,
+		"":             false,
+		"bob_ann":      false,
+		"../alice":     false,
+		"alice/2":      false,
+		"alice.txt":    false,
+		"alice bob":    false,
+		"álvaro":       false,
+	}
+	for name, want := range names {
+		if got := ValidName(name); got != want {
+			t.Errorf("ValidName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestStoreNewLineInvalidNames(t *testing.T) {
+	// Rejected before anything is downloaded, written or uploaded
+	for _, key := range [][2]string{{"alice", "../bob_Ab12Cd"}, {"bob_ann", "Ab12Cd"}, {"alice", ""}} {
+		if err := StoreNewLine(key[0], key[1], "hello", "10-Mar-2024 09:00:00"); err == nil {
+			t.Errorf("StoreNewLine(%q, %q) accepted", key[0], key[1])
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	viper "github.com/theherk/viper"
+)
+
+func TestAppMetricsWrite(t *testing.T) {
+	m := NewAppMetrics()
+	msg := &sqs.Message{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{"cmd": {DataType: aws.String("String"), StringValue: aws.String("1")}},
+		Attributes:        map[string]*string{"SentTimestamp": aws.String("0")},
+	}
+	cmd := m.Received(msg)
+	m.Finished(msg, cmd, time.Now(), nil)
+	m.Finished(msg, m.Received(&sqs.Message{}), time.Now(), errors.New("boom"))
+
+	var b bytes.Buffer
+	m.Write(&b)
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE p1_messages_received_total counter\n",
+		`p1_messages_received_total{cmd="1"} 1` + "\n",
+		`p1_messages_received_total{cmd="unknown"} 1` + "\n",
+		`p1_messages_processed_total{cmd="1"} 1` + "\n",
+		`p1_messages_failed_total{cmd="unknown"} 1` + "\n",
+		`p1_message_processing_seconds_bucket{cmd="1",le="+Inf"} 1` + "\n",
+		`p1_queue_lag_seconds_bucket{cmd="1",le="300"} 0` + "\n",
+		`p1_queue_lag_seconds_count{cmd="1"} 1` + "\n",
+		"p1_last_poll_timestamp_seconds ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricCmd(t *testing.T) {
+	for cmd, want := range map[string]string{"1": "1", "08": "8", "99": "99", "100": "unknown", "-1": "unknown", "": "unknown", "x": "unknown"} {
+		if got := MetricCmd(cmd); got != want {
+			t.Errorf("MetricCmd(%q) = %q, want %q", cmd, got, want)
+		}
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	viper.Set("health.stallseconds", 60)
+	defer viper.Reset()
+	Metrics = NewAppMetrics()
+
+	w := httptest.NewRecorder()
+	healthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok\n" {
+		t.Errorf("healthz after a poll = %d %q", w.Code, w.Body.String())
+	}
+
+	Metrics.lastPoll = time.Now().Add(-2 * time.Minute)
+	w = httptest.NewRecorder()
+	healthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("healthz of a stalled loop = %d", w.Code)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+// HELP TEXTS AND QUEUE OF THE METRICS OF THIS APP, THE REST IS IN health_shared.go
+
+const polledQueue = "inbox"
+
+var metricHelp = map[string]string{
+	metricReceived:  "Messages received from the inbox queue.",
+	metricProcessed: "Messages processed and deleted from the inbox queue.",
+	metricFailed:    "Messages whose processing or deletion failed.",
+	metricSkipped:   "Messages released back to the inbox queue because they were for another app.",
+	metricLatency:   "Time taken to process a message, deletion included.",
+	metricQueueLag:  "Time a processed message waited in the inbox queue before it was received.",
+}
+
+var histogramMetrics = []string{metricLatency, metricQueueLag}
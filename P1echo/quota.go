@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	viper "github.com/theherk/viper"
+)
+
+// DAILY QUOTAS OF A USER: ECHO MESSAGES STORED IN ITS CONVERSATION AND THE BYTES THEY ADD TO IT. 0 IS UNLIMITED
+type Quota struct {
+	Messages int64
+	Storage  int64
+}
+
+// WHAT A USER STORED ON Day (YYYY-MM-DD, LOCAL TIME)
+type QuotaUsage struct {
+	Day      string `json:"day"`
+	Messages int64  `json:"messages"`
+	Storage  int64  `json:"storageBytes"`
+}
+
+// DAILY USAGE OF EVERY USER. IT IS SAVED IN quota.usagefile AFTER EVERY CHANGE SO A RESTART DOES NOT RESET IT
+type QuotaStore struct {
+	mu    sync.Mutex
+	path  string
+	usage map[string]QuotaUsage
+}
+
+var Quotas *QuotaStore
+
+// READS THE USAGE SAVED IN path. A MISSING FILE MEANS NOTHING WAS STORED YET
+func LoadQuotaStore(path string) (*QuotaStore, error) {
+	q := &QuotaStore{path: path, usage: make(map[string]QuotaUsage)}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	} else if err != nil {
+		return q, fmt.Errorf("Could not read quota usage file %s: %v", path, err)
+	}
+	err = json.Unmarshal(b, &q.usage)
+	if err != nil {
+		return q, fmt.Errorf("Malformed quota usage file %s: %v", path, err)
+	}
+	return q, nil
+}
+
+func Today() string {
+	return time.Now().Format("2006-01-02")
+}
+
+// TIME LEFT UNTIL THE QUOTAS START OVER
+func UntilTomorrow() time.Duration {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()).Sub(now)
+}
+
+// QUOTA OF user: THE ONE SET IN ITS ACCOUNT WITH -quota OR ELSE quota.dailymessages AND quota.dailystoragebytes
+func UserQuota(user string) Quota {
+	quota := Quota{Messages: viper.GetInt64("quota.dailymessages"), Storage: viper.GetInt64("quota.dailystoragebytes")}
+	users, err := LoadUsers()
+	if err != nil {
+		log.Warnf("Using the default quota of %s: %v", user, err)
+		return quota
+	}
+	for _, account := range users {
+		if account.User == user {
+			if account.DailyMessages != nil {
+				quota.Messages = *account.DailyMessages
+			}
+			if account.DailyStorage != nil {
+				quota.Storage = *account.DailyStorage
+			}
+		}
+	}
+	return quota
+}
+
+// WHAT user STORED TODAY
+func (q *QuotaStore) Usage(user string) QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	usage := q.usage[user]
+	if usage.Day != Today() {
+		return QuotaUsage{Day: Today()}
+	}
+	return usage
+}
+
+// FAILS IF STORING ONE MORE MESSAGE OF size BYTES TODAY WOULD TAKE user OVER ITS QUOTA
+func (q *QuotaStore) Check(user string, size int64) error {
+	quota := UserQuota(user)
+	usage := q.Usage(user)
+	if quota.Messages > 0 && usage.Messages+1 > quota.Messages {
+		return fmt.Errorf("Daily quota of %d messages reached, it starts over at midnight", quota.Messages)
+	}
+	if quota.Storage > 0 && usage.Storage+size > quota.Storage {
+		return fmt.Errorf("Daily quota of %d bytes reached, it starts over at midnight", quota.Storage)
+	}
+	return nil
+}
+
+// COUNTS A MESSAGE OF size BYTES STORED BY user
+func (q *QuotaStore) Add(user string, size int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	usage := q.usage[user]
+	if usage.Day != Today() {
+		usage = QuotaUsage{Day: Today()}
+	}
+	usage.Messages++
+	usage.Storage += size
+	q.usage[user] = usage
+	if err := q.saveLocked(); err != nil {
+		log.Errorf("%v", err)
+	}
+}
+
+// WRITES THE USAGE OF TODAY, DROPPING THE ONE OF PAST DAYS
+func (q *QuotaStore) saveLocked() error {
+	today := Today()
+	for user, usage := range q.usage {
+		if usage.Day != today {
+			delete(q.usage, user)
+		}
+	}
+	b, err := json.MarshalIndent(q.usage, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Could not encode quota usage: %v", err)
+	}
+	err = ioutil.WriteFile(q.path+".tmp", b, 0600)
+	if err == nil {
+		err = os.Rename(q.path+".tmp", q.path)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not write quota usage file %s: %v", q.path, err)
+	}
+	return nil
+}
+
+// SETS THE DAILY QUOTAS OF THE ACCOUNT user (USED BY THE -quota FLAG). EACH VALUE IS A NUMBER (0 FOR UNLIMITED),
+// "default" TO GO BACK TO THE ONE OF THE CONFIG FILE OR "" TO KEEP THE CURRENT ONE
+func SetUserQuota(user string, messages string, storage string) error {
+	parse := func(value string, current *int64) (*int64, error) {
+		if value == "" {
+			return current, nil
+		} else if value == "default" {
+			return nil, nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid quota %q, write a number, 0 for unlimited or \"default\"", value)
+		}
+		return &n, nil
+	}
+	var parseErr error
+	err := UpdateUser(user, false, func(account *UserAccount) {
+		dailyMessages, err := parse(messages, account.DailyMessages)
+		if err != nil {
+			parseErr = err
+			return
+		}
+		dailyStorage, err := parse(storage, account.DailyStorage)
+		if err != nil {
+			parseErr = err
+			return
+		}
+		account.DailyMessages, account.DailyStorage = dailyMessages, dailyStorage
+	})
+	if parseErr != nil {
+		return parseErr
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	viper "github.com/theherk/viper"
+)
+
+func TestQuotaStore(t *testing.T) {
+	dir := t.TempDir()
+	viper.Set("auth.usersfile", filepath.Join(dir, "users.json"))
+	viper.Set("quota.dailymessages", 2)
+	viper.Set("quota.dailystoragebytes", 100)
+	defer viper.Reset()
+	path := filepath.Join(dir, "usage.json")
+	q, err := LoadQuotaStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Check("alice", 101); err == nil {
+		t.Errorf("message over the storage quota accepted")
+	}
+	q.Add("alice", 60)
+	q.Add("alice", 30)
+	if err := q.Check("alice", 1); err == nil {
+		t.Errorf("third message accepted with a quota of 2")
+	}
+	if err := q.Check("bob", 10); err != nil {
+		t.Errorf("quota of alice applied to bob: %v", err)
+	}
+
+	// The usage survives a restart and is forgotten the next day
+	restarted, err := LoadQuotaStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage := restarted.Usage("alice"); usage.Messages != 2 || usage.Storage != 90 || usage.Day != Today() {
+		t.Errorf("usage after a restart = %+v", usage)
+	}
+	restarted.usage["alice"] = QuotaUsage{Day: "2000-01-01", Messages: 2, Storage: 90}
+	if err := restarted.Check("alice", 1); err != nil {
+		t.Errorf("usage of a past day counted: %v", err)
+	}
+}
+
+func TestSetUserQuota(t *testing.T) {
+	viper.Set("auth.usersfile", filepath.Join(t.TempDir(), "users.json"))
+	viper.Set("quota.dailymessages", 5)
+	defer viper.Reset()
+	StoreUser("alice", "secret", nil)
+
+	if err := SetUserQuota("alice", "0", ""); err != nil {
+		t.Fatal(err)
+	}
+	if quota := UserQuota("alice"); quota.Messages != 0 {
+		t.Errorf("quota after -quota 0 = %+v, want unlimited", quota)
+	}
+	if err := SetUserQuota("alice", "default", "-3"); err == nil {
+		t.Errorf("negative quota accepted")
+	}
+	if err := SetUserQuota("alice", "default", ""); err != nil || UserQuota("alice").Messages != 5 {
+		t.Errorf("quota after going back to the default = %+v, %v", UserQuota("alice"), err)
+	}
+	if err := SetUserQuota("nobody", "1", ""); err == nil {
+		t.Errorf("quota set for a missing user")
+	}
+	if quota := UserQuota("bob"); quota.Messages != 5 {
+		t.Errorf("quota of a user without account = %+v, want the default", quota)
+	}
+}
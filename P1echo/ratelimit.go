@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// BODY OF THE REPLIES TO MESSAGES REJECTED BY A QUOTA, THOSE REJECTED BY A RATE LIMIT GET RateLimitedBody
+const QuotaExceededBody = "QUOTA EXCEEDED"
+
+// COMMANDS OF THIS APP WITH A RATE LIMIT, READ FROM ratelimit.[NAME].perminute AND ratelimit.[NAME].burst
+var rateLimitNames = []string{"echo", "savedsearches", "login", "loginuser"}
+
+// NAME OF THE RATE LIMIT OF A MESSAGE, "" IF IT HAS NONE. END IS NEVER LIMITED SO A SESSION CAN ALWAYS BE CLOSED
+func RateLimitName(cmd int, msg *sqs.Message) string {
+	if cmd == 1 && *msg.Body != "END" {
+		return "echo"
+	} else if cmd == 5 {
+		return "savedsearches"
+	} else if cmd == 8 { // Renewals too, the token they carry is only checked after the limit
+		return "login"
+	}
+	return ""
+}
+
+// ANSWERS A MESSAGE REJECTED BY A RATE LIMIT OR A QUOTA (body RateLimitedBody OR QuotaExceededBody) WITH THE REASON
+// IN THE error ATTRIBUTE AND THE SECONDS TO WAIT BEFORE TRYING AGAIN IN retryAfter
+func SendRejected(msg *sqs.Message, body string, reason string, wait time.Duration) error {
+	reply := NewAuthReply(msg, body)
+	reply.MessageAttributes["error"] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(reason),
+	}
+	reply.MessageAttributes["retryAfter"] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("Number"),
+		StringValue: aws.String(strconv.Itoa(RetrySeconds(wait))),
+	}
+	return SendReply(reply)
+}
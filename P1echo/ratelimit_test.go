@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	limits := map[string]RateLimit{
+		"echo":  {PerMinute: 60, Burst: 3},
+		"login": {PerMinute: 5, Burst: 1},
+	}
+	tests := []struct {
+		name     string
+		limit    string
+		before   int           // Requests sent first
+		elapsed  time.Duration // Time passed after them
+		after    int           // Requests sent then, the last one is checked
+		wantOK   bool
+		wantWait time.Duration
+	}{
+		{"within the burst", "echo", 0, 0, 3, true, 0},
+		{"burst used up", "echo", 3, 0, 1, false, time.Second},
+		{"refilled", "echo", 3, time.Second, 1, true, 0},
+		{"refill stops at the burst", "echo", 1, time.Hour, 4, false, time.Second},
+		{"slow limit", "login", 1, 0, 1, false, 12 * time.Second},
+		{"partly refilled", "login", 1, 6 * time.Second, 1, false, 6 * time.Second},
+		{"command without a limit", "stats", 100, 0, 1, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(limits)
+			for i := 0; i < tt.before; i++ {
+				l.Allow("alice", tt.limit)
+			}
+			// Moving the last refill back is the same as waiting
+			for _, b := range l.buckets {
+				b.last = b.last.Add(-tt.elapsed)
+			}
+			var ok bool
+			var wait time.Duration
+			for i := 0; i < tt.after; i++ {
+				ok, wait = l.Allow("alice", tt.limit)
+			}
+			if ok != tt.wantOK || (wait-tt.wantWait).Abs() > 10*time.Millisecond {
+				t.Errorf("Allow() = %v, %v, want %v, %v", ok, wait, tt.wantOK, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestRateLimiterKeys(t *testing.T) {
+	l := NewRateLimiter(map[string]RateLimit{"login": {PerMinute: 1, Burst: 1}, "loginuser": {PerMinute: 1, Burst: 2}})
+	l.AllowLogin("alice", "attacker")
+	tests := []struct {
+		name   string
+		user   string
+		source string
+		wantOK bool
+	}{
+		{"same user and sender", "alice", "attacker", false},
+		{"same user from another sender", "alice", "sess01", false},
+		{"another user from the same sender", "bob", "attacker", true},
+	}
+	for _, tt := range tests {
+		if ok, _ := l.AllowLogin(tt.user, tt.source); ok != tt.wantOK {
+			t.Errorf("%s: AllowLogin(%q, %q) = %v, want %v", tt.name, tt.user, tt.source, ok, tt.wantOK)
+		}
+	}
+	// A new sender for every guess still drains the bucket of the user
+	l = NewRateLimiter(map[string]RateLimit{"login": {PerMinute: 5, Burst: 5}, "loginuser": {PerMinute: 10, Burst: 10}})
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		if ok, _ := l.AllowLogin("alice", fmt.Sprintf("sess%02d", i)); ok {
+			allowed++
+		}
+	}
+	if allowed != 10 {
+		t.Errorf("%d logins of alice allowed from 50 senders, want 10", allowed)
+	}
+}
+
+func TestRateLimitName(t *testing.T) {
+	message := func(body string, token string) *sqs.Message {
+		msg := &sqs.Message{Body: aws.String(body), MessageAttributes: map[string]*sqs.MessageAttributeValue{}}
+		if token != "" {
+			msg.MessageAttributes["token"] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(token)}
+		}
+		return msg
+	}
+	tests := []struct {
+		name string
+		cmd  int
+		msg  *sqs.Message
+		want string
+	}{
+		{"echo", 1, message("hello", "t"), "echo"},
+		{"end of the conversation", 1, message("END", "t"), ""},
+		{"saved searches", 5, message("LIST", "t"), "savedsearches"},
+		{"login with a password", 8, message("secret", ""), "login"},
+		{"token renewal", 8, message("RENEW", "t"), "login"},
+		{"command without a limit", 3, message("", "t"), ""},
+	}
+	for _, tt := range tests {
+		if got := RateLimitName(tt.cmd, tt.msg); got != tt.want {
+			t.Errorf("%s: RateLimitName(%d) = %q, want %q", tt.name, tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestRetrySeconds(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want int
+	}{
+		{0, 1},
+		{300 * time.Millisecond, 1},
+		{time.Second, 1},
+		{1100 * time.Millisecond, 2},
+		{time.Minute, 60},
+	}
+	for _, tt := range tests {
+		if got := RetrySeconds(tt.wait); got != tt.want {
+			t.Errorf("RetrySeconds(%v) = %d, want %d", tt.wait, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	s3 "github.com/aws/aws-sdk-go/service/s3"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/sirupsen/logrus"
+	viper "github.com/theherk/viper"
+)
+
+// STANDING QUERY OF Owner OVER THE CONVERSATION OF Target. Owner IS NOTIFIED OF EVERY NEW LINE OF Target CONTAINING Query
+type SavedSearch struct {
+	ID      string `json:"id"`
+	Owner   string `json:"owner"`
+	Target  string `json:"target"`
+	Query   string `json:"query"`
+	Created string `json:"created"`
+}
+
+// TRUE IF THE LINE text OF THE CONVERSATION OF client CONTAINS THE QUERY, IGNORING CASE. SEARCHES SAVED BEFORE EMPTY
+// QUERIES WERE REJECTED WOULD MATCH EVERY LINE, THEY MATCH NONE
+func (search SavedSearch) Matches(client string, text string) bool {
+	if search.Target != client || strings.TrimSpace(search.Query) == "" {
+		return false
+	}
+	return strings.Contains(strings.ToLower(text), strings.ToLower(search.Query))
+}
+
+// IN MEMORY COPY OF THE SAVED SEARCHES OBJECT IN S3 AND THE ETAG IT WAS READ WITH
+var savedSearches []SavedSearch
+var savedSearchesETag string
+
+// RETURNS THE SAVED SEARCHES STORED IN S3, READING THEM AGAIN ONLY IF THE OBJECT CHANGED SINCE THE LAST TIME
+func LoadSavedSearches() ([]SavedSearch, error) {
+	bucketname := viper.GetString("s3.bucketname")
+	key := viper.GetString("savedsearches.key")
+	head, err := s3svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(bucketname), Key: aws.String(key)})
+	if err != nil {
+		// No saved searches registered yet
+		savedSearches, savedSearchesETag = nil, ""
+		return nil, nil
+	}
+	if head.ETag != nil && *head.ETag == savedSearchesETag {
+		return savedSearches, nil
+	}
+
+	obj, err := s3svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucketname), Key: aws.String(key)})
+	if err != nil {
+		return nil, fmt.Errorf("Could not download saved searches: %v", err)
+	}
+	defer obj.Body.Close()
+	b, err := ioutil.ReadAll(obj.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read saved searches: %v", err)
+	}
+	var searches []SavedSearch
+	err = json.Unmarshal(b, &searches)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed saved searches object %s: %v", key, err)
+	}
+	savedSearches = searches
+	savedSearchesETag = aws.StringValue(obj.ETag)
+	return savedSearches, nil
+}
+
+// UPLOADS THE SAVED SEARCHES TO S3, REPLACING THE PREVIOUS ONES
+func StoreSavedSearches(searches []SavedSearch) error {
+	b, err := json.Marshal(searches)
+	if err != nil {
+		return fmt.Errorf("Could not encode saved searches: %v", err)
+	}
+	out, err := s3svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(viper.GetString("s3.bucketname")),
+		Key:         aws.String(viper.GetString("savedsearches.key")),
+		ContentType: aws.String("application/json"),
+		Body:        bytes.NewReader(b),
+	})
+	if err != nil {
+		return fmt.Errorf("Could not upload saved searches: %v", err)
+	}
+	savedSearches = searches
+	savedSearchesETag = aws.StringValue(out.ETag)
+	return nil
+}
+
+// ADDS, DELETES OR LISTS THE SAVED SEARCHES OF THE USER IN clientName DEPENDING ON THE action ATTRIBUTE
+// AND ANSWERS WITH THE RESULTING LIST ([ID]|||[TARGET]|||[QUERY]|||[CREATED] SEPARATED BY ///). A SEARCH CAN ONLY
+// WATCH A CONVERSATION THE OWNER MAY READ AND NEEDS A QUERY, AN EMPTY ONE WOULD MATCH EVERY LINE. A REJECTED SEARCH
+// IS ANSWERED WITH THE LIST AND THE REASON IN THE error ATTRIBUTE
+func ProcessSavedSearchMessage(msg *sqs.Message, claims TokenClaims) error {
+	cmd := *msg.MessageAttributes["cmd"].StringValue
+	sessID := *msg.MessageAttributes["sessionID"].StringValue
+	owner := *msg.MessageAttributes["clientName"].StringValue
+	timestamp := *msg.MessageAttributes["timestamp"].StringValue
+	action := GetAttribute(msg, "action")
+	var rejected string
+
+	searches, err := LoadSavedSearches()
+	if err != nil {
+		return err
+	}
+	if action == "add" {
+		target := GetAttribute(msg, "target")
+		if target == "" {
+			target = owner
+		}
+		if !claims.CanAccess(target) {
+			log.Warnf("Rejecting saved search of %s: no access to the conversation of %s", owner, target)
+			return SendUnauthorized(msg, fmt.Errorf("User %s may not access the conversation of %s", owner, target))
+		}
+		if strings.TrimSpace(*msg.Body) == "" {
+			log.Warnf("Rejecting saved search of %s over %s: empty query", owner, target)
+			rejected = "The query of a saved search cannot be empty"
+		} else {
+			searches = append(searches, SavedSearch{
+				ID:      fmt.Sprintf("%s-%d", owner, time.Now().UnixNano()),
+				Owner:   owner,
+				Target:  target,
+				Query:   *msg.Body,
+				Created: timestamp,
+			})
+			err = StoreSavedSearches(searches)
+			if err != nil {
+				return err
+			}
+			log.Infof("Saved search added. Owner: %s\tTarget: %s\tQuery: %s", owner, target, *msg.Body)
+		}
+	} else if action == "delete" {
+		id := GetAttribute(msg, "searchID")
+		kept := make([]SavedSearch, 0, len(searches))
+		for _, search := range searches {
+			if search.ID != id || search.Owner != owner {
+				kept = append(kept, search)
+			}
+		}
+		if len(kept) != len(searches) {
+			err = StoreSavedSearches(kept)
+			if err != nil {
+				return err
+			}
+			log.Infof("Saved search %s deleted", id)
+		}
+		searches = kept
+	}
+
+	var list []string
+	for _, search := range searches {
+		if search.Owner == owner {
+			list = append(list, strings.Join([]string{search.ID, search.Target, search.Query, search.Created}, "|||"))
+		}
+	}
+	body := strings.Join(list, "///")
+	if body == "" {
+		body = "NO SAVED SEARCHES"
+	}
+
+	msgTX := &sqs.SendMessageInput{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"clientName": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(owner),
+			},
+			"sessionID": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(sessID),
+			},
+			"timestamp": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(timestamp),
+			},
+			"cmd": &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(cmd),
+			},
+		},
+		MessageBody: aws.String(body),
+		QueueUrl:    &outboxURL,
+	}
+	if rejected != "" {
+		msgTX.MessageAttributes["error"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(rejected),
+		}
+	}
+	result, err := sqssvc.SendMessage(msgTX)
+	if err != nil {
+		log.Errorf("Could not send message to SQS queue: %v", err)
+	} else {
+		log.Infof("Message sent to SQS. MessageID: %v", *result.MessageId)
+	}
+	return nil
+}
+
+// SENDS A NOTIFICATION (COMMAND 6) TO THE OWNER OF EVERY SAVED SEARCH OVER client MATCHED BY THE NEW LINE.
+// NOTIFICATIONS ARE ADDRESSED TO THE OWNER NAME INSTEAD OF A SESSION SO ANY OF ITS CLIENTS CAN PICK THEM UP.
+// OWNERS THAT LOST ACCESS TO THE CONVERSATION SINCE THEY SAVED THE SEARCH ARE NOT NOTIFIED
+func NotifySavedSearches(client string, sessID string, text string, timestamp string) {
+	searches, err := LoadSavedSearches()
+	if err != nil {
+		log.Errorf("Could not load saved searches: %v", err)
+		return
+	}
+	users, err := LoadUsers()
+	if err != nil {
+		log.Errorf("Could not load users: %v", err)
+		return
+	}
+	for _, search := range searches {
+		if !search.Matches(client, text) {
+			continue
+		}
+		if !AccountCanAccess(users, search.Owner, search.Target) {
+			log.Infof("Skipping saved search %s, %s may no longer read the conversation of %s", search.ID, search.Owner, search.Target)
+			continue
+		}
+		msgTX := &sqs.SendMessageInput{
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				"clientName": &sqs.MessageAttributeValue{
+					DataType:    aws.String("String"),
+					StringValue: aws.String(search.Owner),
+				},
+				"sessionID": &sqs.MessageAttributeValue{
+					DataType:    aws.String("String"),
+					StringValue: aws.String(sessID),
+				},
+				"timestamp": &sqs.MessageAttributeValue{
+					DataType:    aws.String("String"),
+					StringValue: aws.String(timestamp),
+				},
+				"cmd": &sqs.MessageAttributeValue{
+					DataType:    aws.String("String"),
+					StringValue: aws.String("6"),
+				},
+				"searchID": &sqs.MessageAttributeValue{
+					DataType:    aws.String("String"),
+					StringValue: aws.String(search.ID),
+				},
+				"target": &sqs.MessageAttributeValue{
+					DataType:    aws.String("String"),
+					StringValue: aws.String(search.Target),
+				},
+				"query": &sqs.MessageAttributeValue{
+					DataType:    aws.String("String"),
+					StringValue: aws.String(search.Query),
+				},
+			},
+			MessageBody: aws.String(timestamp + "|||" + text),
+			QueueUrl:    &outboxURL,
+		}
+		result, err := sqssvc.SendMessage(msgTX)
+		if err != nil {
+			log.Errorf("Could not send notification to SQS queue: %v", err)
+		} else {
+			log.Infof("Saved search %s matched, notifying %s. MessageID: %v", search.ID, search.Owner, *result.MessageId)
+		}
+	}
+}
+
+// RETURNS THE STRING VALUE OF AN OPTIONAL MESSAGE ATTRIBUTE OR "" IF THE MESSAGE DOES NOT CARRY IT
+func GetAttribute(msg *sqs.Message, name string) string {
+	attr, ok := msg.MessageAttributes[name]
+	if !ok || attr.StringValue == nil {
+		return ""
+	}
+	return *attr.StringValue
+}
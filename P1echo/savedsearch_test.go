@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestSavedSearchMatches(t *testing.T) {
+	tests := []struct {
+		search SavedSearch
+		client string
+		text   string
+		want   bool
+	}{
+		{SavedSearch{Target: "bob", Query: "Invoice"}, "bob", "the invoice is late", true},
+		{SavedSearch{Target: "bob", Query: "invoice"}, "carol", "the invoice is late", false},
+		{SavedSearch{Target: "bob", Query: "invoice"}, "bob", "nothing to see", false},
+		{SavedSearch{Target: "bob", Query: ""}, "bob", "any line", false},
+		{SavedSearch{Target: "bob", Query: "  "}, "bob", "any  line", false},
+	}
+	for _, tt := range tests {
+		if got := tt.search.Matches(tt.client, tt.text); got != tt.want {
+			t.Errorf("%+v.Matches(%q, %q) = %v, want %v", tt.search, tt.client, tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	viper "github.com/theherk/viper"
+)
+
+func TestReadTokenSecret(t *testing.T) {
+	strong := strings.Repeat("k", 32)
+	tests := []struct {
+		name    string
+		env     string
+		config  string
+		want    string
+		wantErr bool
+	}{
+		{"from the config", "", strong, strong, false},
+		{"environment first", strong + "env", strong, strong + "env", false},
+		{"empty", "", "", "", true},
+		{"placeholder", "", "change-this-shared-secret", "", true},
+		{"too short", "", strong[:31], "", true},
+		{"short environment", "short", strong, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("P1_AUTH_SECRET", tt.env)
+			viper.Set("auth.secret", tt.config)
+			defer viper.Reset()
+			secret, err := ReadTokenSecret()
+			if (err != nil) != tt.wantErr || string(secret) != tt.want {
+				t.Errorf("ReadTokenSecret() = %q, %v, want %q", secret, err, tt.want)
+			}
+		})
+	}
+}
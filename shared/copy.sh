@@ -0,0 +1,13 @@
+#!/bin/sh
+# COPIES THE SHARED FILES GIVEN AS ARGUMENTS INTO THE APP OF THE CURRENT DIRECTORY AS [NAME]_shared.go. RUN BY
+# go generate IN EVERY APP, THE FIRST TWO LINES OF EACH FILE (ITS //go:build ignore) ARE LEFT OUT
+set -e
+dir=$(dirname "$0")
+for f in "$@"; do
+	out="${f%.go}_shared.go"
+	{
+		echo "// Code generated by shared/copy.sh from shared/$f. DO NOT EDIT."
+		echo
+		tail -n +3 "$dir/$f"
+	} >"$out"
+done
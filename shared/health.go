@@ -0,0 +1,328 @@
+//go:build ignore
+
+// HEALTH CHECKS AND PROMETHEUS METRICS OF THE APPS THAT POLL A QUEUE. EACH APP DECLARES metricHelp,
+// histogramMetrics AND polledQueue, WHAT DIFFERS BETWEEN THEM
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	aws "github.com/aws/aws-sdk-go/aws"
+	s3 "github.com/aws/aws-sdk-go/service/s3"
+	sqs "github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/sirupsen/logrus"
+	viper "github.com/theherk/viper"
+)
+
+const (
+	metricReceived  = "p1_messages_received_total"
+	metricProcessed = "p1_messages_processed_total"
+	metricFailed    = "p1_messages_failed_total"
+	metricSkipped   = "p1_messages_skipped_total"
+	metricLatency   = "p1_message_processing_seconds"
+	metricQueueLag  = "p1_queue_lag_seconds"
+)
+
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+var lagBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// THE COUNT OF OBSERVATIONS OF A PROMETHEUS HISTOGRAM. Counts[i] HOLDS THE ONES <= Bounds[i] THAT ARE ABOVE THE
+// PREVIOUS BOUND, THEY ARE ADDED UP WHEN WRITTEN
+type Histogram struct {
+	Bounds []float64
+	Counts []uint64
+	Sum    float64
+	Count  uint64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{Bounds: bounds, Counts: make([]uint64, len(bounds))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.Sum += v
+	h.Count++
+	if i := sort.SearchFloat64s(h.Bounds, v); i < len(h.Bounds) {
+		h.Counts[i]++
+	}
+}
+
+// COUNTERS AND HISTOGRAMS OF THE MESSAGES OF THE APP BY COMMAND NUMBER, SERVED ON /metrics IN THE PROMETHEUS TEXT
+// FORMAT. IT ALSO KEEPS WHEN THE APP LAST POLLED ITS QUEUE, WHICH /healthz CHECKS
+type AppMetrics struct {
+	mu         sync.Mutex
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*Histogram
+	lastPoll   time.Time
+}
+
+var Metrics = NewAppMetrics()
+
+func NewAppMetrics() *AppMetrics {
+	return &AppMetrics{
+		counters:   make(map[string]map[string]float64),
+		histograms: make(map[string]map[string]*Histogram),
+		lastPoll:   time.Now(),
+	}
+}
+
+func (m *AppMetrics) Inc(name string, cmd string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counters[name] == nil {
+		m.counters[name] = make(map[string]float64)
+	}
+	m.counters[name][cmd]++
+}
+
+func (m *AppMetrics) Observe(name string, cmd string, bounds []float64, v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.histograms[name] == nil {
+		m.histograms[name] = make(map[string]*Histogram)
+	}
+	h, ok := m.histograms[name][cmd]
+	if !ok {
+		h = NewHistogram(bounds)
+		m.histograms[name][cmd] = h
+	}
+	h.Observe(v)
+}
+
+// CALLED EACH TIME THE APP POLLS ITS QUEUE
+func (m *AppMetrics) Polled() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastPoll = time.Now()
+}
+
+func (m *AppMetrics) LastPoll() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastPoll
+}
+
+// COUNTS A MESSAGE TAKEN FROM THE QUEUE AND RETURNS THE cmd LABEL OF ITS SERIES
+func (m *AppMetrics) Received(msg *sqs.Message) string {
+	cmd := MetricCmd(GetAttribute(msg, "cmd"))
+	m.Inc(metricReceived, cmd)
+	return cmd
+}
+
+// RECORDS THE OUTCOME OF A MESSAGE OF THIS APP, HOW LONG IT TOOK SINCE start AND HOW LONG IT WAITED IN THE QUEUE
+func (m *AppMetrics) Finished(msg *sqs.Message, cmd string, start time.Time, err error) {
+	if err != nil {
+		m.Inc(metricFailed, cmd)
+	} else {
+		m.Inc(metricProcessed, cmd)
+	}
+	m.Observe(metricLatency, cmd, latencyBuckets, time.Since(start).Seconds())
+	if sent, ok := msg.Attributes["SentTimestamp"]; ok && sent != nil {
+		if ms, err := strconv.ParseInt(*sent, 10, 64); err == nil {
+			lag := start.Sub(time.Unix(0, ms*int64(time.Millisecond))).Seconds()
+			if lag < 0 {
+				lag = 0
+			}
+			m.Observe(metricQueueLag, cmd, lagBuckets, lag)
+		}
+	}
+}
+
+// WRITES EVERY SERIES IN THE PROMETHEUS TEXT EXPOSITION FORMAT
+func (m *AppMetrics) Write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range []string{metricReceived, metricProcessed, metricFailed, metricSkipped} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, metricHelp[name], name)
+		for _, cmd := range sortedKeys(m.counters[name]) {
+			fmt.Fprintf(w, "%s{cmd=%q} %s\n", name, cmd, FormatMetric(m.counters[name][cmd]))
+		}
+	}
+	for _, name := range histogramMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, metricHelp[name], name)
+		cmds := make([]string, 0, len(m.histograms[name]))
+		for cmd := range m.histograms[name] {
+			cmds = append(cmds, cmd)
+		}
+		sort.Strings(cmds)
+		for _, cmd := range cmds {
+			h := m.histograms[name][cmd]
+			var cumulative uint64
+			for i, bound := range h.Bounds {
+				cumulative += h.Counts[i]
+				fmt.Fprintf(w, "%s_bucket{cmd=%q,le=%q} %d\n", name, cmd, FormatMetric(bound), cumulative)
+			}
+			fmt.Fprintf(w, "%s_bucket{cmd=%q,le=\"+Inf\"} %d\n", name, cmd, h.Count)
+			fmt.Fprintf(w, "%s_sum{cmd=%q} %s\n", name, cmd, FormatMetric(h.Sum))
+			fmt.Fprintf(w, "%s_count{cmd=%q} %d\n", name, cmd, h.Count)
+		}
+	}
+	fmt.Fprintf(w, "# HELP p1_last_poll_timestamp_seconds Last time the app polled the %s queue.\n", polledQueue)
+	fmt.Fprintf(w, "# TYPE p1_last_poll_timestamp_seconds gauge\n")
+	fmt.Fprintf(w, "p1_last_poll_timestamp_seconds %s\n", FormatMetric(float64(m.lastPoll.UnixNano())/1e9))
+}
+
+// LABEL VALUE OF A COMMAND NUMBER. ANYTHING ELSE SENT BY A CLIENT IS "unknown" SO IT CANNOT ADD SERIES AT WILL
+func MetricCmd(cmd string) string {
+	c, err := strconv.Atoi(cmd)
+	if err != nil || c < 0 || c > 99 {
+		return "unknown"
+	}
+	return strconv.Itoa(c)
+}
+
+func FormatMetric(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MESSAGES WAITING (visible), BEING PROCESSED (inflight) AND DELAYED (delayed) IN THE QUEUE, AS ESTIMATED BY SQS
+func QueueDepth(ctx context.Context, queueURL string) (map[string]int64, error) {
+	out, err := sqssvc.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(queueURL),
+		AttributeNames: aws.StringSlice([]string{"ApproximateNumberOfMessages", "ApproximateNumberOfMessagesNotVisible", "ApproximateNumberOfMessagesDelayed"}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Could not get attributes of queue %s: %v", queueURL, err)
+	}
+	depth := make(map[string]int64)
+	for state, attribute := range map[string]string{
+		"visible":  "ApproximateNumberOfMessages",
+		"inflight": "ApproximateNumberOfMessagesNotVisible",
+		"delayed":  "ApproximateNumberOfMessagesDelayed",
+	} {
+		if v, ok := out.Attributes[attribute]; ok && v != nil {
+			depth[state], _ = strconv.ParseInt(*v, 10, 64)
+		}
+	}
+	return depth, nil
+}
+
+// CHECKS THAT THE INBOX AND OUTBOX QUEUES AND THE BUCKET ANSWER. RETURNS "ok" OR THE ERROR OF EACH CHECK
+func ReadinessChecks(ctx context.Context) (map[string]string, bool) {
+	checks := make(map[string]string)
+	ready := true
+	for name, queueURL := range map[string]string{"inbox": inboxURL, "outbox": outboxURL} {
+		if _, err := QueueDepth(ctx, queueURL); err != nil {
+			checks[name], ready = err.Error(), false
+		} else {
+			checks[name] = "ok"
+		}
+	}
+	bucket := viper.GetString("s3.bucketname")
+	if _, err := s3.New(sess).HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		checks["bucket"], ready = fmt.Sprintf("Could not reach bucket %s: %v", bucket, err), false
+	} else {
+		checks["bucket"] = "ok"
+	}
+	return checks, ready
+}
+
+func healthTimeout() time.Duration {
+	if n := viper.GetInt("health.checktimeoutseconds"); n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	return 5 * time.Second
+}
+
+// LIVENESS: FAILS WHEN THE APP HAS NOT POLLED ITS QUEUE FOR health.stallseconds (STUCK ON A MESSAGE)
+func healthz(w http.ResponseWriter, r *http.Request) {
+	stall := time.Duration(viper.GetInt("health.stallseconds")) * time.Second
+	if stall <= 0 {
+		stall = 120 * time.Second
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if since := time.Since(Metrics.LastPoll()); since > stall {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "the app has not polled the %s queue for %s\n", polledQueue, since.Truncate(time.Second))
+		return
+	}
+	fmt.Fprint(w, "ok\n")
+}
+
+// READINESS: FAILS WHEN THE QUEUES OR THE BUCKET CANNOT BE REACHED
+func readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout())
+	defer cancel()
+	checks, ready := ReadinessChecks(ctx)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ready {
+		log.Warnf("Not ready: %v", checks)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s: %s\n", name, checks[name])
+	}
+}
+
+// PROMETHEUS METRICS OF THE MESSAGES AND THE DEPTH OF THE QUEUES
+func metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	Metrics.Write(w)
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout())
+	defer cancel()
+	var lines []string
+	for _, queue := range []string{"inbox", "outbox"} {
+		queueURL := inboxURL
+		if queue == "outbox" {
+			queueURL = outboxURL
+		}
+		depth, err := QueueDepth(ctx, queueURL)
+		if err != nil {
+			log.Warnf("Could not read depth of the %s queue: %v", queue, err)
+			continue
+		}
+		for _, state := range []string{"visible", "inflight", "delayed"} {
+			lines = append(lines, fmt.Sprintf("p1_queue_messages{queue=%q,state=%q} %d", queue, state, depth[state]))
+		}
+	}
+	fmt.Fprint(w, "# HELP p1_queue_messages Approximate number of messages in the queue by state.\n# TYPE p1_queue_messages gauge\n")
+	if len(lines) > 0 {
+		fmt.Fprintln(w, strings.Join(lines, "\n"))
+	}
+}
+
+// SERVES /healthz, /readyz AND /metrics ON health.listen. AN EMPTY ADDRESS TURNS THEM OFF
+func StartHealthServer(addr string) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/readyz", readyz)
+	mux.HandleFunc("/metrics", metrics)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	go func() {
+		log.Infof("[INIT] Health and metrics listening on http://%s", addr)
+		if err := srv.ListenAndServe(); err != nil {
+			log.Errorf("Health and metrics server stopped: %v", err)
+		}
+	}()
+}
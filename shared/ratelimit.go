@@ -0,0 +1,134 @@
+//go:build ignore
+
+// TOKEN BUCKET RATE LIMITS, APPLIED BY THE WORKERS AND CHECKED TOO BY THE CLIENTS BEFORE SENDING. EACH APP DECLARES
+// rateLimitNames, THE COMMANDS IT LIMITS
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	viper "github.com/theherk/viper"
+)
+
+// BODY OF THE REPLIES TO MESSAGES REJECTED BY A RATE LIMIT
+const RateLimitedBody = "RATE LIMITED"
+
+// TOKENS ADDED PER MINUTE TO THE BUCKET OF EVERY USER AND HOW MANY IT HOLDS AT MOST
+type RateLimit struct {
+	PerMinute float64
+	Burst     float64
+}
+
+type bucketKey struct {
+	User string
+	Name string
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// TOKEN BUCKET RATE LIMITS PER USER AND COMMAND. EVERY REQUEST TAKES A TOKEN FROM THE BUCKET OF ITS USER AND
+// COMMAND, WHICH REFILLS AT PerMinute UP TO Burst. COMMANDS WITHOUT A LIMIT ARE NEVER REJECTED
+type RateLimiter struct {
+	mu      sync.Mutex
+	limits  map[string]RateLimit
+	buckets map[bucketKey]*tokenBucket
+	pruned  time.Time
+}
+
+var Limits = NewRateLimiter(nil)
+
+func NewRateLimiter(limits map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limits:  limits,
+		buckets: make(map[bucketKey]*tokenBucket),
+		pruned:  time.Now(),
+	}
+}
+
+// READS THE LIMITS OF rateLimitNames. A COMMAND WITHOUT perminute IS NOT LIMITED AND ONE WITHOUT burst GETS ONE
+// MINUTE OF TOKENS
+func ReadRateLimits() map[string]RateLimit {
+	limits := make(map[string]RateLimit)
+	for _, name := range rateLimitNames {
+		perMinute := viper.GetFloat64("ratelimit." + name + ".perminute")
+		if perMinute <= 0 {
+			continue
+		}
+		burst := viper.GetFloat64("ratelimit." + name + ".burst")
+		if burst < 1 {
+			burst = math.Max(1, perMinute)
+		}
+		limits[name] = RateLimit{PerMinute: perMinute, Burst: burst}
+	}
+	return limits
+}
+
+// TAKES A TOKEN FROM THE BUCKET OF user AND name. WITHOUT TOKENS LEFT RETURNS FALSE AND THE TIME UNTIL THE NEXT ONE
+func (l *RateLimiter) Allow(user string, name string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit, ok := l.limits[name]
+	if !ok {
+		return true, 0
+	}
+	now := time.Now()
+	l.pruneLocked(now)
+	key := bucketKey{User: user, Name: name}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: limit.Burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(limit.Burst, b.tokens+now.Sub(b.last).Minutes()*limit.PerMinute)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / limit.PerMinute * float64(time.Minute))
+}
+
+// ONCE A MINUTE FORGETS THE BUCKETS THAT ARE FULL AGAIN, THEY WOULD BE CREATED FULL ANYWAY
+func (l *RateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	l.pruned = now
+	for key, b := range l.buckets {
+		limit := l.limits[key.Name]
+		if b.tokens+now.Sub(b.last).Minutes()*limit.PerMinute >= limit.Burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func RateLimitMessage(name string, wait time.Duration) string {
+	return fmt.Sprintf("Too many %s requests, try again in %d seconds", name, RetrySeconds(wait))
+}
+
+// WHOLE SECONDS TO WAIT, AT LEAST ONE
+func RetrySeconds(wait time.Duration) int {
+	return int(math.Max(1, math.Ceil(wait.Seconds())))
+}
+
+// KEY OF THE LOGIN BUCKET OF user TRIED FROM source (A WEB ADDRESS OR THE SESSION OF A CLIENT). KEYING IT ON THE USER
+// ALONE WOULD LET ANYONE LOCK THE USER OUT BY SENDING WRONG PASSWORDS
+func LoginKey(user string, source string) string {
+	return user + "@" + source
+}
+
+// TAKES A LOGIN TOKEN OF user (ratelimit.loginuser) AND THEN ONE OF user FROM source (ratelimit.login). THE SOURCE IS
+// CHOSEN BY THE SENDER, SO ONLY THE BUCKET OF THE USER BOUNDS THE PASSWORDS THAT CAN BE TRIED FOR AN ACCOUNT
+func (l *RateLimiter) AllowLogin(user string, source string) (bool, time.Duration) {
+	if allowed, wait := l.Allow(user, "loginuser"); !allowed {
+		return false, wait
+	}
+	return l.Allow(LoginKey(user, source), "login")
+}
@@ -0,0 +1,37 @@
+//go:build ignore
+
+// SECRET SHARED BY THE APPS TO SIGN AND VERIFY SESSION TOKENS
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	viper "github.com/theherk/viper"
+)
+
+// ENVIRONMENT VARIABLE READ BEFORE auth.secret, SO THE SECRET NEED NOT BE WRITTEN IN THE CONFIG FILE
+const secretEnv = "P1_AUTH_SECRET"
+
+// SECRET SHIPPED IN THE CONFIG FILES OF EARLIER VERSIONS, EVERY COPY OF THE REPOSITORY KNOWS IT
+const placeholderSecret = "change-this-shared-secret"
+
+const minSecretBytes = 32
+
+// RETURNS THE TOKEN SECRET OF P1_AUTH_SECRET, OR OF auth.secret IF THE VARIABLE IS NOT SET. ANYONE KNOWING THE SECRET
+// CAN SIGN A TOKEN FOR ANY USER, SO AN EMPTY ONE, THE OLD PLACEHOLDER OR ONE SHORTER THAN 32 BYTES IS AN ERROR
+func ReadTokenSecret() ([]byte, error) {
+	secret := os.Getenv(secretEnv)
+	if secret == "" {
+		secret = viper.GetString("auth.secret")
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("auth.secret is not set, set it in the config file or in %s", secretEnv)
+	} else if secret == placeholderSecret {
+		return nil, fmt.Errorf("auth.secret is still the placeholder of the example config, generate a random one")
+	} else if len(secret) < minSecretBytes {
+		return nil, fmt.Errorf("auth.secret is %d bytes long, it needs at least %d", len(secret), minSecretBytes)
+	}
+	return []byte(secret), nil
+}